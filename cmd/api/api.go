@@ -6,6 +6,11 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/seanhalberthal/webmart/docs"
+	"github.com/seanhalberthal/webmart/internal/auth"
+	"github.com/seanhalberthal/webmart/internal/blobstore"
+	"github.com/seanhalberthal/webmart/internal/mailer"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/payments"
 	"github.com/seanhalberthal/webmart/internal/store"
 	httpSwagger "github.com/swaggo/http-swagger/v2" // http-swagger middleware
 	"go.uber.org/zap"
@@ -14,16 +19,92 @@ import (
 )
 
 type application struct {
-	config config
-	store  store.Storage
-	logger *zap.SugaredLogger
+	config        config
+	store         store.Storage
+	logger        *zap.SugaredLogger
+	authenticator auth.Authenticator
+	mailer        mailer.Client
+	payments      payments.Gateway
+	blobs         blobstore.Store
 }
 
 type config struct {
-	addr   string
-	apiURL string
-	db     dbConfig
-	env    string
+	addr         string
+	apiURL       string
+	frontendURL  string
+	db           dbConfig
+	env          string
+	auth         authConfig
+	mail         mailConfig
+	payments     paymentsConfig
+	currency     currencyConfig
+	blob         blobConfig
+	images       imagesConfig
+	reservations reservationsConfig
+	warehouses   warehousesConfig
+}
+
+type warehousesConfig struct {
+	// strategy picks the warehouses that checkout and committed
+	// reservations take stock from.
+	strategy store.AllocationStrategy
+}
+
+type blobConfig struct {
+	// driver is local or s3.
+	driver string
+	// dir and baseURL configure the local driver. baseURL is where the API
+	// serves the blobs from.
+	dir     string
+	baseURL string
+	s3      blobstore.S3Config
+}
+
+type imagesConfig struct {
+	// maxBytes is the largest accepted image file.
+	maxBytes int64
+	// maxPixels caps width times height, so that small files cannot
+	// decode into huge images.
+	maxPixels     int
+	maxPerProduct int
+}
+
+type currencyConfig struct {
+	// rounding is how converted prices are rounded to the target currency's
+	// minor unit.
+	rounding money.RoundingMode
+}
+
+type paymentsConfig struct {
+	provider      string
+	webhookSecret string
+	// webhookURL is where the fake provider delivers its webhook events.
+	webhookURL string
+	// delay is how long the fake provider takes to confirm delayed payments.
+	delay time.Duration
+	// tolerance is how far a webhook signature's timestamp may be from now.
+	tolerance time.Duration
+	// returnRetryInterval is how often voids and refunds the gateway has not
+	// yet made are tried again.
+	returnRetryInterval time.Duration
+}
+
+type mailConfig struct {
+	driver    string
+	fromEmail string
+	dir       string
+	exp       time.Duration
+}
+
+type authConfig struct {
+	token tokenConfig
+}
+
+type tokenConfig struct {
+	secret string
+	exp    time.Duration
+	iss    string
+	aud    string
 }
 
 type dbConfig struct {
@@ -38,8 +119,9 @@ func (app *application) routes() http.Handler {
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173"}, // React frontend
-		AllowedMethods:   []string{"GET", "POST", "DELETE", "PATCH"},
-		AllowedHeaders:   []string{"Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "If-Match", AcceptCurrencyHeader},
+		ExposedHeaders:   []string{"ETag"},
 		AllowCredentials: true,
 	}))
 
@@ -63,30 +145,148 @@ func (app *application) routes() http.Handler {
 			httpSwagger.URL(docsURL)))
 
 		r.Route("/products", func(r chi.Router) {
-			r.Post("/", app.createProductHandler)
+			r.With(app.AuthTokenMiddleware, app.RequirePermission(store.PermProductsWrite)).Post("/", app.createProductHandler)
 			r.Get("/", app.getAllProductsHandler)
+			r.Get("/search", app.searchProductsHandler)
 
 			r.Route("/{productID}", func(r chi.Router) {
 				r.Get("/", app.getProductHandler)
-				r.Delete("/", app.deleteProductHandler)
-				r.Patch("/", app.updateProductHandler)
+				r.With(app.AuthTokenMiddleware, app.RequirePermission(store.PermOrdersWrite)).Post("/reservations", app.createReservationHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermProductsWrite))
+
+					r.Delete("/", app.deleteProductHandler)
+					r.Patch("/", app.updateProductHandler)
+					r.Post("/images", app.uploadProductImagesHandler)
+					r.Delete("/images/{imageID}", app.deleteProductImageHandler)
+					r.Post("/variants", app.createProductVariantHandler)
+					r.Patch("/variants/{variantID}", app.updateProductVariantHandler)
+					r.Delete("/variants/{variantID}", app.deleteProductVariantHandler)
+					r.Post("/inventory/adjustments", app.createInventoryAdjustmentHandler)
+					r.Get("/inventory/movements", app.getInventoryMovementsHandler)
+					r.Post("/inventory/allocations", app.createStockAllocationHandler)
+					r.Post("/inventory/transfers", app.createStockTransferHandler)
+					r.Put("/categories", app.setProductCategoriesHandler)
+				})
+
+				r.Route("/reviews", func(r chi.Router) {
+					r.Get("/", app.getProductReviewsHandler)
+					r.With(app.AuthTokenMiddleware, app.RequirePermission(store.PermReviewsWrite)).Post("/", app.createReviewHandler)
+
+					r.Route("/{reviewID}", func(r chi.Router) {
+						r.Use(app.AuthTokenMiddleware)
+
+						r.With(app.RequirePermission(store.PermReviewsWrite)).Patch("/", app.updateReviewHandler)
+						r.Delete("/", app.deleteReviewHandler)
+					})
+				})
+			})
+		})
+
+		r.Route("/categories", func(r chi.Router) {
+			r.Get("/", app.getCategoriesHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermCategoriesManage))
+
+				r.Post("/", app.createCategoryHandler)
+				r.Patch("/{categoryID}", app.updateCategoryHandler)
+				r.Delete("/{categoryID}", app.deleteCategoryHandler)
+			})
+		})
+
+		r.Route("/warehouses", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermProductsWrite))
+
+			r.Get("/", app.getWarehousesHandler)
+			r.Post("/", app.createWarehouseHandler)
+			r.Patch("/{warehouseID}", app.updateWarehouseHandler)
+			r.Delete("/{warehouseID}", app.deleteWarehouseHandler)
+		})
+
+		r.Route("/promotions", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+
+			r.With(app.RequirePermission(store.PermOrdersWrite)).Post("/evaluate", app.evaluatePromotionsHandler)
+			r.With(app.RequirePermission(store.PermOrdersWrite)).Post("/redeem", app.redeemPromotionsHandler)
 
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(store.PermPromotionsManage))
+
+				r.Get("/", app.getPromotionsHandler)
+				r.Post("/", app.createPromotionHandler)
+				r.Get("/{promotionID}", app.getPromotionHandler)
+				r.Patch("/{promotionID}", app.updatePromotionHandler)
+				r.Delete("/{promotionID}", app.deletePromotionHandler)
 			})
 		})
 
+		r.Route("/tax", func(r chi.Router) {
+			r.Post("/quote", app.createTaxQuoteHandler)
+			r.Get("/rates", app.getTaxRatesHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermTaxManage))
+
+				r.Post("/rates", app.createTaxRateHandler)
+				r.Patch("/rates/{taxRateID}", app.updateTaxRateHandler)
+				r.Delete("/rates/{taxRateID}", app.deleteTaxRateHandler)
+			})
+		})
+
+		r.Route("/cart", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermOrdersWrite))
+
+			r.Get("/", app.getCartHandler)
+			r.Post("/items", app.addCartItemHandler)
+			r.Patch("/items/{variantID}", app.updateCartItemHandler)
+			r.Delete("/items/{variantID}", app.removeCartItemHandler)
+		})
+
+		r.Route("/orders", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+
+			r.Get("/", app.getOrdersHandler)
+			r.With(app.RequirePermission(store.PermOrdersWrite)).Post("/checkout", app.checkoutHandler)
+			r.Get("/{orderID}", app.getOrderHandler)
+			r.Post("/{orderID}/transitions", app.transitionOrderHandler)
+			r.With(app.RequirePermission(store.PermOrdersWrite)).Post("/{orderID}/payments", app.createPaymentHandler)
+		})
+
+		r.Route("/reservations/{reservationID}", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware, app.RequirePermission(store.PermOrdersWrite))
+
+			r.Post("/commit", app.commitReservationHandler)
+			r.Delete("/", app.releaseReservationHandler)
+		})
+
+		r.Get("/blobs/*", app.getBlobHandler)
+
+		r.Route("/payments", func(r chi.Router) {
+			r.Post("/webhook", app.paymentWebhookHandler)
+		})
+
 		r.Route("/users", func(r chi.Router) {
 			r.Post("/", app.createUserHandler)
+			r.Put("/activate/{token}", app.activateUserHandler)
 
 			r.Route("/{userID}", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+
 				r.Get("/", app.getUserHandler)
+				r.With(app.RequirePermission(store.PermUsersManage)).Put("/role", app.updateUserRoleHandler)
 				//r.Delete("/", app.deleteUserHandler)
 				//r.Patch("/", app.updateUserHandler)
 
 			})
 		})
 
+		r.With(app.AuthTokenMiddleware, app.RequirePermission(store.PermUsersManage)).Get("/roles", app.getRolesHandler)
+
 		r.Route("/authentication", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
+			r.Post("/token", app.createTokenHandler)
 		})
 	})
 
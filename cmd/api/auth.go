@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/mailer"
 	"github.com/seanhalberthal/webmart/internal/store"
 	"net/http"
+	"time"
 )
 
 type RegisterUserPayload struct {
@@ -13,40 +19,134 @@ type RegisterUserPayload struct {
 
 // RegisterUser godoc
 //
-//	@Summary	Registers a new user on the system
-//	@Tags		authentication
-//	@Accept		json
-//	@Produce	json
-//	@Param		payload	body		RegisterUserPayload	true	"User credentials"
-//	@Success	201		{object}	store.User			"User registered"
-//	@Failure	400		{object}	error
-//	@Failure	500		{object}	error
-//	@Security	ApiKeyAuth
-//	@Router		/users [post]
+//	@Summary		Registers a new user on the system
+//	@Description	Creates an inactive user and emails them an activation link
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RegisterUserPayload	true	"User credentials"
+//	@Success		201		{object}	store.User			"User registered"
+//	@Failure		400		{object}	error
+//	@Failure		422		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/user [post]
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var payload RegisterUserPayload
-	if err := readJSON(w, r, &payload); err != nil {
-		handleError(w, http.StatusBadRequest, err)
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
 		return
 	}
 
 	user := &store.User{Username: payload.Username, Email: payload.Email}
 
 	if err := user.Password.Set(payload.Password); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 
 	ctx := r.Context()
 
-	err := app.store.Users.UserCreate(ctx, user)
+	// The plain token only ever leaves the server in the activation email;
+	// the store persists its hash.
+	plainToken := uuid.New().String()
+
+	err := app.store.Users.UserCreateAndInvite(ctx, user, plainToken, app.config.mail.exp)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	vars := struct {
+		Username      string
+		ActivationURL string
+		Expiry        time.Duration
+	}{
+		Username:      user.Username,
+		ActivationURL: fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken),
+		Expiry:        app.config.mail.exp,
+	}
+
+	if err := app.mailer.Send(mailer.UserInvitationTemplate, user.Username, user.Email, vars); err != nil {
+		// Roll back the registration so the user can sign up again.
+		if err := app.store.Users.UserDelete(ctx, user.ID); err != nil {
+			app.logger.Errorw("error deleting user", "error", err)
+		}
+
+		app.internalServerError(w, r, fmt.Errorf("sending invitation email: %w", err))
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type CreateUserTokenPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// CreateToken godoc
+//
+//	@Summary		Creates a token
+//	@Description	Exchanges user credentials for a signed JWT
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateUserTokenPayload	true	"User credentials"
+//	@Success		201		{string}	string					"Token"
+//	@Failure		400		{object}	error
+//	@Failure		422		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/token [post]
+func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateUserTokenPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	user, err := app.store.Users.UserGetByEmail(r.Context(), payload.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.unauthorizedResponse(w, r, errInvalidCredentials)
+		return
+	}
+
+	if !user.IsActive {
+		app.forbiddenResponse(w, r, errInactiveUser)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": user.ID.String(),
+		"exp": now.Add(app.config.auth.token.exp).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.aud,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := writeJSONResponse(w, http.StatusCreated, payload); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+	if err := writeJSONResponse(w, http.StatusCreated, token); err != nil {
+		app.internalServerError(w, r, err)
 		return
 	}
 }
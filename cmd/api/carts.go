@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"net/http"
+)
+
+type AddCartItemPayload struct {
+	VariantID uuid.UUID `json:"variant_id" validate:"required"`
+	Quantity  int       `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
+type UpdateCartItemPayload struct {
+	Quantity int `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
+// GetCart godoc
+//
+//	@Summary		Fetches the user's cart
+//	@Description	Returns the authenticated user's cart with line and cart subtotals. Lines are re-priced if the variant's price has changed.
+//	@Tags			cart
+//	@Produce		json
+//	@Success		200	{object}	store.Cart
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/cart [get]
+func (app *application) getCartHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeCart(w, r, http.StatusOK)
+}
+
+// AddCartItem godoc
+//
+//	@Summary		Adds a product variant to the cart
+//	@Description	Adds units of a product variant to the authenticated user's cart, on top of any already in it
+//	@Tags			cart
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		AddCartItemPayload	true	"Variant and quantity"
+//	@Success		200		{object}	store.Cart
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Not enough stock that is not held by other users' reservations"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/cart/items [post]
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var payload AddCartItemPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Carts.CartItemAdd(r.Context(), user.ID, payload.VariantID, payload.Quantity); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK)
+}
+
+// UpdateCartItem godoc
+//
+//	@Summary	Changes the quantity of a cart line
+//	@Tags		cart
+//	@Accept		json
+//	@Produce	json
+//	@Param		variantID	path		string					true	"Variant ID"
+//	@Param		payload		body		UpdateCartItemPayload	true	"New quantity"
+//	@Success	200			{object}	store.Cart
+//	@Failure	400			{object}	error
+//	@Failure	401			{object}	error
+//	@Failure	404			{object}	error
+//	@Failure	409			{object}	error	"Not enough stock that is not held by other users' reservations"
+//	@Failure	422			{object}	error
+//	@Failure	500			{object}	error
+//	@Security	ApiKeyAuth
+//	@Router		/cart/items/{variantID} [patch]
+func (app *application) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	variantID, err := getVariantID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload UpdateCartItemPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Carts.CartItemUpdate(r.Context(), user.ID, variantID, payload.Quantity); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.writeCart(w, r, http.StatusOK)
+}
+
+// RemoveCartItem godoc
+//
+//	@Summary	Removes a product variant from the cart
+//	@Tags		cart
+//	@Param		variantID	path		string	true	"Variant ID"
+//	@Success	204			{string}	string	"Item removed"
+//	@Failure	400			{object}	error
+//	@Failure	401			{object}	error
+//	@Failure	404			{object}	error
+//	@Failure	500			{object}	error
+//	@Security	ApiKeyAuth
+//	@Router		/cart/items/{variantID} [delete]
+func (app *application) removeCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	variantID, err := getVariantID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Carts.CartItemRemove(r.Context(), user.ID, variantID); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeCart(w http.ResponseWriter, r *http.Request, status int) {
+	user := getUserFromContext(r)
+
+	cart, err := app.store.Carts.CartGet(r.Context(), user.ID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, status, cart); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
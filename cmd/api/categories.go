@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type CreateCategoryPayload struct {
+	ParentID *uuid.UUID `json:"parent_id"`
+	Name     string     `json:"name" validate:"required,max=100"`
+	// Slug defaults to one made from the name.
+	Slug        string `json:"slug" validate:"omitempty,max=100,slug"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+// UpdateCategoryPayload is a category's editable fields, patched like
+// UpdateProductPayload. Removing parent_id moves the category to the top
+// level.
+type UpdateCategoryPayload struct {
+	ParentID    *uuid.UUID `json:"parent_id,omitempty"`
+	Name        *string    `json:"name" validate:"required,min=1,max=100"`
+	Slug        *string    `json:"slug" validate:"required,max=100,slug"`
+	Description *string    `json:"description" validate:"required,max=1000"`
+}
+
+type SetProductCategoriesPayload struct {
+	CategoryIDs []uuid.UUID `json:"category_ids" validate:"max=20,unique"`
+}
+
+var (
+	slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+	errNoSlug = errors.New("a slug is required when the name has no letters or digits")
+)
+
+// slugify makes a URL slug from a name, e.g. "Home & Garden" becomes
+// "home-garden".
+func slugify(name string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func getCategoryID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "categoryID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid category ID %q", idStr)
+	}
+	return id, nil
+}
+
+// GetCategories godoc
+//
+//	@Summary		Lists categories
+//	@Description	Returns the category tree. Each category's product_count includes the products in its subcategories, counting each product once.
+//	@Tags			categories
+//	@Produce		json
+//	@Success		200	{array}		store.Category
+//	@Failure		500	{object}	error
+//	@Router			/categories [get]
+func (app *application) getCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.store.Categories.CategoryGetAll(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	tree := store.CategoryTree(categories)
+	if tree == nil {
+		tree = []*store.Category{}
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, tree); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateCategory godoc
+//
+//	@Summary		Creates a category
+//	@Description	Adds a category, under parent_id if it is set or at the top level otherwise.
+//	@Tags			categories
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateCategoryPayload	true	"Category"
+//	@Success		201		{object}	store.Category
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error	"No such parent category"
+//	@Failure		409		{object}	error	"Slug already in use"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/categories [post]
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCategoryPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	category := &store.Category{
+		ParentID:    payload.ParentID,
+		Name:        payload.Name,
+		Slug:        payload.Slug,
+		Description: payload.Description,
+	}
+
+	if category.Slug == "" {
+		category.Slug = slugify(payload.Name)
+		if category.Slug == "" {
+			app.badRequestResponse(w, r, errNoSlug)
+			return
+		}
+	}
+
+	if err := app.store.Categories.CategoryCreate(r.Context(), category); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, category); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateCategory godoc
+//
+//	@Summary		Updates a category
+//	@Description	Partially updates a category with a JSON Merge Patch or JSON Patch, as for products. Changing parent_id moves the category and its subcategories; setting it to null moves them to the top level.
+//	@Tags			categories
+//	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json
+//	@Param			categoryID	path		string					true	"Category ID"
+//	@Param			payload		body		UpdateCategoryPayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	store.Category
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Slug already in use, or the move would create a cycle"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/categories/{categoryID} [patch]
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getCategoryID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category, err := app.store.Categories.CategoryGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	current := UpdateCategoryPayload{
+		ParentID:    category.ParentID,
+		Name:        &category.Name,
+		Slug:        &category.Slug,
+		Description: &category.Description,
+	}
+
+	var payload UpdateCategoryPayload
+	if err := app.readPatch(w, r, current, &payload); err != nil {
+		return
+	}
+
+	category.ParentID = payload.ParentID
+	category.Name = *payload.Name
+	category.Slug = *payload.Slug
+	category.Description = *payload.Description
+
+	if err := app.store.Categories.CategoryUpdate(ctx, category); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, category); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteCategory godoc
+//
+//	@Summary		Deletes a category
+//	@Description	Deletes a category, removing its products from it. Subcategories must be moved or deleted first.
+//	@Tags			categories
+//	@Param			categoryID	path	string	true	"Category ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error	"The category has subcategories"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/categories/{categoryID} [delete]
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getCategoryID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Categories.CategoryDelete(r.Context(), id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetProductCategories godoc
+//
+//	@Summary		Sets a product's categories
+//	@Description	Replaces the categories a product is listed in. Only the product's seller or an admin may do so.
+//	@Tags			products
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string						true	"Product ID"
+//	@Param			payload		body		SetProductCategoriesPayload	true	"Category IDs"
+//	@Success		200			{array}		store.CategoryRef
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error	"No such product or category"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/categories [put]
+func (app *application) setProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload SetProductCategoriesPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Categories.CategorySetForProduct(ctx, id, payload.CategoryIDs); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	categories, err := app.store.Categories.CategoryGetByProduct(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, categories); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
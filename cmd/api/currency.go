@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/seanhalberthal/webmart/internal/money"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// AcceptCurrencyHeader lets clients ask for prices in another currency, e.g.
+// "Accept-Currency: EUR". The currency query parameter takes precedence.
+const AcceptCurrencyHeader = "Accept-Currency"
+
+// priceConverter converts product prices into the currency a client asked
+// for.
+type priceConverter struct {
+	to       money.Currency
+	rates    map[money.Currency]*big.Rat
+	rounding money.RoundingMode
+}
+
+// requestedCurrency returns the currency named by the currency query
+// parameter or, failing that, the first supported currency listed in the
+// Accept-Currency header. It returns "" if the client did not ask for one, or
+// only listed unsupported currencies in the header, which like other Accept
+// headers is a preference rather than a demand.
+func requestedCurrency(r *http.Request) (money.Currency, error) {
+	if code := r.URL.Query().Get("currency"); code != "" {
+		return money.ParseCurrency(code)
+	}
+
+	for _, part := range strings.Split(r.Header.Get(AcceptCurrencyHeader), ",") {
+		code, _, _ := strings.Cut(part, ";")
+		if c, err := money.ParseCurrency(code); err == nil {
+			return c, nil
+		}
+	}
+
+	return "", nil
+}
+
+// readPriceConverter returns a converter for the currency the client asked
+// for, or nil if they did not ask for one. If it fails the error response has
+// already been written when it returns.
+func (app *application) readPriceConverter(w http.ResponseWriter, r *http.Request) (*priceConverter, error) {
+	w.Header().Add("Vary", AcceptCurrencyHeader)
+
+	to, err := requestedCurrency(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, err
+	}
+
+	if to == "" {
+		return nil, nil
+	}
+
+	rates, err := app.store.ExchangeRates.ExchangeRateGetAllTo(r.Context(), to)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return nil, err
+	}
+
+	return &priceConverter{to: to, rates: rates, rounding: app.config.currency.rounding}, nil
+}
+
+// convert returns price in the requested currency, or nil if there is no
+// exchange rate to convert it with. A nil converter converts nothing.
+func (c *priceConverter) convert(price money.Amount) *money.Conversion {
+	if c == nil {
+		return nil
+	}
+
+	rate, ok := c.rates[price.Currency()]
+	if !ok {
+		return nil
+	}
+
+	conversion := price.ConvertTo(c.to, rate, c.rounding)
+	return &conversion
+}
@@ -13,7 +13,7 @@ import (
 //	@Success		200	{object}	map[string]string
 //	@Failure		500	{object}	error
 //	@Router			/healthcheck [get]
-func (app *application) healthCheckHandler(w http.ResponseWriter, _ *http.Request) {
+func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]string{
 		"status":  "ok",
 		"env":     app.config.env,
@@ -21,7 +21,7 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, _ *http.Reques
 	}
 
 	if err := writeJSONResponse(w, http.StatusOK, data); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 }
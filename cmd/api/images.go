@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/blobstore"
+	"github.com/seanhalberthal/webmart/internal/images"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// imagesFormField is the multipart form field that carries uploaded images.
+const imagesFormField = "images"
+
+var (
+	errNoImages      = fmt.Errorf("at least one image is required in the %q form field", imagesFormField)
+	errImageTooLarge = errors.New("image is too large")
+)
+
+func getImageID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "imageID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid image ID %q", idStr)
+	}
+	return id, nil
+}
+
+// UploadProductImages godoc
+//
+//	@Summary		Upload product images
+//	@Description	Adds one or more images to a product, after any it already has and in the order they are sent. The format is sniffed from the file contents and must be JPEG, PNG or GIF. Small, medium and large JPEG thumbnails are rendered for each image. Only the product's seller or an admin may upload images.
+//	@Tags			products
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			productID	path		string	true	"Product ID"
+//	@Param			images		formData	file	true	"Image files, repeated for several images"
+//	@Success		201			{array}		store.ProductImage
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"The product would have too many images"
+//	@Failure		413			{object}	error
+//	@Failure		415			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/images [post]
+func (app *application) uploadProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	product, err := app.getOwnedProduct(r, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	cfg := app.config.images
+	maxBytes := cfg.maxBytes*int64(cfg.maxPerProduct) + 1_048_576 // room for the multipart framing
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			app.payloadTooLargeResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer func(form *multipart.Form) {
+		if err := form.RemoveAll(); err != nil {
+			app.logger.Warnw("failed to remove multipart files", "error", err)
+		}
+	}(r.MultipartForm)
+
+	files := r.MultipartForm.File[imagesFormField]
+	if len(files) == 0 {
+		app.badRequestResponse(w, r, errNoImages)
+		return
+	}
+
+	if len(files) > cfg.maxPerProduct {
+		app.conflictResponse(w, r, fmt.Errorf("%w: at most %d are allowed", store.ErrTooManyImages, cfg.maxPerProduct))
+		return
+	}
+
+	uploaded := make([]*store.ProductImage, 0, len(files))
+	for _, fh := range files {
+		img, err := app.storeImage(ctx, product.ID, fh)
+		if err != nil {
+			app.deleteImageBlobs(ctx, uploaded...)
+
+			switch {
+			case errors.Is(err, errImageTooLarge):
+				app.payloadTooLargeResponse(w, r, err)
+			case errors.Is(err, images.ErrUnsupportedFormat), errors.Is(err, images.ErrTooManyPixels):
+				app.unsupportedMediaTypeResponse(w, r, fmt.Errorf("%s: %w", fh.Filename, err))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		uploaded = append(uploaded, img)
+	}
+
+	if err := app.store.ProductImages.ProductImageAdd(ctx, product.ID, uploaded, cfg.maxPerProduct); err != nil {
+		app.deleteImageBlobs(ctx, uploaded...)
+
+		switch {
+		case errors.Is(err, store.ErrTooManyImages):
+			app.conflictResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	result := make([]store.ProductImage, len(uploaded))
+	for i, img := range uploaded {
+		result[i] = *img
+	}
+	app.setImageURLs(result)
+
+	if err := writeJSONResponse(w, http.StatusCreated, result); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// storeImage checks an uploaded file and writes it and its thumbnails to the
+// blob store. The returned image is not saved to the database yet.
+func (app *application) storeImage(ctx context.Context, productID uuid.UUID, fh *multipart.FileHeader) (*store.ProductImage, error) {
+	if fh.Size > app.config.images.maxBytes {
+		return nil, fmt.Errorf("%w: %s is over the %d byte limit", errImageTooLarge, fh.Filename, app.config.images.maxBytes)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func(f multipart.File) {
+		err := f.Close()
+		if err != nil {
+			return
+		}
+	}(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, contentType, err := images.Decode(data, app.config.images.maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &store.ProductImage{
+		ID:            uuid.New(),
+		ProductID:     productID,
+		ContentType:   contentType,
+		Width:         decoded.Bounds().Dx(),
+		Height:        decoded.Bounds().Dy(),
+		Size:          int64(len(data)),
+		ThumbnailKeys: make(map[string]string, len(images.ThumbnailSizes)),
+	}
+
+	prefix := fmt.Sprintf("products/%s/%s/", productID, img.ID)
+	img.Key = prefix + "original" + images.Extension(contentType)
+
+	if err := app.blobs.Put(ctx, img.Key, data, contentType); err != nil {
+		return nil, err
+	}
+
+	for _, size := range images.ThumbnailSizes {
+		thumbnail, err := images.EncodeJPEG(images.Thumbnail(decoded, size.Max))
+		if err != nil {
+			app.deleteImageBlobs(ctx, img)
+			return nil, err
+		}
+
+		key := prefix + size.Name + ".jpg"
+		if err := app.blobs.Put(ctx, key, thumbnail, "image/jpeg"); err != nil {
+			app.deleteImageBlobs(ctx, img)
+			return nil, err
+		}
+		img.ThumbnailKeys[size.Name] = key
+	}
+
+	return img, nil
+}
+
+// DeleteProductImage godoc
+//
+//	@Summary		Delete a product image
+//	@Description	Removes an image and its thumbnails from a product. Only the product's seller or an admin may delete images.
+//	@Tags			products
+//	@Param			productID	path	string	true	"Product ID"
+//	@Param			imageID		path	string	true	"Image ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/images/{imageID} [delete]
+func (app *application) deleteProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	imageID, err := getImageID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	img, err := app.store.ProductImages.ProductImageDelete(ctx, id, imageID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.deleteImageBlobs(ctx, img)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBlob godoc
+//
+//	@Summary		Download a blob
+//	@Description	Serves blobs, such as product images, from the configured blob store. Image URLs point here when blobs are stored on the local file system.
+//	@Tags			blobs
+//	@Produce		octet-stream
+//	@Param			key	path		string	true	"Blob key"
+//	@Success		200	{file}		file
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/blobs/{key} [get]
+func (app *application) getBlobHandler(w http.ResponseWriter, r *http.Request) {
+	blob, contentType, err := app.blobs.Get(r.Context(), chi.URLParam(r, "*"))
+	if err != nil {
+		switch {
+		case errors.Is(err, blobstore.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer func(blob io.ReadCloser) {
+		err := blob.Close()
+		if err != nil {
+			return
+		}
+	}(blob)
+
+	// Blob keys are never reused, so their contents never change.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, blob); err != nil {
+		app.logger.Warnw("failed to write blob", "path", r.URL.Path, "error", err)
+	}
+}
+
+// setImageURLs fills in the download URLs of images from the blob store.
+func (app *application) setImageURLs(imgs []store.ProductImage) {
+	for i := range imgs {
+		imgs[i].URL = app.blobs.URL(imgs[i].Key)
+		imgs[i].Thumbnails = make(map[string]string, len(imgs[i].ThumbnailKeys))
+		for size, key := range imgs[i].ThumbnailKeys {
+			imgs[i].Thumbnails[size] = app.blobs.URL(key)
+		}
+	}
+}
+
+// deleteImageBlobs removes the blobs of images that are being discarded. It
+// only logs failures, which leave orphaned blobs behind but nothing broken.
+func (app *application) deleteImageBlobs(ctx context.Context, imgs ...*store.ProductImage) {
+	for _, img := range imgs {
+		keys := []string{img.Key}
+		for _, key := range img.ThumbnailKeys {
+			keys = append(keys, key)
+		}
+
+		for _, key := range keys {
+			if key == "" {
+				continue
+			}
+			if err := app.blobs.Delete(ctx, key); err != nil {
+				app.logger.Warnw("failed to delete blob", "key", key, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+)
+
+type InventoryAdjustmentPayload struct {
+	VariantID uuid.UUID `json:"variant_id" validate:"required"`
+	// WarehouseID defaults to the seller's first warehouse.
+	WarehouseID *uuid.UUID `json:"warehouse_id"`
+	// Kind is receipt for deliveries of new stock, which must be positive,
+	// and adjustment, the default, for corrections either way.
+	Kind     store.MovementKind `json:"kind" validate:"omitempty,oneof=receipt adjustment"`
+	Quantity int                `json:"quantity" validate:"required,min=-100000,max=100000"`
+	Reason   string             `json:"reason" validate:"required,max=500"`
+}
+
+type AllocationPayload struct {
+	VariantID uuid.UUID `json:"variant_id" validate:"required"`
+	Quantity  int       `json:"quantity" validate:"required,min=1,max=100000"`
+	// Strategy defaults to the one checkout uses.
+	Strategy store.AllocationStrategy `json:"strategy" validate:"omitempty,oneof=most_stock fewest_splits priority"`
+}
+
+type StockTransferPayload struct {
+	VariantID       uuid.UUID `json:"variant_id" validate:"required"`
+	FromWarehouseID uuid.UUID `json:"from_warehouse_id" validate:"required"`
+	ToWarehouseID   uuid.UUID `json:"to_warehouse_id" validate:"required,nefield=FromWarehouseID"`
+	Quantity        int       `json:"quantity" validate:"required,min=1,max=100000"`
+	Reason          string    `json:"reason" validate:"max=500"`
+}
+
+type InventoryHistoryQuery struct {
+	VariantID *uuid.UUID `json:"variant_id"`
+	Limit     int        `json:"limit" validate:"min=1,max=100"`
+	Cursor    string     `json:"cursor" validate:"max=512"`
+}
+
+// CreateInventoryAdjustment godoc
+//
+//	@Summary		Adjusts a variant's stock
+//	@Description	Records a stock receipt or adjustment in the inventory ledger and applies it to the variant's stock in a warehouse, by default the seller's first. quantity is signed: negative adjustments take stock away, but never below zero. Only the product's seller or an admin may adjust its stock.
+//	@Tags			inventory
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string						true	"Product ID"
+//	@Param			payload		body		InventoryAdjustmentPayload	true	"Adjustment"
+//	@Success		201			{object}	store.InventoryMovement
+//	@Failure		400			{object}	error	"A receipt with a negative quantity"
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error	"No such product, variant or warehouse"
+//	@Failure		409			{object}	error	"The adjustment would leave negative stock"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/inventory/adjustments [post]
+func (app *application) createInventoryAdjustmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload InventoryAdjustmentPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if payload.Kind == "" {
+		payload.Kind = store.MovementAdjustment
+	}
+
+	user := getUserFromContext(r)
+	movement := &store.InventoryMovement{
+		ProductID:   id,
+		VariantID:   &payload.VariantID,
+		WarehouseID: payload.WarehouseID,
+		Kind:        payload.Kind,
+		Quantity:    payload.Quantity,
+		Reason:      payload.Reason,
+		ActorID:     &user.ID,
+	}
+
+	if err := app.store.Inventory.InventoryRecord(r.Context(), movement); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, movement); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateStockAllocation godoc
+//
+//	@Summary		Plans where stock ships from
+//	@Description	Picks the warehouses a quantity of a variant would be taken from, without taking it. most_stock drains the fullest warehouses first, fewest_splits uses as few warehouses as possible and priority follows the seller's warehouse order. Stock held by reservations cannot be allocated. Only the product's seller or an admin may allocate its stock.
+//	@Tags			inventory
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string				true	"Product ID"
+//	@Param			payload		body		AllocationPayload	true	"Variant, quantity and strategy"
+//	@Success		200			{object}	store.Allocation
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Not enough available stock"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/inventory/allocations [post]
+func (app *application) createStockAllocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	payload := AllocationPayload{Strategy: app.config.warehouses.strategy}
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	allocation, err := app.store.Warehouses.WarehouseAllocate(r.Context(), id, payload.VariantID, payload.Quantity, payload.Strategy)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, allocation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateStockTransfer godoc
+//
+//	@Summary		Moves stock between warehouses
+//	@Description	Moves units of a variant from one of the seller's warehouses to another, recording a transfer out of one and into the other in the inventory ledger. The variant's total stock is unchanged. Only the product's seller or an admin may transfer its stock.
+//	@Tags			inventory
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string					true	"Product ID"
+//	@Param			payload		body		StockTransferPayload	true	"Transfer"
+//	@Success		201			{array}		store.InventoryMovement
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error	"No such product, variant or warehouse"
+//	@Failure		409			{object}	error	"The source warehouse holds too little"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/inventory/transfers [post]
+func (app *application) createStockTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload StockTransferPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := getUserFromContext(r)
+	transfer := &store.StockTransfer{
+		ProductID:       id,
+		VariantID:       payload.VariantID,
+		FromWarehouseID: payload.FromWarehouseID,
+		ToWarehouseID:   payload.ToWarehouseID,
+		Quantity:        payload.Quantity,
+		Reason:          payload.Reason,
+		ActorID:         &user.ID,
+	}
+
+	if err := app.store.Warehouses.WarehouseTransfer(r.Context(), transfer); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, transfer.Movements); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetInventoryMovements godoc
+//
+//	@Summary		Lists a product's stock movements
+//	@Description	Pages through the inventory ledger of a product, newest first. Each movement carries the variant's stock after it (balance) and the product's total stock after it (product_balance). Only the product's seller or an admin may read it.
+//	@Tags			inventory
+//	@Produce		json
+//	@Param			productID	path		string	true	"Product ID"
+//	@Param			variant_id	query		string	false	"Only movements of this variant"
+//	@Param			limit		query		int		false	"Page size (1-100)"	default(20)
+//	@Param			cursor		query		string	false	"Cursor from the previous page's next_cursor"
+//	@Success		200			{object}	store.InventoryPage
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/inventory/movements [get]
+func (app *application) getInventoryMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+	hq := InventoryHistoryQuery{Cursor: q.Get("cursor")}
+
+	if hq.Limit, err = queryInt(q, "limit", store.DefaultPageLimit); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if hq.VariantID, err = queryUUID(q, "variant_id"); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(hq); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	page, err := app.store.Inventory.InventoryGetHistory(r.Context(), store.InventoryQuery{
+		ProductID: id,
+		VariantID: hq.VariantID,
+		Limit:     hq.Limit,
+		Cursor:    hq.Cursor,
+	})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -2,9 +2,46 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"github.com/seanhalberthal/webmart/internal/tax"
+	"io"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
+var Validate *validator.Validate
+
+func init() {
+	Validate = validator.New(validator.WithRequiredStructEnabled())
+
+	// Report failing fields by their JSON names rather than Go field names.
+	Validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	// Validate amounts by their minor units, so min=0 rejects negative prices.
+	Validate.RegisterCustomTypeFunc(func(v reflect.Value) any {
+		return v.Interface().(money.Amount).Minor()
+	}, money.Amount{})
+
+	Validate.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugPattern.MatchString(fl.Field().String())
+	})
+
+	Validate.RegisterValidation("promocode", func(fl validator.FieldLevel) bool {
+		return promoCodePattern.MatchString(fl.Field().String())
+	})
+}
+
 func writeJSON(w http.ResponseWriter, status int, data any) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -14,7 +51,108 @@ func writeJSON(w http.ResponseWriter, status int, data any) error {
 func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	maxBytes := 1_048_576 // 1MB response limit
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	return json.NewDecoder(r.Body).Decode(data)
+
+	return decodeJSON(r.Body, data)
+}
+
+// decodeJSON reads a single JSON value into data, rejecting unknown fields.
+func decodeJSON(r io.Reader, data any) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(data); err != nil {
+		return err
+	}
+
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// readAndValidateJSON decodes the request body into data and runs the
+// validate struct tags against it. If either step fails the matching error
+// response has already been written when it returns.
+func (app *application) readAndValidateJSON(w http.ResponseWriter, r *http.Request, data any) error {
+	if err := readJSON(w, r, data); err != nil {
+		app.badRequestResponse(w, r, err)
+		return err
+	}
+
+	if err := Validate.Struct(data); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return err
+	}
+
+	return nil
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func validationFieldErrors(errs validator.ValidationErrors) []fieldError {
+	fields := make([]fieldError, 0, len(errs))
+	for _, fe := range errs {
+		// Drop the top-level struct name from the namespace so nested
+		// fields read as "address.city" instead of "Payload.address.city".
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+
+		fields = append(fields, fieldError{
+			Field:   field,
+			Code:    fe.Tag(),
+			Message: validationMessage(fe),
+		})
+	}
+
+	return fields
+}
+
+func validationMessage(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "uuid", "uuid4":
+		return "must be a valid UUID"
+	case "url":
+		return "must be a valid URL"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "min", "gte":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max", "lte":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "lt":
+		return fmt.Sprintf("must be less than %s", fe.Param())
+	case "len":
+		return fmt.Sprintf("must be exactly %s long", fe.Param())
+	case "unique":
+		return "must not contain duplicates"
+	case "slug":
+		return "must be lowercase letters and digits separated by single hyphens"
+	case "promocode":
+		return "must be letters, digits, hyphens and underscores"
+	default:
+		return fmt.Sprintf("failed the %q check", fe.Tag())
+	}
 }
 
 func respondWithErrorJSON(w http.ResponseWriter, status int, message string) error {
@@ -25,17 +163,169 @@ func respondWithErrorJSON(w http.ResponseWriter, status int, message string) err
 	return writeJSON(w, status, &envelope{Error: message})
 }
 
-func handleError(w http.ResponseWriter, statusCode int, err error) {
-	err = respondWithErrorJSON(w, statusCode, err.Error())
-	if err != nil {
+func writeJSONResponse(w http.ResponseWriter, status int, data interface{}) error {
+	type envelope struct {
+		Data any `json:"data"`
+	}
+
+	return writeJSON(w, status, &envelope{Data: data})
+}
+
+// errorResponse maps an error returned by the store to the matching HTTP
+// response. Anything it does not recognise is treated as a server error.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var stockErr *store.InsufficientStockError
+
+	switch {
+	case errors.As(err, &stockErr):
+		app.insufficientStockResponse(w, r, stockErr)
+	case errors.Is(err, store.ErrInvalidCursor),
+		errors.Is(err, store.ErrInvalidMovement),
+		errors.Is(err, store.ErrInvalidPromotion),
+		errors.Is(err, tax.ErrInvalidRate):
+		app.badRequestResponse(w, r, err)
+	case errors.Is(err, store.ErrNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrVersionConflict):
+		app.preconditionFailedResponse(w, r, err)
+	case errors.Is(err, store.ErrDuplicateEmail),
+		errors.Is(err, store.ErrDuplicateUsername),
+		errors.Is(err, store.ErrDuplicateReview),
+		errors.Is(err, store.ErrInsufficientStock),
+		errors.Is(err, store.ErrMixedCurrencies),
+		errors.Is(err, store.ErrDuplicateSlug),
+		errors.Is(err, store.ErrCategoryCycle),
+		errors.Is(err, store.ErrCategoryHasChildren),
+		errors.Is(err, store.ErrDuplicateSKU),
+		errors.Is(err, store.ErrDuplicateVariant),
+		errors.Is(err, store.ErrLastVariant),
+		errors.Is(err, store.ErrInvalidTransition),
+		errors.Is(err, store.ErrReservationClosed),
+		errors.Is(err, store.ErrDuplicateWarehouse),
+		errors.Is(err, store.ErrWarehouseNotEmpty),
+		errors.Is(err, store.ErrDuplicatePromotionCode),
+		errors.Is(err, store.ErrDuplicateRedemption),
+		errors.Is(err, store.ErrPromotionNotApplicable),
+		errors.Is(err, store.ErrDuplicateTaxRate),
+		errors.Is(err, store.ErrPaymentInProgress),
+		errors.Is(err, store.ErrConflict):
+		app.conflictResponse(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Errorw("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusInternalServerError, "the server encountered a problem")
+}
+
+func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusBadRequest, err.Error())
+}
+
+func (app *application) unauthorizedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unauthorized", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="restricted"`)
+	app.writeError(w, http.StatusUnauthorized, err.Error())
+}
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("forbidden", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusForbidden, err.Error())
+}
+
+func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("not found", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusNotFound, err.Error())
+}
+
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		app.internalServerError(w, r, err)
 		return
 	}
+
+	app.logger.Warnw("failed validation", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	type envelope struct {
+		Error  string       `json:"error"`
+		Fields []fieldError `json:"fields"`
+	}
+
+	body := &envelope{Error: "validation failed", Fields: validationFieldErrors(errs)}
+	if err := writeJSON(w, http.StatusUnprocessableEntity, body); err != nil {
+		app.logger.Errorw("failed to write error response", "error", err)
+	}
 }
 
-func writeJSONResponse(w http.ResponseWriter, status int, data interface{}) error {
+func (app *application) insufficientStockResponse(w http.ResponseWriter, r *http.Request, err *store.InsufficientStockError) {
+	app.logger.Warnw("conflict", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
 	type envelope struct {
-		Data any `json:"data"`
+		Error    string                `json:"error"`
+		Variants []store.StockShortage `json:"variants"`
 	}
 
-	return writeJSON(w, status, &envelope{Data: data})
+	body := &envelope{Error: store.ErrInsufficientStock.Error(), Variants: err.Shortages}
+	if err := writeJSON(w, http.StatusConflict, body); err != nil {
+		app.logger.Errorw("failed to write error response", "error", err)
+	}
+}
+
+func (app *application) paymentDeclinedResponse(w http.ResponseWriter, r *http.Request, payment *store.Payment) {
+	app.logger.Warnw("payment declined", "method", r.Method, "path", r.URL.Path, "payment", payment.ID, "reason", payment.FailureReason)
+
+	type envelope struct {
+		Error   string         `json:"error"`
+		Payment *store.Payment `json:"payment"`
+	}
+
+	body := &envelope{Error: errPaymentDeclined.Error(), Payment: payment}
+	if err := writeJSON(w, http.StatusPaymentRequired, body); err != nil {
+		app.logger.Errorw("failed to write error response", "error", err)
+	}
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("conflict", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusConflict, err.Error())
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusPreconditionFailed, err.Error())
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition required", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusPreconditionRequired, err.Error())
+}
+
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("payload too large", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unsupported media type", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	app.writeError(w, http.StatusUnsupportedMediaType, err.Error())
+}
+
+func (app *application) writeError(w http.ResponseWriter, status int, message string) {
+	if err := respondWithErrorJSON(w, status, message); err != nil {
+		app.logger.Errorw("failed to write error response", "error", err)
+	}
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+	"strings"
+)
+
+type userKey string
+
+const userCtx userKey = "user"
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errMissingAuthHeader  = errors.New("authorization header is missing")
+	errMalformedAuth      = errors.New("authorization header is malformed")
+	errInvalidToken       = errors.New("invalid token")
+	errInactiveUser       = errors.New("user account has not been activated")
+	errForbidden          = errors.New("you do not have permission to do this")
+)
+
+// AuthTokenMiddleware validates the bearer token on the request and loads the
+// user it was issued for into the request context.
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			app.unauthorizedResponse(w, r, errMissingAuthHeader)
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			app.unauthorizedResponse(w, r, errMalformedAuth)
+			return
+		}
+
+		token, err := app.authenticator.ValidateToken(parts[1])
+		if err != nil {
+			app.unauthorizedResponse(w, r, errInvalidToken)
+			return
+		}
+
+		sub, err := token.Claims.GetSubject()
+		if err != nil {
+			app.unauthorizedResponse(w, r, errInvalidToken)
+			return
+		}
+
+		userID, err := uuid.Parse(sub)
+		if err != nil {
+			app.unauthorizedResponse(w, r, errInvalidToken)
+			return
+		}
+
+		ctx := r.Context()
+
+		user, err := app.store.Users.UserGet(ctx, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedResponse(w, r, errInvalidToken)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if !user.IsActive {
+			app.forbiddenResponse(w, r, errInactiveUser)
+			return
+		}
+
+		ctx = context.WithValue(ctx, userCtx, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequirePermission lets through only users whose role has been granted
+// permission. It must run after AuthTokenMiddleware.
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
+			if user == nil {
+				app.unauthorizedResponse(w, r, errMissingAuthHeader)
+				return
+			}
+
+			if !user.Role.Can(permission) {
+				app.forbiddenResponse(w, r, fmt.Errorf("%w: requires %s", errForbidden, permission))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func getUserFromContext(r *http.Request) *store.User {
+	user, _ := r.Context().Value(userCtx).(*store.User)
+	return user
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+	"slices"
+)
+
+type CheckoutItemPayload struct {
+	VariantID uuid.UUID `json:"variant_id" validate:"required"`
+	Quantity  int       `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
+type CheckoutPayload struct {
+	Items []CheckoutItemPayload `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+type OrderTransitionPayload struct {
+	Status store.OrderStatus `json:"status" validate:"required,oneof=pending paid fulfilled shipped delivered cancelled refunded"`
+	Note   string            `json:"note" validate:"max=500"`
+}
+
+var (
+	errBuyerTransition  = errors.New("buyers can only cancel their orders while they are pending")
+	errSellerTransition = errors.New("sellers can only mark orders of just their own products as fulfilled, shipped or delivered")
+)
+
+func getOrderID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "orderID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid order ID %q", idStr)
+	}
+	return id, nil
+}
+
+// Checkout godoc
+//
+//	@Summary		Places an order
+//	@Description	Buys the listed product variants, decrementing their stock and snapshotting their prices in a single transaction. Stock is taken from the sellers' warehouses using the configured allocation strategy.
+//	@Tags			orders
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CheckoutPayload	true	"Variants and quantities"
+//	@Success		201		{object}	store.Order
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Not enough stock, with the offending variants"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/orders/checkout [post]
+func (app *application) checkoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CheckoutPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	lines := make([]store.OrderLine, len(payload.Items))
+	for i, item := range payload.Items {
+		lines[i] = store.OrderLine{VariantID: item.VariantID, Quantity: item.Quantity}
+	}
+
+	user := getUserFromContext(r)
+
+	order, err := app.store.Orders.OrderCheckout(r.Context(), user.ID, lines, app.config.warehouses.strategy)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, order); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetOrders godoc
+//
+//	@Summary	Lists the user's orders
+//	@Tags		orders
+//	@Produce	json
+//	@Success	200	{array}		store.Order
+//	@Failure	401	{object}	error
+//	@Failure	500	{object}	error
+//	@Security	ApiKeyAuth
+//	@Router		/orders [get]
+func (app *application) getOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	orders, err := app.store.Orders.OrderGetAllByUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, orders); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetOrder godoc
+//
+//	@Summary		Fetches an order
+//	@Description	Returns an order with its line items and status history. Visible to its buyer, the sellers of its products and admins.
+//	@Tags			orders
+//	@Produce		json
+//	@Param			orderID	path		string	true	"Order ID"
+//	@Success		200		{object}	store.Order
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/orders/{orderID} [get]
+func (app *application) getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getOrderID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order, err := app.store.Orders.OrderGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if order.UserID != user.ID && !user.Role.Can(store.PermOrdersManage) {
+		isSeller, err := app.store.Orders.OrderHasSeller(ctx, order.ID, user.ID)
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+
+		// Report other people's orders as missing rather than revealing
+		// that they exist.
+		if !isSeller {
+			app.notFoundResponse(w, r, store.ErrNotFound)
+			return
+		}
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, order); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// TransitionOrder godoc
+//
+//	@Summary		Changes an order's status
+//	@Description	Moves an order along its lifecycle (pending, paid, fulfilled, shipped, delivered, with cancelled and refunded branches). Buyers may cancel their own orders while they are pending. Sellers may mark orders made up only of their own products as fulfilled, shipped or delivered. Admins may make any change the lifecycle allows, including marking orders paid or refunded. Cancelling or refunding an order voids or refunds its payments with the payment gateway once the change is saved; returns the gateway cannot make straight away are retried in the background.
+//	@Tags			orders
+//	@Accept			json
+//	@Produce		json
+//	@Param			orderID	path		string					true	"Order ID"
+//	@Param			payload	body		OrderTransitionPayload	true	"New status"
+//	@Success		200		{object}	store.Order
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Transition not allowed from the current status"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/orders/{orderID}/transitions [post]
+func (app *application) transitionOrderHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getOrderID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload OrderTransitionPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	order, err := app.store.Orders.OrderGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	sellers, err := app.store.Orders.OrderGetSellers(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	// Cancelling or refunding an order gives the buyer their money back. The
+	// payments are only marked here and returned through the gateway once the
+	// change has committed, so a change that is rolled back keeps the money.
+	var returning []store.Payment
+	err = app.store.WithTx(ctx, func(s store.Storage) error {
+		// Check the status the change is made from under the order's lock,
+		// so that it cannot move on before the change is made.
+		from, err := s.Orders.OrderLockStatus(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := orderTransitionAllowed(user, order, sellers, from, payload.Status); err != nil {
+			return err
+		}
+
+		if err := s.Orders.OrderTransition(ctx, id, payload.Status, &user.ID, payload.Note); err != nil {
+			return err
+		}
+
+		if payload.Status == store.OrderCancelled || payload.Status == store.OrderRefunded {
+			returning, err = app.returnPayments(ctx, s, id)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errBuyerTransition), errors.Is(err, errSellerTransition):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+	app.settleReturns(ctx, returning)
+
+	order, err = app.store.Orders.OrderGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, order); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// orderTransitionAllowed checks that user may move order from its current
+// status to another. Admins may make any change. Buyers may only cancel
+// pending orders, and sellers may only move orders of just their own
+// products through fulfilment. Users with no part in the order are told it
+// does not exist.
+func orderTransitionAllowed(user *store.User, order *store.Order, sellers []uuid.UUID, from, to store.OrderStatus) error {
+	if user.Role.Can(store.PermOrdersManage) {
+		return nil
+	}
+
+	isBuyer := order.UserID == user.ID
+	isSeller := slices.Contains(sellers, user.ID)
+
+	switch {
+	case isBuyer && to == store.OrderCancelled:
+		if from != store.OrderPending {
+			return errBuyerTransition
+		}
+		return nil
+	case isSeller && (to == store.OrderFulfilled || to == store.OrderShipped || to == store.OrderDelivered):
+		if len(sellers) != 1 {
+			return errSellerTransition
+		}
+		return nil
+	case isSeller:
+		return errSellerTransition
+	case isBuyer:
+		return errBuyerTransition
+	default:
+		return store.ErrNotFound
+	}
+}
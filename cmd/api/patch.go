@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/seanhalberthal/webmart/internal/jsonpatch"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Media types accepted by PATCH endpoints.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+var errUnsupportedPatch = fmt.Errorf("patches must be sent as %s or %s", mergePatchMediaType, jsonPatchMediaType)
+
+// readPatch applies the JSON Merge Patch or JSON Patch in the request body to
+// the JSON form of current, decodes the result into dst and validates it. If
+// any step fails the matching error response has already been written when it
+// returns.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, current, dst any) error {
+	applyPatch, err := patchFunc(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r, err)
+		return err
+	}
+
+	maxBytes := 1_048_576 // 1MB request limit
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return err
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return err
+	}
+
+	patched, err := applyPatch(doc, patch)
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrPathNotFound), errors.Is(err, jsonpatch.ErrTestFailed):
+			app.conflictResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return err
+	}
+
+	if err := decodeJSON(bytes.NewReader(patched), dst); err != nil {
+		app.badRequestResponse(w, r, err)
+		return err
+	}
+
+	if err := Validate.Struct(dst); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return err
+	}
+
+	return nil
+}
+
+// patchFunc picks how to apply a patch from the request's Content-Type. Plain
+// JSON is treated as a merge patch, which is what older clients send.
+func patchFunc(contentType string) (func(doc, patch []byte) ([]byte, error), error) {
+	if contentType == "" {
+		return jsonpatch.MergePatch, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errUnsupportedPatch
+	}
+
+	switch mediaType {
+	case mergePatchMediaType, "application/json":
+		return jsonpatch.MergePatch, nil
+	case jsonPatchMediaType:
+		return jsonpatch.Apply, nil
+	default:
+		return nil, errUnsupportedPatch
+	}
+}
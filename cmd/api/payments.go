@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/payments"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"io"
+	"net/http"
+	"time"
+)
+
+type CreatePaymentPayload struct {
+	CardNumber string `json:"card_number" validate:"required,numeric,min=12,max=19"`
+}
+
+var (
+	errOrderNotPayable = errors.New("only pending orders can be paid for")
+	errNothingToPay    = errors.New("the order total is zero, so there is nothing to pay")
+	errPaymentDeclined = errors.New("payment declined")
+)
+
+// CreatePayment godoc
+//
+//	@Summary		Pays for an order
+//	@Description	Authorizes and captures the order total with the configured payment gateway. Captured payments mark the order as paid. Some payments are confirmed later by webhook, in which case 202 is returned. An order can only have one payment in progress at a time; another attempt is allowed once a payment is declined or fails.
+//	@Tags			payments
+//	@Accept			json
+//	@Produce		json
+//	@Param			orderID	path		string					true	"Order ID"
+//	@Param			payload	body		CreatePaymentPayload	true	"Card details"
+//	@Success		201		{object}	store.Payment
+//	@Success		202		{object}	store.Payment	"Awaiting confirmation from the provider"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		402		{object}	error	"Declined, with the payment record"
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Order is not pending, has nothing to pay or already has a payment in progress"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/orders/{orderID}/payments [post]
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getOrderID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CreatePaymentPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	order, err := app.store.Orders.OrderGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if order.UserID != user.ID {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return
+	}
+
+	if order.Status != store.OrderPending {
+		app.conflictResponse(w, r, errOrderNotPayable)
+		return
+	}
+
+	if order.Total.IsZero() {
+		app.conflictResponse(w, r, errNothingToPay)
+		return
+	}
+
+	payment := &store.Payment{
+		UserID:   user.ID,
+		OrderID:  order.ID,
+		Provider: app.payments.Name(),
+		Amount:   order.Total,
+	}
+
+	if err := app.store.Payments.PaymentCreate(ctx, payment); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	result, err := app.payments.Authorize(ctx, payments.AuthorizeRequest{
+		Reference:  payment.ID.String(),
+		Amount:     payment.Amount,
+		CardNumber: payload.CardNumber,
+	})
+	if err != nil {
+		app.failPayment(w, r, payment, err)
+		return
+	}
+
+	payment.ProviderRef = &result.ProviderRef
+	payment.Status = result.Status
+	payment.FailureReason = result.DeclineReason
+
+	if result.Status != payments.StatusAuthorized {
+		if err := app.store.Payments.PaymentUpdate(ctx, payment); err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+
+		app.paymentDeclinedResponse(w, r, payment)
+		return
+	}
+
+	result, err = app.payments.Capture(ctx, result.ProviderRef, payment.Amount)
+	if err != nil {
+		app.failPayment(w, r, payment, err)
+		return
+	}
+
+	// The capture is confirmed later by webhook, so the payment stays
+	// authorized until then.
+	if result.Status == payments.StatusPending {
+		if err := app.store.Payments.PaymentUpdate(ctx, payment); err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+
+		if err := writeJSONResponse(w, http.StatusAccepted, payment); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	payment.Status = result.Status
+
+	var returning []store.Payment
+	err = app.store.WithTx(ctx, func(s store.Storage) error {
+		returning, err = app.applyPayment(r, s, payment)
+		return err
+	})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	app.settleReturns(ctx, returning)
+
+	if err := writeJSONResponse(w, http.StatusCreated, payment); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// PaymentWebhook godoc
+//
+//	@Summary		Receives payment provider events
+//	@Description	Reconciles an asynchronous payment event into the payment record. The body must be signed in the Webmart-Signature header. Events are applied once; redeliveries and events that would move a payment backwards are acknowledged and ignored.
+//	@Tags			payments
+//	@Accept			json
+//	@Param			Webmart-Signature	header	string			true	"t=<unix timestamp>,v1=<hex HMAC-SHA256>"
+//	@Param			payload				body	payments.Event	true	"Provider event"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/payments/webhook [post]
+func (app *application) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 1_048_576 // 1MB request limit
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	header := r.Header.Get(payments.SignatureHeader)
+	if err := payments.VerifySignature(app.config.payments.webhookSecret, header, body, app.config.payments.tolerance, time.Now()); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var event payments.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if event.ID == "" || event.ProviderRef == "" {
+		app.badRequestResponse(w, r, errors.New("event id and provider_ref are required"))
+		return
+	}
+
+	var returning []store.Payment
+	err = app.store.WithTx(r.Context(), func(s store.Storage) error {
+		payment, err := s.Payments.PaymentGetByProviderRef(r.Context(), event.ProviderRef)
+		if err != nil {
+			return err
+		}
+
+		isNew, err := s.Payments.PaymentRecordEvent(r.Context(), payment.ID, event.ID, event.Type, body)
+		if err != nil {
+			return err
+		}
+
+		if !isNew {
+			return nil
+		}
+
+		if !payment.Status.CanTransitionTo(event.Status) {
+			app.logger.Infow("ignoring out of order payment event",
+				"event", event.ID, "payment", payment.ID, "from", payment.Status, "to", event.Status)
+			return nil
+		}
+
+		payment.Status = event.Status
+		payment.FailureReason = event.Reason
+
+		returning, err = app.applyPayment(r, s, payment)
+		return err
+	})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	app.settleReturns(r.Context(), returning)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPayment stores a payment's new status and marks its order as paid once
+// the payment is captured. If the order has moved on, the payment is marked
+// to be given back, and the payments returned must be passed to settleReturns
+// once the transaction commits. s must be bound to a transaction.
+func (app *application) applyPayment(r *http.Request, s store.Storage, payment *store.Payment) ([]store.Payment, error) {
+	if err := s.Payments.PaymentUpdate(r.Context(), payment); err != nil {
+		return nil, err
+	}
+
+	if payment.Status != payments.StatusCaptured {
+		return nil, nil
+	}
+
+	note := fmt.Sprintf("payment %s captured", payment.ID)
+	err := s.Orders.OrderTransition(r.Context(), payment.OrderID, store.OrderPaid, nil, note)
+	if errors.Is(err, store.ErrInvalidTransition) {
+		// The order moved on, e.g. it was cancelled, while the payment was
+		// in flight, so the money is given back.
+		app.logger.Warnw("refunding captured payment for an order that is no longer pending",
+			"payment", payment.ID, "order", payment.OrderID, "error", err)
+		return app.returnPayments(r.Context(), s, payment.OrderID)
+	}
+
+	return nil, err
+}
+
+// returnPayments marks the money taken for an order that has been cancelled
+// or refunded to be given back: authorized payments are to be voided and
+// captured ones refunded. Nothing is sent to the gateway here, so a change
+// that is rolled back never returns money; the marked payments are returned
+// for settleReturns once the transaction commits. s must be bound to a
+// transaction.
+func (app *application) returnPayments(ctx context.Context, s store.Storage, orderID uuid.UUID) ([]store.Payment, error) {
+	orderPayments, err := s.Payments.PaymentGetAllByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var returning []store.Payment
+	for i := range orderPayments {
+		payment := &orderPayments[i]
+
+		switch payment.Status {
+		case payments.StatusAuthorized:
+			payment.Status = payments.StatusVoidPending
+		case payments.StatusCaptured:
+			payment.Status = payments.StatusRefundPending
+		default:
+			continue
+		}
+
+		if err := s.Payments.PaymentUpdate(ctx, payment); err != nil {
+			return nil, err
+		}
+		returning = append(returning, *payment)
+	}
+
+	return returning, nil
+}
+
+// settleReturns asks the gateway to void or refund payments marked by
+// returnPayments. Payments the gateway fails to return, or will only confirm
+// later by webhook, stay marked, and retryReturns tries them again.
+func (app *application) settleReturns(ctx context.Context, returning []store.Payment) {
+	for i := range returning {
+		if err := app.settleReturn(ctx, &returning[i]); err != nil {
+			app.logger.Errorw("returning payment", "payment", returning[i].ID, "error", err.Error())
+		}
+	}
+}
+
+func (app *application) settleReturn(ctx context.Context, payment *store.Payment) error {
+	// The key is the same every time the payment is retried, so the gateway
+	// gives the money back at most once.
+	key := fmt.Sprintf("%s:%s", payment.ID, payment.Status)
+
+	var (
+		result *payments.Result
+		err    error
+	)
+	switch payment.Status {
+	case payments.StatusVoidPending:
+		result, err = app.payments.Void(ctx, *payment.ProviderRef, key)
+	case payments.StatusRefundPending:
+		result, err = app.payments.Refund(ctx, *payment.ProviderRef, payment.Amount, key)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return app.store.WithTx(ctx, func(s store.Storage) error {
+		// A webhook event may have settled the payment in the meantime.
+		current, err := s.Payments.PaymentGetByID(ctx, payment.ID)
+		if err != nil {
+			return err
+		}
+
+		if current.Status != payment.Status || !current.Status.CanTransitionTo(result.Status) {
+			return nil
+		}
+
+		current.Status = result.Status
+		return s.Payments.PaymentUpdate(ctx, current)
+	})
+}
+
+// retryReturns hands payments that are still waiting to be voided or refunded
+// back to the gateway every interval until ctx is done.
+func (app *application) retryReturns(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			returning, err := app.store.Payments.PaymentGetAllReturning(ctx)
+			if err != nil {
+				app.logger.Errorw("retrying payment returns", "error", err.Error())
+				continue
+			}
+			app.settleReturns(ctx, returning)
+		}
+	}
+}
+
+// failPayment records a payment whose gateway call failed outright before
+// reporting the failure as a server error.
+func (app *application) failPayment(w http.ResponseWriter, r *http.Request, payment *store.Payment, err error) {
+	payment.Status = payments.StatusFailed
+	payment.FailureReason = "gateway_error"
+
+	if updateErr := app.store.Payments.PaymentUpdate(r.Context(), payment); updateErr != nil {
+		err = errors.Join(err, updateErr)
+	}
+
+	app.internalServerError(w, r, err)
+}
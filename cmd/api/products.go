@@ -1,200 +1,558 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
 	"github.com/seanhalberthal/webmart/internal/store"
+	"github.com/seanhalberthal/webmart/internal/tax"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type CreateProductPayload struct {
-	UserID      uuid.UUID      `json:"user_id"`
-	Title       string         `json:"title"`
-	Description string         `json:"description"`
-	Rating      int            `json:"rating"`
-	Price       float64        `json:"price"`
-	Stock       int            `json:"stock"`
-	Version     int            `json:"version"`
-	Reviews     []store.Review `json:"reviews"`
+	Title       string `json:"title" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000"`
+	// Price, Stock and SKU describe the product's only variant when Variants
+	// is empty, and are ignored otherwise.
+	Price    money.Amount           `json:"price" validate:"min=0"`
+	Stock    int                    `json:"stock" validate:"min=0"`
+	SKU      string                 `json:"sku" validate:"omitempty,max=64,printascii"`
+	Variants []CreateVariantPayload `json:"variants" validate:"max=100,dive"`
+	// TaxClass picks the tax rate charged on the product, "standard" by
+	// default. PriceIncludesTax says whether its prices include that tax.
+	TaxClass         string `json:"tax_class" validate:"omitempty,max=32,slug"`
+	PriceIncludesTax bool   `json:"price_includes_tax"`
 }
 
-func getProductID(w http.ResponseWriter, r *http.Request) uuid.UUID {
+var (
+	errNotProductOwner = errors.New("only the seller of a product can change it")
+	errMissingIfMatch  = errors.New("the If-Match header is required; send the ETag from the last read of the product")
+	errStaleIfMatch    = errors.New("the product has been modified since it was read")
+)
+
+func getProductID(r *http.Request) (uuid.UUID, error) {
 	idStr := chi.URLParam(r, "productID")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, err)
+		return uuid.Nil, fmt.Errorf("invalid product ID %q", idStr)
 	}
-	return id
+	return id, nil
+}
+
+// productETag is the strong entity tag of a product: its quoted version.
+func productETag(p *store.Product) string {
+	return strconv.Quote(strconv.Itoa(p.Version))
+}
+
+// ifMatches reports whether the If-Match header lists etag or is "*". Weak
+// tags never match, as If-Match uses strong comparison.
+func ifMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
 }
 
 // CreateProduct godoc
 //
-//	@Summary	Create a new product listing
-//	@Tags		products
-//	@Accept		json
-//	@Produce	json
-//	@Param		body	body		CreateProductPayload	true	"Product creation payload"
-//	@Success	201		{object}	store.Product
-//	@Failure	400		{object}	error
-//	@Failure	500		{object}	error
-//	@Router		/products [post]
+//	@Summary		Create a new product listing
+//	@Description	Lists a product for sale by the authenticated seller. Either list its variants, all priced in the same currency, or give a price and stock for a single variant. SKUs are generated for variants without one. The tax class picks the tax rate charged on the product, and price_includes_tax says whether its prices are gross or net of that tax.
+//	@Tags			products
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		CreateProductPayload	true	"Product creation payload"
+//	@Success		201		{object}	store.Product
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error	"Variants in different currencies, or a SKU or option set is used twice"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products [post]
 func (app *application) createProductHandler(w http.ResponseWriter, r *http.Request) {
 	var payload CreateProductPayload
-	if err := readJSON(w, r, &payload); err != nil {
-		handleError(w, http.StatusBadRequest, err)
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
 		return
 	}
 
+	variants := payload.Variants
+	if len(variants) == 0 {
+		variants = []CreateVariantPayload{{SKU: payload.SKU, Price: payload.Price, Stock: payload.Stock}}
+	}
+
 	listing := &store.Product{
-		UserID:      payload.UserID,
-		Title:       payload.Title,
-		Description: payload.Description,
-		Rating:      payload.Rating,
-		Price:       payload.Price,
-		Stock:       payload.Stock,
-		Version:     payload.Version,
-		Reviews:     payload.Reviews,
+		UserID:           getUserFromContext(r).ID,
+		Title:            payload.Title,
+		Description:      payload.Description,
+		TaxClass:         tax.DefaultClass,
+		PriceIncludesTax: payload.PriceIncludesTax,
+		Variants:         make([]store.ProductVariant, len(variants)),
+		Reviews:          []store.Review{},
+		Images:           []store.ProductImage{},
+		Categories:       []store.CategoryRef{},
+	}
+	if payload.TaxClass != "" {
+		listing.TaxClass = tax.Class(payload.TaxClass)
+	}
+
+	for i, v := range variants {
+		listing.Variants[i] = v.variant()
 	}
 
 	ctx := r.Context()
 
 	if err := app.store.Products.ProductCreate(ctx, listing); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", productETag(listing))
 	if err := writeJSONResponse(w, http.StatusCreated, listing); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 }
 
 // GetProduct godoc
 //
-//	@Summary	Fetches a product by ID
-//	@Tags		products
-//	@Accept		json
-//	@Produce	json
-//	@Param		id	path		int	true	"Product ID"
-//	@Success	200	{object}	store.Product
-//	@Failure	404	{object}	error
-//	@Failure	500	{object}	error
-//	@Router		/products/{id} [get]
+//	@Summary		Fetches a product by ID
+//	@Description	Returns the product with its variants. The product's price is the lowest of its variants' prices and its stock is their total, which warehouses splits by warehouse. available_stock leaves out units held by reservations. Pass a currency to also get prices converted into it, alongside the originals. Prices with no exchange rate into it have no converted_price.
+//	@Tags			products
+//	@Accept			json
+//	@Produce		json
+//	@Param			id				path		int		true	"Product ID"
+//	@Param			currency		query		string	false	"Currency to convert the price into, e.g. EUR"
+//	@Param			Accept-Currency	header		string	false	"Currency to convert the price into if the currency parameter is not set"
+//	@Success		200				{object}	store.Product
+//	@Failure		400				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/products/{id} [get]
 func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := getProductID(w, r)
+
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	converter, err := app.readPriceConverter(w, r)
+	if err != nil {
+		return
+	}
 
 	product, err := app.store.Products.ProductGetByID(ctx, id)
 	if err != nil {
-		handleError(w, http.StatusNotFound, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
+	product.ConvertedPrice = converter.convert(product.Price)
+
+	if product.Variants, err = app.store.ProductVariants.ProductVariantGetAll(ctx, id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	levels, err := app.store.Warehouses.WarehouseGetStockLevels(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	for i := range product.Variants {
+		v := &product.Variants[i]
+		v.ConvertedPrice = converter.convert(v.Price)
+		v.Warehouses = levels[v.ID]
+	}
+	product.Warehouses = store.TotalStockLevels(product.Variants)
+
 	reviews, err := app.store.Reviews.ReviewGet(ctx, id)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	product.Reviews = reviews
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(product); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+	imgs, err := app.store.ProductImages.ProductImageGetAll(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	app.setImageURLs(imgs)
+	product.Images = imgs
+
+	if product.Categories, err = app.store.Categories.CategoryGetByProduct(ctx, id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", productETag(product))
+	if err := writeJSON(w, http.StatusOK, product); err != nil {
+		app.internalServerError(w, r, err)
 		return
 	}
 }
 
+type ProductListQuery struct {
+	Limit    int           `json:"limit" validate:"min=1,max=100"`
+	Cursor   string        `json:"cursor" validate:"max=512"`
+	Sort     string        `json:"sort" validate:"oneof=price -price created_at -created_at rating -rating"`
+	MinPrice *money.Amount `json:"min_price" validate:"omitnil,min=0"`
+	MaxPrice *money.Amount `json:"max_price" validate:"omitnil,min=0"`
+	// PriceCurrency is the currency the price filters and sort use. Only
+	// products priced in it are listed.
+	PriceCurrency money.Currency `json:"price_currency"`
+	UserID        *uuid.UUID     `json:"user_id"`
+	InStock       bool           `json:"in_stock"`
+	Category      string         `json:"category" validate:"omitempty,max=100,slug"`
+}
+
+func parseProductListQuery(r *http.Request) (ProductListQuery, error) {
+	q := r.URL.Query()
+	lq := ProductListQuery{
+		Cursor:   q.Get("cursor"),
+		Sort:     q.Get("sort"),
+		Category: q.Get("category"),
+	}
+
+	if lq.Sort == "" {
+		lq.Sort = "-created_at"
+	}
+
+	var err error
+	if lq.Limit, err = queryInt(q, "limit", store.DefaultPageLimit); err != nil {
+		return lq, err
+	}
+	if lq.PriceCurrency, err = queryCurrency(q, "price_currency"); err != nil {
+		return lq, err
+	}
+	byPrice := q.Get("min_price") != "" || q.Get("max_price") != "" || strings.TrimPrefix(lq.Sort, "-") == "price"
+	if byPrice && lq.PriceCurrency == "" {
+		return lq, errors.New("price_currency is required to filter or sort by price")
+	}
+	if lq.MinPrice, err = queryAmount(q, "min_price", lq.PriceCurrency); err != nil {
+		return lq, err
+	}
+	if lq.MaxPrice, err = queryAmount(q, "max_price", lq.PriceCurrency); err != nil {
+		return lq, err
+	}
+	if lq.UserID, err = queryUUID(q, "user_id"); err != nil {
+		return lq, err
+	}
+	if lq.InStock, err = queryBool(q, "in_stock"); err != nil {
+		return lq, err
+	}
+
+	if lq.MinPrice != nil && lq.MaxPrice != nil && lq.MaxPrice.Minor() < lq.MinPrice.Minor() {
+		return lq, errors.New("max_price must not be less than min_price")
+	}
+
+	return lq, nil
+}
+
+func (lq ProductListQuery) storeQuery() store.ProductQuery {
+	return store.ProductQuery{
+		Limit:    lq.Limit,
+		Cursor:   lq.Cursor,
+		SortBy:   strings.TrimPrefix(lq.Sort, "-"),
+		Desc:     strings.HasPrefix(lq.Sort, "-"),
+		MinPrice: lq.MinPrice,
+		MaxPrice: lq.MaxPrice,
+		Currency: lq.PriceCurrency,
+		UserID:   lq.UserID,
+		InStock:  lq.InStock,
+		Category: lq.Category,
+	}
+}
+
+// GetAllProducts godoc
+//
+//	@Summary		Lists products
+//	@Description	Lists products a page at a time using an opaque cursor. Filtering or sorting by price needs a price_currency, and only lists products priced in it. Pass a currency to also get prices converted into it. Prices with no exchange rate into it have no converted_price.
+//	@Tags			products
+//	@Produce		json
+//	@Param			limit			query		int		false	"Page size (1-100)"	default(20)
+//	@Param			cursor			query		string	false	"Cursor from the previous page's next_cursor"
+//	@Param			sort			query		string	false	"Sort order, prefix with - for descending"	Enums(price, -price, created_at, -created_at, rating, -rating)	default(-created_at)
+//	@Param			min_price		query		number	false	"Minimum price"
+//	@Param			max_price		query		number	false	"Maximum price"
+//	@Param			price_currency	query		string	false	"Currency of min_price and max_price, and of the prices sorted by; required with them"
+//	@Param			user_id			query		string	false	"Only products listed by this user"
+//	@Param			in_stock		query		bool	false	"Only products with stock"
+//	@Param			category		query		string	false	"Only products in the category with this slug or its subcategories"
+//	@Param			currency		query		string	false	"Currency to convert prices into, e.g. EUR"
+//	@Param			Accept-Currency	header		string	false	"Currency to convert prices into if the currency parameter is not set"
+//	@Success		200				{object}	store.ProductPage
+//	@Failure		400				{object}	error
+//	@Failure		422				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/products [get]
 func (app *application) getAllProductsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	products, err := app.store.Products.ProductGetAll(ctx)
+	lq, err := parseProductListQuery(r)
 	if err != nil {
-		handleError(w, http.StatusNotFound, err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(products); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+	if err := Validate.Struct(lq); err != nil {
+		app.failedValidationResponse(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	converter, err := app.readPriceConverter(w, r)
+	if err != nil {
+		return
+	}
+
+	page, err := app.store.Products.ProductGetAll(ctx, lq.storeQuery())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	for i := range page.Products {
+		p := &page.Products[i]
+		p.ConvertedPrice = converter.convert(p.Price)
+	}
+
+	if err := writeJSON(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ProductSearchParams struct {
+	Query  string `json:"q" validate:"required,max=200"`
+	Limit  int    `json:"limit" validate:"min=1,max=100"`
+	Cursor string `json:"cursor" validate:"max=512"`
+}
+
+// SearchProducts godoc
+//
+//	@Summary		Searches products
+//	@Description	Full-text search over product titles and descriptions, ranked by relevance. Terms are ANDed, "quoted phrases" match in order and a trailing * matches a prefix.
+//	@Tags			products
+//	@Produce		json
+//	@Param			q				query		string	true	"Search terms"
+//	@Param			limit			query		int		false	"Page size (1-100)"	default(20)
+//	@Param			cursor			query		string	false	"Cursor from the previous page's next_cursor"
+//	@Param			currency		query		string	false	"Currency to convert prices into, e.g. EUR"
+//	@Param			Accept-Currency	header		string	false	"Currency to convert prices into if the currency parameter is not set"
+//	@Success		200				{object}	store.ProductSearchPage
+//	@Failure		400				{object}	error
+//	@Failure		422				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/products/search [get]
+func (app *application) searchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := ProductSearchParams{
+		Query:  q.Get("q"),
+		Cursor: q.Get("cursor"),
+	}
+
+	var err error
+	if params.Limit, err = queryInt(q, "limit", store.DefaultPageLimit); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(params); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	converter, err := app.readPriceConverter(w, r)
+	if err != nil {
+		return
+	}
+
+	page, err := app.store.Products.ProductSearch(r.Context(), store.ProductSearchQuery{
+		Query:  params.Query,
+		Limit:  params.Limit,
+		Cursor: params.Cursor,
+	})
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	for i := range page.Results {
+		p := &page.Results[i]
+		p.ConvertedPrice = converter.convert(p.Price)
+	}
+
+	if err := writeJSON(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
 }
 
 // DeleteProduct godoc
 //
-//	@Summary	Delete product by ID
-//	@Tags		products
-//	@Param		id	path		int		true	"Product ID"
-//	@Success	204	{string}	string	"Product deleted successfully"
-//	@Failure	404	{object}	error
-//	@Router		/products/{id} [delete]
+//	@Summary		Delete product by ID
+//	@Description	Only the product's seller or an admin may delete it
+//	@Tags			products
+//	@Param			id	path		int		true	"Product ID"
+//	@Success		204	{string}	string	"Product deleted successfully"
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{id} [delete]
 func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := getProductID(w, r)
 
-	err := app.store.Products.ProductDelete(ctx, id)
+	id, err := getProductID(r)
 	if err != nil {
-		handleError(w, http.StatusNotFound, err)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Products.ProductDelete(ctx, id); err != nil {
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// UpdateProductPayload is a product's editable fields. Patches are applied to
+// the product's current fields in this form, and every field must still be
+// present afterwards, so a patch can change fields but not remove them.
+// Prices and stock are edited through the product's variants.
 type UpdateProductPayload struct {
-	Title       string  `json:"title" validate:"omitempty,min=1,max=100"`
-	Description string  `json:"description" validate:"omitempty,min=1,max=100"`
-	Price       float64 `json:"price" validate:"omitempty,min=0"`
-	Stock       int     `json:"stock" validate:"omitempty,min=0"`
+	Title            *string    `json:"title" validate:"required,min=1,max=100"`
+	Description      *string    `json:"description" validate:"required,max=1000"`
+	TaxClass         *tax.Class `json:"tax_class" validate:"required,max=32,slug"`
+	PriceIncludesTax *bool      `json:"price_includes_tax" validate:"required"`
 }
 
 // UpdateProduct godoc
 //
 //	@Summary		Update a product
-//	@Description	Updates an existing product by ID
+//	@Description	Partially updates a product. The body is either a JSON Merge Patch (RFC 7396), sent as application/merge-patch+json or application/json, or a JSON Patch (RFC 6902), sent as application/json-patch+json. Only the fields a patch touches change. Prices and stock belong to the product's variants and are changed through them. Only the product's seller or an admin may update it. The If-Match header must carry the ETag from the last read of the product, so that concurrent edits are never silently overwritten. The ETag changes when the product or its variants are edited, but not when stock is sold, reserved or moved.
 //	@Tags			products
 //	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
 //	@Produce		json
-//	@Param			id		path		int						true	"Product ID"
-//	@Param			body	body		UpdateProductPayload	true	"Updated product data"
-//	@Success		200		{object}	store.Product
-//	@Failure		400		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Param			id			path		int						true	"Product ID"
+//	@Param			If-Match	header		string					true	"ETag of the product as last read"
+//	@Param			body		body		UpdateProductPayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	store.Product
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"A JSON Patch path is missing or a test operation failed"
+//	@Failure		412			{object}	error	"The product has changed since it was read"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		428			{object}	error	"If-Match is missing"
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
 //	@Router			/products/{id} [patch]
 func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := getProductID(w, r)
 
-	var payload UpdateProductPayload
-	if err := readJSON(w, r, &payload); err != nil {
-		handleError(w, http.StatusBadRequest, err)
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	product, err := app.store.Products.ProductGetByID(ctx, id)
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.preconditionRequiredResponse(w, r, errMissingIfMatch)
+		return
+	}
+
+	product, err := app.getOwnedProduct(r, id)
 	if err != nil {
-		handleError(w, http.StatusNotFound, err)
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
 		return
 	}
 
-	product.Title = payload.Title
-	product.Description = payload.Description
-	product.Price = payload.Price
-	product.Stock = payload.Stock
+	// The store only saves the product if it is still at the version read
+	// here, which catches edits made between this check and the update.
+	if !ifMatches(ifMatch, productETag(product)) {
+		app.preconditionFailedResponse(w, r, errStaleIfMatch)
+		return
+	}
 
-	err = app.store.Products.ProductUpdate(ctx, product)
-	if err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+	current := UpdateProductPayload{
+		Title:            &product.Title,
+		Description:      &product.Description,
+		TaxClass:         &product.TaxClass,
+		PriceIncludesTax: &product.PriceIncludesTax,
+	}
+
+	var payload UpdateProductPayload
+	if err := app.readPatch(w, r, current, &payload); err != nil {
 		return
 	}
 
+	product.Title = *payload.Title
+	product.Description = *payload.Description
+	product.TaxClass = *payload.TaxClass
+	product.PriceIncludesTax = *payload.PriceIncludesTax
+
+	if err := app.store.Products.ProductUpdate(ctx, product); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", productETag(product))
 	if err := writeJSONResponse(w, http.StatusOK, product); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 		return
 	}
 }
+
+// getOwnedProduct loads a product, checking that the authenticated user is
+// its seller or may manage every product.
+func (app *application) getOwnedProduct(r *http.Request, productID uuid.UUID) (*store.Product, error) {
+	product, err := app.store.Products.ProductGetByID(r.Context(), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := getUserFromContext(r)
+	if product.UserID != user.ID && !user.Role.Can(store.PermProductsManage) {
+		return nil, errNotProductOwner
+	}
+
+	return product, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type CreatePromotionPayload struct {
+	// Code is what buyers enter to redeem the promotion. Codes are not case
+	// sensitive.
+	Code        string              `json:"code" validate:"required,max=50,promocode"`
+	Description string              `json:"description" validate:"max=500"`
+	Kind        store.PromotionKind `json:"kind" validate:"required,oneof=percentage fixed"`
+	// Percent is the percentage off, e.g. "12.5", of percentage promotions.
+	Percent string `json:"percent" validate:"omitempty,max=10"`
+	// Amount is the amount off of fixed promotions.
+	Amount         *money.Amount `json:"amount" validate:"omitempty,min=1"`
+	MinBasket      *money.Amount `json:"min_basket" validate:"omitempty,min=1"`
+	MaxUses        *int          `json:"max_uses" validate:"omitempty,min=1"`
+	MaxUsesPerUser *int          `json:"max_uses_per_user" validate:"omitempty,min=1"`
+	StartsAt       *time.Time    `json:"starts_at"`
+	EndsAt         *time.Time    `json:"ends_at"`
+	Stackable      bool          `json:"stackable"`
+	Priority       int           `json:"priority" validate:"min=0,max=1000"`
+	// Active defaults to true.
+	Active      *bool       `json:"active"`
+	ProductIDs  []uuid.UUID `json:"product_ids" validate:"max=100,unique"`
+	CategoryIDs []uuid.UUID `json:"category_ids" validate:"max=100,unique"`
+}
+
+// UpdatePromotionPayload is a promotion's editable fields, patched like
+// UpdateProductPayload. Removing an optional field, such as max_uses or
+// ends_at, clears it.
+type UpdatePromotionPayload struct {
+	Code           *string              `json:"code" validate:"required,max=50,promocode"`
+	Description    *string              `json:"description" validate:"required,max=500"`
+	Kind           *store.PromotionKind `json:"kind" validate:"required,oneof=percentage fixed"`
+	Percent        *string              `json:"percent,omitempty" validate:"omitempty,max=10"`
+	Amount         *money.Amount        `json:"amount,omitempty" validate:"omitempty,min=1"`
+	MinBasket      *money.Amount        `json:"min_basket,omitempty" validate:"omitempty,min=1"`
+	MaxUses        *int                 `json:"max_uses,omitempty" validate:"omitempty,min=1"`
+	MaxUsesPerUser *int                 `json:"max_uses_per_user,omitempty" validate:"omitempty,min=1"`
+	StartsAt       *time.Time           `json:"starts_at,omitempty"`
+	EndsAt         *time.Time           `json:"ends_at,omitempty"`
+	Stackable      *bool                `json:"stackable" validate:"required"`
+	Priority       *int                 `json:"priority" validate:"required,min=0,max=1000"`
+	Active         *bool                `json:"active" validate:"required"`
+	ProductIDs     *[]uuid.UUID         `json:"product_ids" validate:"required,max=100,unique"`
+	CategoryIDs    *[]uuid.UUID         `json:"category_ids" validate:"required,max=100,unique"`
+}
+
+type BasketItemPayload struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	// VariantID picks the variant to price. Without it the product's lowest
+	// price is used.
+	VariantID *uuid.UUID `json:"variant_id"`
+	Quantity  int        `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
+type EvaluatePromotionsPayload struct {
+	Codes []string            `json:"codes" validate:"max=10,dive,required,max=50"`
+	Items []BasketItemPayload `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+type RedeemPromotionsPayload struct {
+	Codes []string            `json:"codes" validate:"required,min=1,max=10,dive,required,max=50"`
+	Items []BasketItemPayload `json:"items" validate:"required,min=1,max=100,dive"`
+	// OrderID is the user's order the promotions are redeemed against, if
+	// any. Each promotion can be redeemed once per order.
+	OrderID *uuid.UUID `json:"order_id"`
+}
+
+var promoCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func getPromotionID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "promotionID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid promotion ID %q", idStr)
+	}
+	return id, nil
+}
+
+// GetPromotions godoc
+//
+//	@Summary		Lists promotions
+//	@Description	Lists every promotion, newest first, with how often each has been redeemed.
+//	@Tags			promotions
+//	@Produce		json
+//	@Success		200	{array}		store.Promotion
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions [get]
+func (app *application) getPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	promotions, err := app.store.Promotions.PromotionGetAll(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, promotions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetPromotion godoc
+//
+//	@Summary		Fetches a promotion
+//	@Description	Returns a promotion with how often it has been redeemed.
+//	@Tags			promotions
+//	@Produce		json
+//	@Param			promotionID	path		string	true	"Promotion ID"
+//	@Success		200			{object}	store.Promotion
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions/{promotionID} [get]
+func (app *application) getPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getPromotionID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	promotion, err := app.store.Promotions.PromotionGetByID(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, promotion); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreatePromotion godoc
+//
+//	@Summary		Creates a promotion
+//	@Description	Creates a percentage or fixed-amount coupon. A promotion can require a minimum basket value, limit its uses in total and per user, run for a window of time and be narrowed to products and categories. Stackable promotions combine with each other; the others are only applied alone.
+//	@Tags			promotions
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreatePromotionPayload	true	"Promotion"
+//	@Success		201		{object}	store.Promotion
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error	"No such product or category"
+//	@Failure		409		{object}	error	"Code already in use"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions [post]
+func (app *application) createPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreatePromotionPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	promotion := &store.Promotion{
+		Code:           strings.ToUpper(payload.Code),
+		Description:    payload.Description,
+		Kind:           payload.Kind,
+		Percent:        payload.Percent,
+		Amount:         payload.Amount,
+		MinBasket:      payload.MinBasket,
+		MaxUses:        payload.MaxUses,
+		MaxUsesPerUser: payload.MaxUsesPerUser,
+		StartsAt:       payload.StartsAt,
+		EndsAt:         payload.EndsAt,
+		Stackable:      payload.Stackable,
+		Priority:       payload.Priority,
+		Active:         payload.Active == nil || *payload.Active,
+		ProductIDs:     payload.ProductIDs,
+		CategoryIDs:    payload.CategoryIDs,
+	}
+
+	if err := app.store.Promotions.PromotionCreate(r.Context(), promotion); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, promotion); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdatePromotion godoc
+//
+//	@Summary		Updates a promotion
+//	@Description	Partially updates a promotion with a JSON Merge Patch or JSON Patch, as for products. Discounts already redeemed are not changed.
+//	@Tags			promotions
+//	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json
+//	@Param			promotionID	path		string					true	"Promotion ID"
+//	@Param			payload		body		UpdatePromotionPayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	store.Promotion
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Code already in use"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions/{promotionID} [patch]
+func (app *application) updatePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getPromotionID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	promotion, err := app.store.Promotions.PromotionGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	current := UpdatePromotionPayload{
+		Code:           &promotion.Code,
+		Description:    &promotion.Description,
+		Kind:           &promotion.Kind,
+		Amount:         promotion.Amount,
+		MinBasket:      promotion.MinBasket,
+		MaxUses:        promotion.MaxUses,
+		MaxUsesPerUser: promotion.MaxUsesPerUser,
+		StartsAt:       promotion.StartsAt,
+		EndsAt:         promotion.EndsAt,
+		Stackable:      &promotion.Stackable,
+		Priority:       &promotion.Priority,
+		Active:         &promotion.Active,
+		ProductIDs:     &promotion.ProductIDs,
+		CategoryIDs:    &promotion.CategoryIDs,
+	}
+	if promotion.Percent != "" {
+		current.Percent = &promotion.Percent
+	}
+
+	var payload UpdatePromotionPayload
+	if err := app.readPatch(w, r, current, &payload); err != nil {
+		return
+	}
+
+	promotion.Code = strings.ToUpper(*payload.Code)
+	promotion.Description = *payload.Description
+	promotion.Kind = *payload.Kind
+	promotion.Percent = ""
+	if payload.Percent != nil {
+		promotion.Percent = *payload.Percent
+	}
+	promotion.Amount = payload.Amount
+	promotion.MinBasket = payload.MinBasket
+	promotion.MaxUses = payload.MaxUses
+	promotion.MaxUsesPerUser = payload.MaxUsesPerUser
+	promotion.StartsAt = payload.StartsAt
+	promotion.EndsAt = payload.EndsAt
+	promotion.Stackable = *payload.Stackable
+	promotion.Priority = *payload.Priority
+	promotion.Active = *payload.Active
+	promotion.ProductIDs = *payload.ProductIDs
+	promotion.CategoryIDs = *payload.CategoryIDs
+
+	if err := app.store.Promotions.PromotionUpdate(ctx, promotion); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, promotion); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeletePromotion godoc
+//
+//	@Summary		Deletes a promotion
+//	@Description	Deletes a promotion and its redemption history. Deactivate a promotion instead to keep its history.
+//	@Tags			promotions
+//	@Param			promotionID	path	string	true	"Promotion ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions/{promotionID} [delete]
+func (app *application) deletePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getPromotionID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Promotions.PromotionDelete(r.Context(), id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EvaluatePromotions godoc
+//
+//	@Summary		Prices a basket with promotions
+//	@Description	Prices a list of products and quantities and applies the promotions with the given codes, without redeeming them. The response shows each applied promotion's discount, overall and per line, and why any code was not applied. When a promotion that cannot be stacked competes with stackable ones, whichever gives the larger discount is applied.
+//	@Tags			promotions
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		EvaluatePromotionsPayload	true	"Basket and promotion codes"
+//	@Success		200		{object}	store.PromotionEvaluation
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error	"No such product or variant"
+//	@Failure		409		{object}	error	"The basket mixes currencies"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions/evaluate [post]
+func (app *application) evaluatePromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload EvaluatePromotionsPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	eval, err := app.store.Promotions.PromotionEvaluate(r.Context(), getUserFromContext(r).ID, promoCodes(payload.Codes), basketItems(payload.Items))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, eval); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RedeemPromotions godoc
+//
+//	@Summary		Redeems promotions
+//	@Description	Prices a basket as the evaluate endpoint does and records a use of each promotion applied, optionally against one of the user's orders. Usage limits are checked and the uses recorded atomically, so concurrent redemptions cannot take a promotion past its limits.
+//	@Tags			promotions
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RedeemPromotionsPayload	true	"Basket and promotion codes"
+//	@Success		201		{object}	store.PromotionEvaluation
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error	"No such product, variant or order"
+//	@Failure		409		{object}	error	"No promotion applies, or one was already redeemed on the order"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/promotions/redeem [post]
+func (app *application) redeemPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RedeemPromotionsPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	eval, err := app.store.Promotions.PromotionRedeem(r.Context(), getUserFromContext(r).ID, promoCodes(payload.Codes), basketItems(payload.Items), payload.OrderID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, eval); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// promoCodes normalises codes as entered to the upper case they are stored in.
+func promoCodes(codes []string) []string {
+	normalised := make([]string, len(codes))
+	for i, code := range codes {
+		normalised[i] = strings.ToUpper(strings.TrimSpace(code))
+	}
+	return normalised
+}
+
+func basketItems(payload []BasketItemPayload) []store.BasketItem {
+	items := make([]store.BasketItem, len(payload))
+	for i, item := range payload {
+		items[i] = store.BasketItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+	}
+	return items
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"net/url"
+	"strconv"
+)
+
+func queryInt(q url.Values, key string, fallback int) (int, error) {
+	s := q.Get(key)
+	if s == "" {
+		return fallback, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+
+	return i, nil
+}
+
+func queryAmount(q url.Values, key string, currency money.Currency) (*money.Amount, error) {
+	s := q.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	a, err := money.Parse(s, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an amount with at most %d decimal places", key, currency.Exponent())
+	}
+
+	return &a, nil
+}
+
+func queryCurrency(q url.Values, key string) (money.Currency, error) {
+	s := q.Get(key)
+	if s == "" {
+		return "", nil
+	}
+
+	return money.ParseCurrency(s)
+}
+
+func queryBool(q url.Values, key string) (bool, error) {
+	s := q.Get(key)
+	if s == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean", key)
+	}
+
+	return b, nil
+}
+
+func queryUUID(q url.Values, key string) (*uuid.UUID, error) {
+	s := q.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a valid UUID", key)
+	}
+
+	return &id, nil
+}
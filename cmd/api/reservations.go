@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+	"time"
+)
+
+type reservationsConfig struct {
+	// ttl is how long a hold lasts when the client does not say.
+	ttl time.Duration
+	// maxTTL caps the hold length a client may ask for.
+	maxTTL time.Duration
+	// sweepInterval is how often expired holds are marked as such.
+	sweepInterval time.Duration
+}
+
+type CreateReservationPayload struct {
+	VariantID uuid.UUID `json:"variant_id" validate:"required"`
+	Quantity  int       `json:"quantity" validate:"required,min=1,max=1000"`
+	// TTLSeconds is how long to hold the stock for, up to the configured
+	// maximum. It defaults to the configured hold length.
+	TTLSeconds int `json:"ttl_seconds" validate:"omitempty,min=30"`
+}
+
+func getReservationID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "reservationID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid reservation ID %q", idStr)
+	}
+	return id, nil
+}
+
+// CreateReservation godoc
+//
+//	@Summary		Reserves a variant's stock
+//	@Description	Holds units of a variant for the user for a limited time. While the hold is active the units are taken out of the available_stock other buyers see and cannot be bought by them. Checking out the variant, or committing the hold, uses it up.
+//	@Tags			reservations
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string						true	"Product ID"
+//	@Param			payload		body		CreateReservationPayload	true	"Reservation"
+//	@Success		201			{object}	store.StockReservation
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error	"No such product or variant"
+//	@Failure		409			{object}	error	"Not enough available stock"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/reservations [post]
+func (app *application) createReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CreateReservationPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	ttl := app.config.reservations.ttl
+	if payload.TTLSeconds > 0 {
+		ttl = time.Duration(payload.TTLSeconds) * time.Second
+	}
+	if ttl > app.config.reservations.maxTTL {
+		app.badRequestResponse(w, r, fmt.Errorf("ttl_seconds must be at most %d", int(app.config.reservations.maxTTL.Seconds())))
+		return
+	}
+
+	reservation := &store.StockReservation{
+		ProductID: id,
+		VariantID: payload.VariantID,
+		UserID:    getUserFromContext(r).ID,
+		Quantity:  payload.Quantity,
+	}
+
+	if err := app.store.Reservations.ReservationCreate(r.Context(), reservation, ttl); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, reservation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CommitReservation godoc
+//
+//	@Summary		Commits a reservation
+//	@Description	Turns an active hold into a permanent decrement of the variant's stock, recorded in the inventory ledger. Only the user who made the reservation or an admin may commit it.
+//	@Tags			reservations
+//	@Produce		json
+//	@Param			reservationID	path		string	true	"Reservation ID"
+//	@Success		200				{object}	store.StockReservation
+//	@Failure		400				{object}	error
+//	@Failure		401				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		409				{object}	error	"The reservation is no longer active"
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/reservations/{reservationID}/commit [post]
+func (app *application) commitReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := app.getHeldReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.store.Reservations.ReservationCommit(r.Context(), reservation, getUserFromContext(r).ID, app.config.warehouses.strategy)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, reservation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ReleaseReservation godoc
+//
+//	@Summary		Releases a reservation
+//	@Description	Ends an active hold early, returning its units to the available stock. Only the user who made the reservation or an admin may release it.
+//	@Tags			reservations
+//	@Param			reservationID	path	string	true	"Reservation ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error	"The reservation is no longer active"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/reservations/{reservationID} [delete]
+func (app *application) releaseReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := app.getHeldReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.store.Reservations.ReservationRelease(r.Context(), reservation); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getHeldReservation loads the reservation named in the URL, writing an error
+// response and returning false if it cannot be found or belongs to someone
+// else.
+func (app *application) getHeldReservation(w http.ResponseWriter, r *http.Request) (*store.StockReservation, bool) {
+	id, err := getReservationID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	reservation, err := app.store.Reservations.ReservationGetByID(r.Context(), id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return nil, false
+	}
+
+	// Report other people's reservations as missing rather than revealing
+	// that they exist.
+	user := getUserFromContext(r)
+	if reservation.UserID != user.ID && !user.Role.Can(store.PermOrdersManage) {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return nil, false
+	}
+
+	return reservation, true
+}
+
+// sweepReservations marks expired holds as such every interval until ctx is
+// done. Expired holds stop counting against stock as soon as they run out,
+// so the sweep only keeps their status honest.
+func (app *application) sweepReservations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.store.Reservations.ReservationExpire(ctx)
+			if err != nil {
+				app.logger.Errorw("sweeping reservations", "error", err.Error())
+				continue
+			}
+			if n > 0 {
+				app.logger.Infow("expired reservations", "count", n)
+			}
+		}
+	}
+}
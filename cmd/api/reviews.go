@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+)
+
+var errNotReviewAuthor = errors.New("only the author of a review can change it")
+
+type CreateReviewPayload struct {
+	Content string `json:"content" validate:"required,max=1000"`
+	Stars   int    `json:"stars" validate:"required,min=1,max=5"`
+}
+
+type UpdateReviewPayload struct {
+	Content *string `json:"content" validate:"omitnil,min=1,max=1000"`
+	Stars   *int    `json:"stars" validate:"omitnil,min=1,max=5"`
+}
+
+func getReviewID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "reviewID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid review ID %q", idStr)
+	}
+	return id, nil
+}
+
+// CreateReview godoc
+//
+//	@Summary		Reviews a product
+//	@Description	Adds the authenticated user's star rating and review to a product
+//	@Tags			reviews
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string				true	"Product ID"
+//	@Param			payload		body		CreateReviewPayload	true	"Review"
+//	@Success		201			{object}	store.Review
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/reviews [post]
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CreateReviewPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	user := getUserFromContext(r)
+	review := &store.Review{
+		ProductID: productID,
+		UserID:    user.ID,
+		Content:   payload.Content,
+		Stars:     payload.Stars,
+		User:      store.User{ID: user.ID, Username: user.Username},
+	}
+
+	if err := app.store.Reviews.ReviewCreate(r.Context(), review); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, review); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetProductReviews godoc
+//
+//	@Summary	Lists a product's reviews
+//	@Tags		reviews
+//	@Produce	json
+//	@Param		productID	path		string	true	"Product ID"
+//	@Success	200			{array}		store.Review
+//	@Failure	400			{object}	error
+//	@Failure	404			{object}	error
+//	@Failure	500			{object}	error
+//	@Router		/products/{productID}/reviews [get]
+func (app *application) getProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	productID, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.store.Products.ProductGetByID(ctx, productID); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	reviews, err := app.store.Reviews.ReviewGet(ctx, productID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, reviews); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// UpdateReview godoc
+//
+//	@Summary		Updates a review
+//	@Description	Changes the content or star rating of a review. Only its author may do so.
+//	@Tags			reviews
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string				true	"Product ID"
+//	@Param			reviewID	path		string				true	"Review ID"
+//	@Param			payload		body		UpdateReviewPayload	true	"Fields to change"
+//	@Success		200			{object}	store.Review
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/reviews/{reviewID} [patch]
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reviewID, err := getReviewID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review, err := app.getAuthoredReview(r, productID, reviewID, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotReviewAuthor):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	var payload UpdateReviewPayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if payload.Content != nil {
+		review.Content = *payload.Content
+	}
+	if payload.Stars != nil {
+		review.Stars = *payload.Stars
+	}
+
+	if err := app.store.Reviews.ReviewUpdate(r.Context(), review); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, review); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DeleteReview godoc
+//
+//	@Summary		Deletes a review
+//	@Description	Removes a review. Only its author or a moderator may do so.
+//	@Tags			reviews
+//	@Param			productID	path		string	true	"Product ID"
+//	@Param			reviewID	path		string	true	"Review ID"
+//	@Success		204			{string}	string	"Review deleted"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/reviews/{reviewID} [delete]
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reviewID, err := getReviewID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review, err := app.getAuthoredReview(r, productID, reviewID, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotReviewAuthor):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Reviews.ReviewDelete(r.Context(), review); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getAuthoredReview loads a review, checking that it belongs to the product
+// in the URL and was written by the authenticated user. If allowModerators is
+// set, users who may moderate reviews pass the check too.
+func (app *application) getAuthoredReview(r *http.Request, productID, reviewID uuid.UUID, allowModerators bool) (*store.Review, error) {
+	review, err := app.store.Reviews.ReviewGetByID(r.Context(), reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.ProductID != productID {
+		return nil, store.ErrNotFound
+	}
+
+	user := getUserFromContext(r)
+	if review.UserID != user.ID && !(allowModerators && user.Role.Can(store.PermReviewsModerate)) {
+		return nil, errNotReviewAuthor
+	}
+
+	return review, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+)
+
+type UpdateUserRolePayload struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+// GetRoles godoc
+//
+//	@Summary	Lists roles
+//	@Tags		roles
+//	@Produce	json
+//	@Success	200	{array}		store.Role
+//	@Failure	401	{object}	error
+//	@Failure	403	{object}	error
+//	@Failure	500	{object}	error
+//	@Security	ApiKeyAuth
+//	@Router		/roles [get]
+func (app *application) getRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.RoleGetAll(r.Context())
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateUserRole godoc
+//
+//	@Summary		Changes a user's role
+//	@Description	Gives a user another role, e.g. to let a buyer sell
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		string					true	"User ID"
+//	@Param			payload	body		UpdateUserRolePayload	true	"Role name"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error	"No such user or role"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/role [put]
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getUserID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload UpdateUserRolePayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	if err := app.store.Users.UserSetRole(ctx, id, payload.Role); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.UserGet(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
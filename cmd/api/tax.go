@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/tax"
+	"net/http"
+	"strings"
+)
+
+type CreateTaxRatePayload struct {
+	// Country is an ISO 3166-1 alpha-2 code, e.g. "GB".
+	Country string `json:"country" validate:"required,len=2,alpha"`
+	// Region is an ISO 3166-2 code within Country, e.g. "US-CA", for a rate
+	// that only applies there. Leave it out for a country-wide rate.
+	Region   string `json:"region" validate:"omitempty,max=10"`
+	TaxClass string `json:"tax_class" validate:"required,max=32,slug"`
+	// Percent is the percentage charged, e.g. "20" or "7.25".
+	Percent string `json:"percent" validate:"required,max=9"`
+	Name    string `json:"name" validate:"max=50"`
+}
+
+// UpdateTaxRatePayload is a tax rate's editable fields, patched like
+// UpdateProductPayload.
+type UpdateTaxRatePayload struct {
+	Country  *string    `json:"country" validate:"required,len=2,alpha"`
+	Region   *string    `json:"region" validate:"required,max=10"`
+	TaxClass *tax.Class `json:"tax_class" validate:"required,max=32,slug"`
+	Percent  *string    `json:"percent" validate:"required,min=1,max=9"`
+	Name     *string    `json:"name" validate:"required,max=50"`
+}
+
+type TaxDestinationPayload struct {
+	Country string `json:"country" validate:"required,len=2,alpha"`
+	Region  string `json:"region" validate:"omitempty,max=10"`
+}
+
+type TaxQuotePayload struct {
+	Destination TaxDestinationPayload `json:"destination"`
+	Items       []BasketItemPayload   `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+func getTaxRateID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "taxRateID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid tax rate ID %q", idStr)
+	}
+	return id, nil
+}
+
+// CreateTaxQuote godoc
+//
+//	@Summary		Quotes the tax on a basket
+//	@Description	Works out the tax due on a list of products and quantities delivered to a destination, line by line. Each product's tax class picks the rate charged: the destination region's rate if it has one, or else its country's. Lines with no rate are not taxed. Prices that include tax are split into net and tax; prices that do not have tax added. Tax is rounded half up to the minor unit on each line.
+//	@Tags			tax
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TaxQuotePayload	true	"Basket and destination"
+//	@Success		200		{object}	tax.Quote
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error	"No such product or variant"
+//	@Failure		409		{object}	error	"The basket mixes currencies"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/tax/quote [post]
+func (app *application) createTaxQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TaxQuotePayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	dest := tax.Destination{
+		Country: strings.ToUpper(payload.Destination.Country),
+		Region:  strings.ToUpper(payload.Destination.Region),
+	}
+
+	quote, err := app.store.TaxRates.TaxQuote(r.Context(), dest, basketItems(payload.Items))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, quote); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GetTaxRates godoc
+//
+//	@Summary		Lists tax rates
+//	@Description	Lists the tax rate table, ordered by country, region and tax class.
+//	@Tags			tax
+//	@Produce		json
+//	@Param			country	query		string	false	"Only list the rates of this country"
+//	@Success		200		{array}		tax.Rate
+//	@Failure		500		{object}	error
+//	@Router			/tax/rates [get]
+func (app *application) getTaxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.store.TaxRates.TaxRateGetAll(r.Context(), r.URL.Query().Get("country"))
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, rates); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateTaxRate godoc
+//
+//	@Summary		Creates a tax rate
+//	@Description	Adds a rate to the tax rate table, charged on products of a tax class delivered to a country or, when a region is given, to that region only.
+//	@Tags			tax
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateTaxRatePayload	true	"Tax rate"
+//	@Success		201		{object}	tax.Rate
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error	"A rate for the destination and tax class already exists"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tax/rates [post]
+func (app *application) createTaxRateHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateTaxRatePayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	rate := &tax.Rate{
+		Country: payload.Country,
+		Region:  payload.Region,
+		Class:   tax.Class(payload.TaxClass),
+		Percent: payload.Percent,
+		Name:    payload.Name,
+	}
+
+	if err := app.store.TaxRates.TaxRateCreate(r.Context(), rate); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, rate); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateTaxRate godoc
+//
+//	@Summary		Updates a tax rate
+//	@Description	Partially updates a tax rate with a JSON Merge Patch or JSON Patch, as for products. Quotes use the new rate straight away.
+//	@Tags			tax
+//	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json
+//	@Param			taxRateID	path		string					true	"Tax rate ID"
+//	@Param			payload		body		UpdateTaxRatePayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	tax.Rate
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"A rate for the destination and tax class already exists"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tax/rates/{taxRateID} [patch]
+func (app *application) updateTaxRateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getTaxRateID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate, err := app.store.TaxRates.TaxRateGetByID(ctx, id)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	current := UpdateTaxRatePayload{
+		Country:  &rate.Country,
+		Region:   &rate.Region,
+		TaxClass: &rate.Class,
+		Percent:  &rate.Percent,
+		Name:     &rate.Name,
+	}
+
+	var payload UpdateTaxRatePayload
+	if err := app.readPatch(w, r, current, &payload); err != nil {
+		return
+	}
+
+	rate.Country = *payload.Country
+	rate.Region = *payload.Region
+	rate.Class = *payload.TaxClass
+	rate.Percent = *payload.Percent
+	rate.Name = *payload.Name
+
+	if err := app.store.TaxRates.TaxRateUpdate(ctx, rate); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, rate); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteTaxRate godoc
+//
+//	@Summary		Deletes a tax rate
+//	@Description	Removes a rate from the tax rate table. Products it applied to are no longer taxed at the destination, unless a country-wide rate takes over from a deleted regional one.
+//	@Tags			tax
+//	@Param			taxRateID	path	string	true	"Tax rate ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tax/rates/{taxRateID} [delete]
+func (app *application) deleteTaxRateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getTaxRateID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.TaxRates.TaxRateDelete(r.Context(), id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/seanhalberthal/webmart/internal/store"
@@ -9,20 +10,20 @@ import (
 )
 
 type CreateUserPayload struct {
-	Name      string    `json:"name"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
+	Name      string    `json:"name" validate:"required,max=255"`
+	Username  string    `json:"username" validate:"required,max=100"`
+	Email     string    `json:"email" validate:"required,email,max=255"`
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
-func getUserID(w http.ResponseWriter, r *http.Request) uuid.UUID {
+func getUserID(r *http.Request) (uuid.UUID, error) {
 	idStr := chi.URLParam(r, "userID")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		handleError(w, http.StatusBadRequest, err)
+		return uuid.Nil, fmt.Errorf("invalid user ID %q", idStr)
 	}
-	return id
+	return id, nil
 }
 
 // CreateUser godoc
@@ -34,13 +35,14 @@ func getUserID(w http.ResponseWriter, r *http.Request) uuid.UUID {
 //	@Param		user	body		CreateUserPayload	true	"User creation payload"
 //	@Success	201		{object}	store.User
 //	@Failure	400		{object}	error
+//	@Failure	422		{object}	error
+//	@Failure	409		{object}	error
 //	@Failure	500		{object}	error
 //	@Security	ApiKeyAuth
 //	@Router		/users [post]
 func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	var payload CreateUserPayload
-	if err := readJSON(w, r, &payload); err != nil {
-		handleError(w, http.StatusBadRequest, err)
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
 		return
 	}
 
@@ -55,11 +57,12 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	ctx := r.Context()
 
 	if err := app.store.Users.UserCreate(ctx, user); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.errorResponse(w, r, err)
+		return
 	}
 
 	if err := writeJSONResponse(w, http.StatusCreated, user); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
 	}
 }
 
@@ -72,21 +75,48 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 //	@Param		id	path		int	true	"User ID"
 //	@Success	200	{object}	store.User
 //	@Failure	400	{object}	error
+//	@Failure	401	{object}	error
 //	@Failure	404	{object}	error
 //	@Failure	500	{object}	error
 //	@Security	ApiKeyAuth
 //	@Router		/users/{id} [get]
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	id := getUserID(w, r)
+
+	id, err := getUserID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 
 	user, err := app.store.Users.UserGet(ctx, id)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	if err := writeJSONResponse(w, http.StatusOK, user); err != nil {
-		handleError(w, http.StatusInternalServerError, err)
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ActivateUser godoc
+//
+//	@Summary		Activates a user
+//	@Description	Activates a user account using the token from their invitation email
+//	@Tags			users
+//	@Param			token	path		string	true	"Invitation token"
+//	@Success		204		{string}	string	"User activated"
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/users/activate/{token} [put]
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	if err := app.store.Users.UserActivate(r.Context(), token); err != nil {
+		app.errorResponse(w, r, err)
+		return
 	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+)
+
+// CreateVariantPayload is a new variant. Its stock is booked into the
+// inventory ledger as a receipt.
+type CreateVariantPayload struct {
+	// SKU defaults to one made from the product and variant IDs.
+	SKU string `json:"sku" validate:"omitempty,max=64,printascii"`
+	// Options names the variant, e.g. {"colour": "black"}. Each variant of a
+	// product needs a different set.
+	Options map[string]string `json:"options" validate:"max=10,dive,keys,min=1,max=50,endkeys,max=100"`
+	Price   money.Amount      `json:"price" validate:"min=0"`
+	Stock   int               `json:"stock" validate:"min=0"`
+}
+
+func (p CreateVariantPayload) variant() store.ProductVariant {
+	return store.ProductVariant{
+		SKU:     p.SKU,
+		Options: p.Options,
+		Price:   p.Price,
+		Stock:   p.Stock,
+	}
+}
+
+// UpdateVariantPayload is a variant's editable fields, patched like
+// UpdateProductPayload. Stock changes through inventory adjustments instead.
+type UpdateVariantPayload struct {
+	SKU     *string            `json:"sku" validate:"required,min=1,max=64,printascii"`
+	Options *map[string]string `json:"options" validate:"required,max=10,dive,keys,min=1,max=50,endkeys,max=100"`
+	Price   *money.Amount      `json:"price" validate:"required,min=0"`
+}
+
+func getVariantID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "variantID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid variant ID %q", idStr)
+	}
+	return id, nil
+}
+
+// CreateProductVariant godoc
+//
+//	@Summary		Adds a variant to a product
+//	@Description	Adds a purchasable variant, such as another colour, to a product. A bare price is taken to be in the product's currency, and a price in any other currency is refused. Its stock is recorded in the inventory ledger as a receipt. Only the product's seller or an admin may add variants.
+//	@Tags			products
+//	@Accept			json
+//	@Produce		json
+//	@Param			productID	path		string					true	"Product ID"
+//	@Param			payload		body		CreateVariantPayload	true	"Variant"
+//	@Success		201			{object}	store.ProductVariant
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Wrong currency, or the SKU or options are already in use"
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/variants [post]
+func (app *application) createProductVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	product, err := app.getOwnedProduct(r, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Bare numeric prices keep the currency of the amount they decode into.
+	payload := CreateVariantPayload{Price: money.Zero(product.Price.Currency())}
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	variant := payload.variant()
+	variant.ProductID = product.ID
+
+	if err := app.store.ProductVariants.ProductVariantCreate(r.Context(), &variant, getUserFromContext(r).ID); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, variant); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateProductVariant godoc
+//
+//	@Summary		Updates a product variant
+//	@Description	Partially updates a variant with a JSON Merge Patch or JSON Patch, as for products. A bare price, or price.amount without a currency, is in the product's currency. Stock is changed by posting inventory adjustments. Only the product's seller or an admin may update variants.
+//	@Tags			products
+//	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json
+//	@Param			productID	path		string					true	"Product ID"
+//	@Param			variantID	path		string					true	"Variant ID"
+//	@Param			payload		body		UpdateVariantPayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	store.ProductVariant
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Wrong currency, or the SKU or options are already in use"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/variants/{variantID} [patch]
+func (app *application) updateProductVariantHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variantID, err := getVariantID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	variant, err := app.store.ProductVariants.ProductVariantGetByID(ctx, id, variantID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	current := UpdateVariantPayload{
+		SKU:     &variant.SKU,
+		Options: &variant.Options,
+		Price:   &variant.Price,
+	}
+
+	// Bare numeric prices keep the currency of the amount they decode into.
+	price := money.Zero(variant.Price.Currency())
+	payload := UpdateVariantPayload{Price: &price}
+	if err := app.readPatch(w, r, current, &payload); err != nil {
+		return
+	}
+
+	variant.SKU = *payload.SKU
+	variant.Options = *payload.Options
+	variant.Price = *payload.Price
+
+	if err := app.store.ProductVariants.ProductVariantUpdate(ctx, variant); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, variant); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteProductVariant godoc
+//
+//	@Summary		Deletes a product variant
+//	@Description	Removes a variant from a product and from every cart it is in. Past orders keep their copy of it. A product's last variant cannot be deleted; delete the product instead. Only the product's seller or an admin may delete variants.
+//	@Tags			products
+//	@Param			productID	path	string	true	"Product ID"
+//	@Param			variantID	path	string	true	"Variant ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error	"The variant is the product's last"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/products/{productID}/variants/{variantID} [delete]
+func (app *application) deleteProductVariantHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getProductID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variantID, err := getVariantID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedProduct(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotProductOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.ProductVariants.ProductVariantDelete(r.Context(), id, variantID); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/store"
+	"net/http"
+)
+
+type CreateWarehousePayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+	// Priority orders the seller's warehouses, lowest first.
+	Priority int `json:"priority" validate:"min=0,max=1000"`
+}
+
+// UpdateWarehousePayload is a warehouse's editable fields, patched like
+// UpdateProductPayload.
+type UpdateWarehousePayload struct {
+	Name     *string `json:"name" validate:"required,min=1,max=100"`
+	Priority *int    `json:"priority" validate:"required,min=0,max=1000"`
+}
+
+var errNotWarehouseOwner = errors.New("only the seller who owns a warehouse can change it")
+
+func getWarehouseID(r *http.Request) (uuid.UUID, error) {
+	idStr := chi.URLParam(r, "warehouseID")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid warehouse ID %q", idStr)
+	}
+	return id, nil
+}
+
+// GetWarehouses godoc
+//
+//	@Summary		Lists the user's warehouses
+//	@Description	Lists the warehouses the user keeps stock in, in priority order. A seller's first warehouse is created for them when they first receive stock.
+//	@Tags			warehouses
+//	@Produce		json
+//	@Success		200	{array}		store.Warehouse
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/warehouses [get]
+func (app *application) getWarehousesHandler(w http.ResponseWriter, r *http.Request) {
+	warehouses, err := app.store.Warehouses.WarehouseGetAllByUser(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, warehouses); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateWarehouse godoc
+//
+//	@Summary		Creates a warehouse
+//	@Description	Adds a location the user keeps stock in. Stock is moved into it with transfers or booked into it with inventory adjustments.
+//	@Tags			warehouses
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateWarehousePayload	true	"Warehouse"
+//	@Success		201		{object}	store.Warehouse
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error	"Name already in use"
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/warehouses [post]
+func (app *application) createWarehouseHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateWarehousePayload
+	if err := app.readAndValidateJSON(w, r, &payload); err != nil {
+		return
+	}
+
+	warehouse := &store.Warehouse{
+		UserID:   getUserFromContext(r).ID,
+		Name:     payload.Name,
+		Priority: payload.Priority,
+	}
+
+	if err := app.store.Warehouses.WarehouseCreate(r.Context(), warehouse); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusCreated, warehouse); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateWarehouse godoc
+//
+//	@Summary		Updates a warehouse
+//	@Description	Partially updates a warehouse with a JSON Merge Patch or JSON Patch, as for products. Only the warehouse's seller or an admin may update it.
+//	@Tags			warehouses
+//	@Accept			json
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Produce		json
+//	@Param			warehouseID	path		string					true	"Warehouse ID"
+//	@Param			payload		body		UpdateWarehousePayload	true	"Merge patch, or an array of JSON Patch operations"
+//	@Success		200			{object}	store.Warehouse
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error	"Name already in use"
+//	@Failure		415			{object}	error
+//	@Failure		422			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/warehouses/{warehouseID} [patch]
+func (app *application) updateWarehouseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getWarehouseID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	warehouse, err := app.getOwnedWarehouse(r, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotWarehouseOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	current := UpdateWarehousePayload{
+		Name:     &warehouse.Name,
+		Priority: &warehouse.Priority,
+	}
+
+	var payload UpdateWarehousePayload
+	if err := app.readPatch(w, r, current, &payload); err != nil {
+		return
+	}
+
+	warehouse.Name = *payload.Name
+	warehouse.Priority = *payload.Priority
+
+	if err := app.store.Warehouses.WarehouseUpdate(r.Context(), warehouse); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONResponse(w, http.StatusOK, warehouse); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteWarehouse godoc
+//
+//	@Summary		Deletes a warehouse
+//	@Description	Deletes an empty warehouse. Transfer its stock elsewhere first. Only the warehouse's seller or an admin may delete it.
+//	@Tags			warehouses
+//	@Param			warehouseID	path	string	true	"Warehouse ID"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error	"The warehouse still holds stock"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/warehouses/{warehouseID} [delete]
+func (app *application) deleteWarehouseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := getWarehouseID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getOwnedWarehouse(r, id); err != nil {
+		switch {
+		case errors.Is(err, errNotWarehouseOwner):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.errorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Warehouses.WarehouseDelete(r.Context(), id); err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnedWarehouse loads a warehouse, checking that the authenticated user
+// is its seller or may manage every product.
+func (app *application) getOwnedWarehouse(r *http.Request, warehouseID uuid.UUID) (*store.Warehouse, error) {
+	warehouse, err := app.store.Warehouses.WarehouseGetByID(r.Context(), warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := getUserFromContext(r)
+	if warehouse.UserID != user.ID && !user.Role.Can(store.PermProductsManage) {
+		return nil, errNotWarehouseOwner
+	}
+
+	return warehouse, nil
+}
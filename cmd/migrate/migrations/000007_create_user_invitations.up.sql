@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_invitations
+(
+    token   BYTEA PRIMARY KEY,
+    user_id UUID                        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expiry  TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
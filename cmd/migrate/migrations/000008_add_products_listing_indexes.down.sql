@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_products_price_id;
+DROP INDEX IF EXISTS idx_products_created_at_id;
+DROP INDEX IF EXISTS idx_products_rating_id;
+DROP INDEX IF EXISTS idx_products_user_id;
@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS idx_products_price_id ON products (price, id);
+CREATE INDEX IF NOT EXISTS idx_products_created_at_id ON products (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_products_rating_id ON products (rating, id);
+CREATE INDEX IF NOT EXISTS idx_products_user_id ON products (user_id);
@@ -0,0 +1,12 @@
+ALTER TABLE products
+    DROP COLUMN review_count,
+    ALTER COLUMN rating DROP DEFAULT,
+    ALTER COLUMN rating TYPE INT USING round(rating)::INT;
+
+ALTER TABLE reviews
+    DROP CONSTRAINT reviews_product_id_user_id_key,
+    DROP CONSTRAINT fk_reviews_user,
+    DROP CONSTRAINT fk_reviews_product,
+    DROP COLUMN updated_at,
+    DROP COLUMN stars,
+    ALTER COLUMN id DROP DEFAULT;
@@ -0,0 +1,24 @@
+ALTER TABLE reviews
+    ALTER COLUMN id SET DEFAULT gen_random_uuid(),
+    ADD COLUMN stars      SMALLINT NOT NULL DEFAULT 5 CHECK (stars BETWEEN 1 AND 5),
+    ADD COLUMN updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ADD CONSTRAINT fk_reviews_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_reviews_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    ADD CONSTRAINT reviews_product_id_user_id_key UNIQUE (product_id, user_id);
+
+-- The default only backfills reviews written before star ratings existed.
+ALTER TABLE reviews
+    ALTER COLUMN stars DROP DEFAULT;
+
+ALTER TABLE products
+    ALTER COLUMN rating TYPE NUMERIC(3, 2),
+    ALTER COLUMN rating SET DEFAULT 0,
+    ADD COLUMN review_count INT NOT NULL DEFAULT 0;
+
+UPDATE products p
+SET rating       = r.rating,
+    review_count = r.review_count
+FROM (SELECT product_id, AVG(stars) AS rating, COUNT(*) AS review_count
+      FROM reviews
+      GROUP BY product_id) r
+WHERE r.product_id = p.id;
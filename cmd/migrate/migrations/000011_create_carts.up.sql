@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS carts
+(
+    id         UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    user_id    UUID UNIQUE NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP          DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP          DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS cart_items
+(
+    cart_id    UUID           NOT NULL REFERENCES carts (id) ON DELETE CASCADE,
+    product_id UUID           NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    quantity   INT            NOT NULL CHECK (quantity > 0),
+    unit_price DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (cart_id, product_id)
+);
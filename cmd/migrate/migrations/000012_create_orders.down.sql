@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_items;
+DROP FUNCTION IF EXISTS reject_order_item_changes;
+DROP TABLE IF EXISTS orders;
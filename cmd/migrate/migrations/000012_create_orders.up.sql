@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS orders
+(
+    id         UUID PRIMARY KEY        DEFAULT gen_random_uuid(),
+    user_id    UUID           NOT NULL REFERENCES users (id),
+    status     TEXT           NOT NULL DEFAULT 'pending',
+    total      DECIMAL(12, 2) NOT NULL,
+    created_at TIMESTAMP               DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP               DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_user_id_created_at ON orders (user_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS order_items
+(
+    id         UUID PRIMARY KEY        DEFAULT gen_random_uuid(),
+    order_id   UUID           NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products (id) ON DELETE SET NULL,
+    title      TEXT           NOT NULL,
+    unit_price DECIMAL(10, 2) NOT NULL,
+    quantity   INT            NOT NULL CHECK (quantity > 0),
+    subtotal   DECIMAL(12, 2) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (order_id);
+
+-- Line items snapshot what the buyer paid, so they must never be repriced.
+CREATE OR REPLACE FUNCTION reject_order_item_changes() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'order items are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_items_immutable
+    BEFORE UPDATE OF order_id, title, unit_price, quantity, subtotal
+    ON order_items
+    FOR EACH ROW
+EXECUTE FUNCTION reject_order_item_changes();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_events;
+
+ALTER TABLE orders
+    DROP CONSTRAINT orders_status_check;
@@ -0,0 +1,20 @@
+ALTER TABLE orders
+    ADD CONSTRAINT orders_status_check
+        CHECK (status IN ('pending', 'paid', 'fulfilled', 'shipped', 'delivered', 'cancelled', 'refunded'));
+
+CREATE TABLE IF NOT EXISTS order_events
+(
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id    UUID NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status   TEXT NOT NULL,
+    actor_id    UUID REFERENCES users (id) ON DELETE SET NULL,
+    note        TEXT NOT NULL    DEFAULT '',
+    created_at  TIMESTAMP        DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_events_order_id_created_at ON order_events (order_id, created_at);
+
+INSERT INTO order_events (order_id, to_status, actor_id, created_at)
+SELECT id, status, user_id, created_at
+FROM orders;
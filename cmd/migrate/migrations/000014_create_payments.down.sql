@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_payments_returning;
+DROP INDEX IF EXISTS payments_order_id_active_key;
+DROP TABLE IF EXISTS payment_events;
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS payments
+(
+    id             UUID PRIMARY KEY        DEFAULT gen_random_uuid(),
+    user_id        UUID           NOT NULL REFERENCES users (id),
+    order_id       UUID           NOT NULL REFERENCES orders (id),
+    provider       TEXT           NOT NULL,
+    provider_ref   TEXT UNIQUE,
+    amount         DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    currency       CHAR(3)        NOT NULL DEFAULT 'GBP',
+    status         TEXT           NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'authorized', 'captured', 'declined', 'failed', 'voided', 'refunded',
+                          'void_pending', 'refund_pending')),
+    failure_reason TEXT           NOT NULL DEFAULT '',
+    created_at     TIMESTAMP               DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP               DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_user_id ON payments (user_id);
+
+-- An order has at most one payment in flight or taken, so concurrent attempts
+-- to pay for it cannot both charge the buyer. Payments still waiting to be
+-- voided or refunded hold the buyer's money, so they count too. Declined,
+-- failed, voided and refunded payments do not, so the buyer can try again.
+CREATE UNIQUE INDEX IF NOT EXISTS payments_order_id_active_key
+    ON payments (order_id)
+    WHERE status IN ('pending', 'authorized', 'captured', 'void_pending', 'refund_pending');
+
+-- Payments waiting to be voided or refunded, which are retried until the
+-- gateway gives the money back.
+CREATE INDEX IF NOT EXISTS idx_payments_returning
+    ON payments (updated_at)
+    WHERE status IN ('void_pending', 'refund_pending');
+
+-- Provider webhook events, keyed by the provider's event ID so that
+-- redelivered events are only applied once.
+CREATE TABLE IF NOT EXISTS payment_events
+(
+    id          TEXT PRIMARY KEY,
+    payment_id  UUID  NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    type        TEXT  NOT NULL,
+    payload     JSONB NOT NULL,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_events_payment_id ON payment_events (payment_id);
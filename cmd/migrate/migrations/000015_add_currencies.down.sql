@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE products
+    DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,17 @@
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'GBP';
+
+-- Orders are always in a single currency, that of the products bought.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'GBP';
+
+-- rate is the number of units of quote that one unit of base buys.
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    base       CHAR(3)         NOT NULL,
+    quote      CHAR(3)         NOT NULL,
+    rate       NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (base, quote),
+    CHECK (base <> quote)
+);
@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          BIGSERIAL PRIMARY KEY,
+    name        VARCHAR(255) UNIQUE NOT NULL,
+    level       INT                 NOT NULL DEFAULT 0,
+    description TEXT                NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id    BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission TEXT   NOT NULL,
+    PRIMARY KEY (role_id, permission)
+);
+
+INSERT INTO roles (name, level, description)
+VALUES ('buyer', 1, 'Can shop, pay for orders and review products'),
+       ('seller', 2, 'Can also list products and fulfil orders for them'),
+       ('admin', 3, 'Can manage every product, order and user');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.permission
+FROM roles r
+         JOIN (VALUES ('buyer', 'orders:write'),
+                      ('buyer', 'reviews:write'),
+                      ('seller', 'orders:write'),
+                      ('seller', 'reviews:write'),
+                      ('seller', 'products:write'),
+                      ('admin', 'orders:write'),
+                      ('admin', 'reviews:write'),
+                      ('admin', 'reviews:moderate'),
+                      ('admin', 'products:write'),
+                      ('admin', 'products:manage'),
+                      ('admin', 'orders:manage'),
+                      ('admin', 'users:manage')) AS p (role, permission) ON p.role = r.name;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role_id BIGINT REFERENCES roles (id);
+
+-- Everyone who already has listings keeps being able to manage them.
+UPDATE users
+SET role_id = (SELECT id FROM roles WHERE name = 'seller')
+WHERE id IN (SELECT user_id FROM products);
+
+UPDATE users
+SET role_id = (SELECT id FROM roles WHERE name = 'buyer')
+WHERE role_id IS NULL;
+
+ALTER TABLE users
+    ALTER COLUMN role_id SET NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE products
+    ALTER COLUMN version DROP NOT NULL,
+    ALTER COLUMN version SET DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Versions start at 1 so that every product has a usable ETag.
+UPDATE products
+SET version = 1
+WHERE version IS NULL
+   OR version < 1;
+
+ALTER TABLE products
+    ALTER COLUMN version SET DEFAULT 1,
+    ALTER COLUMN version SET NOT NULL;
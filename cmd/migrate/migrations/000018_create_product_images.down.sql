@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS product_images;
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS image_url TEXT;
@@ -0,0 +1,20 @@
+-- Images replace the single, never used image_url column. Blobs live in the
+-- blob store under key; only their metadata is kept here.
+ALTER TABLE products
+    DROP COLUMN IF EXISTS image_url;
+
+CREATE TABLE IF NOT EXISTS product_images
+(
+    id             UUID PRIMARY KEY,
+    product_id     UUID    NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    position       INT     NOT NULL CHECK (position >= 0),
+    content_type   TEXT    NOT NULL,
+    width          INT     NOT NULL CHECK (width > 0),
+    height         INT     NOT NULL CHECK (height > 0),
+    size           BIGINT  NOT NULL CHECK (size > 0),
+    key            TEXT    NOT NULL,
+    -- Thumbnail blob keys by size name, e.g. {"small": "products/.../small.jpg"}.
+    thumbnail_keys JSONB   NOT NULL DEFAULT '{}',
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (product_id, position)
+);
@@ -0,0 +1,5 @@
+DELETE FROM role_permissions
+WHERE permission = 'categories:manage';
+
+DROP TABLE IF EXISTS product_categories;
+DROP TABLE IF EXISTS categories;
@@ -0,0 +1,30 @@
+-- Categories form a tree through parent_id. Top-level categories have no
+-- parent.
+CREATE TABLE IF NOT EXISTS categories
+(
+    id          UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    parent_id   UUID REFERENCES categories (id) ON DELETE RESTRICT,
+    name        VARCHAR(100) NOT NULL,
+    slug        VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT         NOT NULL DEFAULT '',
+    created_at  TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    CHECK (parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS product_categories
+(
+    product_id  UUID NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    category_id UUID NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    PRIMARY KEY (product_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_categories_category_id ON product_categories (category_id);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'categories:manage'
+FROM roles
+WHERE name = 'admin'
+ON CONFLICT DO NOTHING;
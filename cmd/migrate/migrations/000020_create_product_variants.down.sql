@@ -0,0 +1,27 @@
+DROP TRIGGER IF EXISTS order_items_immutable ON order_items;
+
+ALTER TABLE order_items
+    DROP COLUMN IF EXISTS options,
+    DROP COLUMN IF EXISTS sku,
+    DROP COLUMN IF EXISTS variant_id;
+
+CREATE TRIGGER order_items_immutable
+    BEFORE UPDATE OF order_id, title, unit_price, quantity, subtotal
+    ON order_items
+    FOR EACH ROW
+EXECUTE FUNCTION reject_order_item_changes();
+
+-- Merge each cart's lines for the same product back into one.
+DELETE FROM cart_items a USING cart_items b
+WHERE a.cart_id = b.cart_id
+  AND a.product_id = b.product_id
+  AND a.variant_id > b.variant_id;
+
+ALTER TABLE cart_items
+    DROP CONSTRAINT IF EXISTS cart_items_pkey,
+    DROP COLUMN IF EXISTS variant_id,
+    ADD PRIMARY KEY (cart_id, product_id);
+
+DROP TRIGGER IF EXISTS product_variants_summary ON product_variants;
+DROP FUNCTION IF EXISTS sync_product_variant_summary();
+DROP TABLE IF EXISTS product_variants;
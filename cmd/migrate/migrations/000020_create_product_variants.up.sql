@@ -0,0 +1,84 @@
+-- Variants are the purchasable versions of a product, e.g. each colour of a
+-- pair of headphones. Every product has at least one. They share the
+-- product's currency.
+CREATE TABLE IF NOT EXISTS product_variants
+(
+    id         UUID PRIMARY KEY        DEFAULT gen_random_uuid(),
+    product_id UUID           NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    sku        VARCHAR(64)    NOT NULL UNIQUE,
+    -- Option values by option name, e.g. {"colour": "black", "size": "M"}.
+    options    JSONB          NOT NULL DEFAULT '{}',
+    price      DECIMAL(10, 2) NOT NULL CHECK (price >= 0),
+    stock      INT            NOT NULL CHECK (stock >= 0),
+    created_at TIMESTAMP               DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP               DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (product_id, options)
+);
+
+-- Every existing product becomes a single variant of itself.
+INSERT INTO product_variants (product_id, sku, price, stock)
+SELECT id, upper(left(replace(id::text, '-', ''), 8)) || '-1', price, stock
+FROM products;
+
+-- products.price and products.stock now summarise the variants, as the
+-- lowest price and the total stock, so that listings can keep filtering and
+-- sorting on them.
+CREATE OR REPLACE FUNCTION sync_product_variant_summary() RETURNS TRIGGER AS
+$$
+DECLARE
+    pid UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        pid := OLD.product_id;
+    ELSE
+        pid := NEW.product_id;
+    END IF;
+
+    UPDATE products
+    SET price = COALESCE((SELECT MIN(price) FROM product_variants WHERE product_id = pid), price),
+        stock = COALESCE((SELECT SUM(stock) FROM product_variants WHERE product_id = pid), 0)
+    WHERE id = pid;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_variants_summary
+    AFTER INSERT OR UPDATE OF price, stock OR DELETE
+    ON product_variants
+    FOR EACH ROW
+EXECUTE FUNCTION sync_product_variant_summary();
+
+-- Cart lines are for a variant rather than a product.
+ALTER TABLE cart_items
+    ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants (id) ON DELETE CASCADE;
+
+UPDATE cart_items ci
+SET variant_id = v.id
+FROM product_variants v
+WHERE v.product_id = ci.product_id;
+
+ALTER TABLE cart_items
+    ALTER COLUMN variant_id SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS cart_items_pkey,
+    ADD PRIMARY KEY (cart_id, variant_id);
+
+-- Order lines snapshot the variant bought alongside the product.
+ALTER TABLE order_items
+    ADD COLUMN IF NOT EXISTS variant_id UUID REFERENCES product_variants (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS sku        VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS options    JSONB       NOT NULL DEFAULT '{}';
+
+UPDATE order_items oi
+SET variant_id = v.id,
+    sku        = v.sku
+FROM product_variants v
+WHERE v.product_id = oi.product_id;
+
+DROP TRIGGER IF EXISTS order_items_immutable ON order_items;
+
+CREATE TRIGGER order_items_immutable
+    BEFORE UPDATE OF order_id, title, unit_price, quantity, subtotal, sku, options
+    ON order_items
+    FOR EACH ROW
+EXECUTE FUNCTION reject_order_item_changes();
@@ -0,0 +1,56 @@
+-- The inventory ledger. Every change to a variant's stock is recorded here
+-- with the balance it left, so stock levels can always be explained.
+-- quantity is signed: receipts and returns add stock, sales take it away and
+-- adjustments and reservations can go either way.
+-- Deleting a product or variant only clears the movement's reference to it;
+-- sku keeps a snapshot of the variant's SKU so the movement still says what
+-- it moved.
+CREATE TABLE IF NOT EXISTS inventory_movements
+(
+    id         UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    product_id UUID REFERENCES products (id) ON DELETE SET NULL,
+    variant_id UUID REFERENCES product_variants (id) ON DELETE SET NULL,
+    sku        VARCHAR(64) NOT NULL,
+    kind       TEXT      NOT NULL CHECK (kind IN ('receipt', 'sale', 'return', 'adjustment', 'reservation')),
+    quantity   INT       NOT NULL CHECK (quantity <> 0),
+    balance    INT       NOT NULL CHECK (balance >= 0),
+    reason     TEXT      NOT NULL DEFAULT '',
+    order_id   UUID REFERENCES orders (id) ON DELETE SET NULL,
+    actor_id   UUID REFERENCES users (id) ON DELETE SET NULL,
+    -- clock_timestamp rather than CURRENT_TIMESTAMP so that movements made in
+    -- one transaction keep their order.
+    created_at TIMESTAMP NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_product_id_created_at
+    ON inventory_movements (product_id, created_at DESC, id DESC);
+
+-- Movements are never edited or removed. The only change allowed is clearing
+-- the product or variant a movement points at, which is how the foreign keys
+-- let them be deleted.
+CREATE OR REPLACE FUNCTION reject_inventory_movement_changes() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND (NEW.product_id IS NULL OR NEW.product_id = OLD.product_id)
+        AND (NEW.variant_id IS NULL OR NEW.variant_id = OLD.variant_id)
+        AND (NEW.sku, NEW.kind, NEW.quantity, NEW.balance, NEW.reason, NEW.created_at)
+            IS NOT DISTINCT FROM (OLD.sku, OLD.kind, OLD.quantity, OLD.balance, OLD.reason, OLD.created_at) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'inventory movements are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER inventory_movements_append_only
+    BEFORE UPDATE OF product_id, variant_id, sku, kind, quantity, balance, reason, created_at OR DELETE
+    ON inventory_movements
+    FOR EACH ROW
+EXECUTE FUNCTION reject_inventory_movement_changes();
+
+-- Open the ledger with the stock each variant already has.
+INSERT INTO inventory_movements (product_id, variant_id, sku, kind, quantity, balance, reason)
+SELECT product_id, id, sku, 'adjustment', stock, stock, 'Opening balance'
+FROM product_variants
+WHERE stock > 0;
@@ -0,0 +1,4 @@
+ALTER TABLE inventory_movements
+    DROP COLUMN IF EXISTS reservation_id;
+
+DROP TABLE IF EXISTS stock_reservations;
@@ -0,0 +1,31 @@
+-- Time-limited holds on a variant's stock. Active holds that have not expired
+-- count against the stock other buyers can see and buy. A hold ends when it
+-- is committed, which takes the stock for good, released by the buyer or
+-- expired by the sweeper.
+CREATE TABLE IF NOT EXISTS stock_reservations
+(
+    id         UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    product_id UUID      NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    variant_id UUID      NOT NULL REFERENCES product_variants (id) ON DELETE CASCADE,
+    user_id    UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    quantity   INT       NOT NULL CHECK (quantity > 0),
+    status     TEXT      NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'committed', 'released', 'expired')),
+    expires_at TIMESTAMP NOT NULL,
+    -- Set when the hold was committed by checking out.
+    order_id   UUID REFERENCES orders (id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_active_variant_id
+    ON stock_reservations (variant_id) WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_active_product_id
+    ON stock_reservations (product_id) WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_active_expires_at
+    ON stock_reservations (expires_at) WHERE status = 'active';
+
+-- Ledger entries made by committing a hold point back at it.
+ALTER TABLE inventory_movements
+    ADD COLUMN IF NOT EXISTS reservation_id UUID REFERENCES stock_reservations (id) ON DELETE SET NULL;
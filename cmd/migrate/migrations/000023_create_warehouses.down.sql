@@ -0,0 +1,11 @@
+-- NOT VALID keeps any transfers already in the append-only ledger.
+ALTER TABLE inventory_movements
+    DROP CONSTRAINT IF EXISTS inventory_movements_kind_check,
+    ADD CONSTRAINT inventory_movements_kind_check
+        CHECK (kind IN ('receipt', 'sale', 'return', 'adjustment', 'reservation')) NOT VALID;
+
+ALTER TABLE inventory_movements
+    DROP COLUMN IF EXISTS warehouse_id;
+
+DROP TABLE IF EXISTS warehouse_stock;
+DROP TABLE IF EXISTS warehouses;
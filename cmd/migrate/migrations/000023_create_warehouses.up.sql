@@ -0,0 +1,52 @@
+-- Sellers keep stock in one or more warehouses. priority orders a seller's
+-- warehouses, lowest first, for allocation; the first is also where stock
+-- goes when no warehouse is named.
+CREATE TABLE IF NOT EXISTS warehouses
+(
+    id         UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    user_id    UUID         NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       VARCHAR(100) NOT NULL,
+    priority   INT          NOT NULL DEFAULT 0,
+    created_at TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT warehouses_user_id_name_key UNIQUE (user_id, name)
+);
+
+-- A variant's stock is split across warehouses. The quantities of a variant
+-- always add up to product_variants.stock, since both only change through
+-- the inventory ledger.
+CREATE TABLE IF NOT EXISTS warehouse_stock
+(
+    warehouse_id UUID      NOT NULL REFERENCES warehouses (id) ON DELETE CASCADE,
+    variant_id   UUID      NOT NULL REFERENCES product_variants (id) ON DELETE CASCADE,
+    product_id   UUID      NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    quantity     INT       NOT NULL CHECK (quantity >= 0),
+    updated_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (warehouse_id, variant_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_stock_variant_id ON warehouse_stock (variant_id);
+CREATE INDEX IF NOT EXISTS idx_warehouse_stock_product_id ON warehouse_stock (product_id);
+
+-- Movements say which warehouse they changed. Transfers move stock between
+-- two warehouses as a pair of movements that leave the variant's total alone.
+ALTER TABLE inventory_movements
+    ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses (id) ON DELETE SET NULL;
+
+ALTER TABLE inventory_movements
+    DROP CONSTRAINT IF EXISTS inventory_movements_kind_check,
+    ADD CONSTRAINT inventory_movements_kind_check
+        CHECK (kind IN ('receipt', 'sale', 'return', 'adjustment', 'reservation', 'transfer'));
+
+-- Every seller starts with a single warehouse holding all their stock.
+INSERT INTO warehouses (user_id, name)
+SELECT DISTINCT user_id, 'Main'
+FROM products
+ON CONFLICT DO NOTHING;
+
+INSERT INTO warehouse_stock (warehouse_id, variant_id, product_id, quantity)
+SELECT w.id, v.id, v.product_id, v.stock
+FROM product_variants v
+         JOIN products p ON p.id = v.product_id
+         JOIN warehouses w ON w.user_id = p.user_id AND w.name = 'Main'
+WHERE v.stock > 0;
@@ -0,0 +1,7 @@
+DELETE FROM role_permissions
+WHERE permission = 'promotions:manage';
+
+DROP TABLE IF EXISTS promotion_redemptions;
+DROP TABLE IF EXISTS promotion_categories;
+DROP TABLE IF EXISTS promotion_products;
+DROP TABLE IF EXISTS promotions;
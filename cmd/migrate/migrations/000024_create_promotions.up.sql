@@ -0,0 +1,71 @@
+-- Promotions are coupons redeemed by code. Percentage promotions take percent
+-- off the items they apply to and fixed promotions take amount off them,
+-- shared out by value. Both amounts and min_basket are in currency, and only
+-- apply to baskets priced in it.
+CREATE TABLE IF NOT EXISTS promotions
+(
+    id                UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    code              VARCHAR(50) NOT NULL,
+    description       TEXT        NOT NULL DEFAULT '',
+    kind              TEXT        NOT NULL CHECK (kind IN ('percentage', 'fixed')),
+    percent           NUMERIC(5, 2) CHECK (percent > 0 AND percent <= 100),
+    amount            DECIMAL(10, 2) CHECK (amount > 0),
+    min_basket        DECIMAL(12, 2) CHECK (min_basket > 0),
+    currency          CHAR(3),
+    -- NULL limits are unlimited.
+    max_uses          INT CHECK (max_uses > 0),
+    max_uses_per_user INT CHECK (max_uses_per_user > 0),
+    starts_at         TIMESTAMP,
+    ends_at           TIMESTAMP,
+    -- Stackable promotions combine with each other, in priority order,
+    -- lowest first. Others are only ever applied alone.
+    stackable         BOOLEAN     NOT NULL DEFAULT FALSE,
+    priority          INT         NOT NULL DEFAULT 0,
+    active            BOOLEAN     NOT NULL DEFAULT TRUE,
+    created_at        TIMESTAMP            DEFAULT CURRENT_TIMESTAMP,
+    updated_at        TIMESTAMP            DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT promotions_code_key UNIQUE (code),
+    CHECK ((kind = 'percentage' AND percent IS NOT NULL AND amount IS NULL) OR
+           (kind = 'fixed' AND amount IS NOT NULL AND percent IS NULL)),
+    CHECK ((amount IS NULL AND min_basket IS NULL) OR currency IS NOT NULL),
+    CHECK (ends_at > starts_at)
+);
+
+-- A promotion with no products or categories applies to every item. With
+-- some, it only applies to those products and to products in those
+-- categories or their subcategories.
+CREATE TABLE IF NOT EXISTS promotion_products
+(
+    promotion_id UUID NOT NULL REFERENCES promotions (id) ON DELETE CASCADE,
+    product_id   UUID NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    PRIMARY KEY (promotion_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS promotion_categories
+(
+    promotion_id UUID NOT NULL REFERENCES promotions (id) ON DELETE CASCADE,
+    category_id  UUID NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    PRIMARY KEY (promotion_id, category_id)
+);
+
+-- Each use of a promotion, which usage limits count.
+CREATE TABLE IF NOT EXISTS promotion_redemptions
+(
+    id           UUID PRIMARY KEY        DEFAULT gen_random_uuid(),
+    promotion_id UUID           NOT NULL REFERENCES promotions (id) ON DELETE CASCADE,
+    user_id      UUID           NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    order_id     UUID REFERENCES orders (id) ON DELETE SET NULL,
+    discount     DECIMAL(12, 2) NOT NULL CHECK (discount >= 0),
+    currency     CHAR(3)        NOT NULL,
+    created_at   TIMESTAMP               DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT promotion_redemptions_promotion_id_order_id_key UNIQUE (promotion_id, order_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_id_user_id
+    ON promotion_redemptions (promotion_id, user_id);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'promotions:manage'
+FROM roles
+WHERE name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+DELETE FROM role_permissions
+WHERE permission = 'tax:manage';
+
+DROP TABLE IF EXISTS tax_rates;
+
+ALTER TABLE products
+    DROP COLUMN IF EXISTS price_includes_tax,
+    DROP COLUMN IF EXISTS tax_class;
@@ -0,0 +1,33 @@
+-- A product's tax class picks the rate charged on it, and
+-- price_includes_tax says whether its prices are gross or net of that tax.
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS tax_class          VARCHAR(32) NOT NULL DEFAULT 'standard',
+    ADD COLUMN IF NOT EXISTS price_includes_tax BOOLEAN     NOT NULL DEFAULT FALSE;
+
+-- Tax rates are charged on goods of a tax class delivered to a country, or to
+-- one of its regions when region, an ISO 3166-2 code, is set. Regional rates
+-- take precedence over their country's.
+CREATE TABLE IF NOT EXISTS tax_rates
+(
+    id         UUID PRIMARY KEY       DEFAULT gen_random_uuid(),
+    country    CHAR(2)       NOT NULL,
+    region     VARCHAR(10)   NOT NULL DEFAULT '',
+    tax_class  VARCHAR(32)   NOT NULL,
+    percent    NUMERIC(7, 4) NOT NULL CHECK (percent >= 0 AND percent <= 100),
+    name       VARCHAR(50)   NOT NULL DEFAULT '',
+    created_at TIMESTAMP              DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP              DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT tax_rates_country_region_tax_class_key UNIQUE (country, region, tax_class)
+);
+
+INSERT INTO tax_rates (country, tax_class, percent, name)
+VALUES ('GB', 'standard', 20, 'VAT'),
+       ('GB', 'reduced', 5, 'VAT'),
+       ('GB', 'zero', 0, 'VAT')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT id, 'tax:manage'
+FROM roles
+WHERE name = 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,35 @@
+// Package blobstore keeps binary objects, such as product images, under
+// slash-separated keys in a pluggable backend.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store interface {
+	// Put stores data under key, replacing any blob already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the blob stored under key and returns its content type. The
+	// caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can download the blob from.
+	URL(key string) string
+}
+
+// checkKey rejects keys that could escape the store, such as "../x".
+func checkKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return nil
+}
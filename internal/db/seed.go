@@ -3,9 +3,12 @@ package db
 import (
 	"context"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
 	"github.com/seanhalberthal/webmart/internal/store"
 	"log"
 	"math/rand"
+	"slices"
 )
 
 var usernames = []string{
@@ -34,6 +37,30 @@ var descriptions = []string{
 	"A stunning 4K resolution monitor with HDR support.",
 }
 
+// titleCategories is the slug of the category each of titles is listed in.
+var titleCategories = []string{"laptops", "audio", "wearables", "keyboards", "monitors"}
+
+// titleOptions lists the variants seeded for titles that come in several,
+// keyed by title. Other titles get a single variant.
+var titleOptions = map[string][]map[string]string{
+	"Wireless Headphones": {
+		{"colour": "black"},
+		{"colour": "white"},
+		{"colour": "blue"},
+	},
+}
+
+// categories are seeded in order, so parents come before their children.
+var categories = []struct{ name, slug, parent string }{
+	{"Electronics", "electronics", ""},
+	{"Computers", "computers", "electronics"},
+	{"Laptops", "laptops", "computers"},
+	{"Keyboards", "keyboards", "computers"},
+	{"Monitors", "monitors", "computers"},
+	{"Audio", "audio", "electronics"},
+	{"Wearables", "wearables", "electronics"},
+}
+
 var reviews = []string{
 	"Amazing laptop, very fast!", "Battery life could be better.",
 	"Great sound quality, but a bit pricey.", "Very comfortable to wear!",
@@ -42,78 +69,159 @@ var reviews = []string{
 	"Crystal-clear display, perfect for work and gaming!", "Wish it had more ports.",
 }
 
-func Seed(store store.Storage) {
+func Seed(s store.Storage) {
 	ctx := context.Background()
 
-	users := generateUsers(100)
-	for _, user := range users {
-		if err := store.Users.UserCreate(ctx, user); err != nil {
-			log.Println("Failed to create user:", err)
-			return
+	// Seed everything in one transaction so a failure part way through does
+	// not leave a half-populated database behind.
+	err := s.WithTx(ctx, func(tx store.Storage) error {
+		users := generateUsers(100)
+		for _, user := range users {
+			if err := tx.Users.UserCreate(ctx, user); err != nil {
+				return fmt.Errorf("failed to create user: %w", err)
+			}
 		}
-	}
 
-	products := generateProducts(200, users)
-	for _, product := range products {
-		if err := store.Products.ProductCreate(ctx, product); err != nil {
-			log.Println("Failed to create product:", err)
-			return
+		categoryIDs := make(map[string]uuid.UUID, len(categories))
+		for _, c := range categories {
+			category := &store.Category{Name: c.name, Slug: c.slug}
+			if c.parent != "" {
+				parentID := categoryIDs[c.parent]
+				category.ParentID = &parentID
+			}
+
+			if err := tx.Categories.CategoryCreate(ctx, category); err != nil {
+				return fmt.Errorf("failed to create category: %w", err)
+			}
+			categoryIDs[c.slug] = category.ID
 		}
-	}
 
-	r := generateReviews(500, users, products)
-	for _, review := range r {
-		if err := store.Reviews.ReviewCreate(ctx, review); err != nil {
-			log.Println("Failed to create review:", err)
-			return
+		products := generateProducts(200, users)
+		for _, product := range products {
+			if err := tx.Products.ProductCreate(ctx, product); err != nil {
+				return fmt.Errorf("failed to create product: %w", err)
+			}
+
+			category := categoryIDs[titleCategories[slices.Index(titles, product.Title)]]
+			if err := tx.Categories.CategorySetForProduct(ctx, product.ID, []uuid.UUID{category}); err != nil {
+				return fmt.Errorf("failed to categorise product: %w", err)
+			}
 		}
+
+		r := generateReviews(500, users, products)
+		for _, review := range r {
+			if err := tx.Reviews.ReviewCreate(ctx, review); err != nil {
+				return fmt.Errorf("failed to create review: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Println("Seeding failed:", err)
+		return
 	}
 
 	log.Println("Seeding complete")
-
 }
 
 func generateUsers(num int) []*store.User {
 	users := make([]*store.User, num)
 
 	for i := 0; i < num; i++ {
+		password := "password"
+
 		users[i] = &store.User{
+			Name:     usernames[i%len(usernames)],
 			Username: usernames[i%len(usernames)] + fmt.Sprintf("%d", i),
 			Email:    usernames[i%len(usernames)] + fmt.Sprintf("%d", i) + "@mail.com",
+			Password: store.Password{Text: &password},
+			IsActive: true,
+			Role:     store.Role{Name: seedRole(i)},
 		}
 	}
 
 	return users
 }
 
+// seedRole makes the first user an admin and every fourth user a seller.
+func seedRole(i int) string {
+	switch {
+	case i == 0:
+		return store.RoleAdmin
+	case i%4 == 1:
+		return store.RoleSeller
+	default:
+		return store.RoleBuyer
+	}
+}
+
 func generateProducts(num int, users []*store.User) []*store.Product {
 	products := make([]*store.Product, num)
 
+	var sellers []*store.User
+	for _, user := range users {
+		if user.Role.Name == store.RoleSeller {
+			sellers = append(sellers, user)
+		}
+	}
+
 	for i := 0; i < num; i++ {
-		user := users[rand.Intn(len(users))]
+		user := sellers[rand.Intn(len(sellers))]
+
+		title := titles[rand.Intn(len(titles))]
 
 		products[i] = &store.Product{
 			UserID:      user.ID,
-			Title:       titles[rand.Intn(len(titles))],
+			Title:       title,
 			Description: descriptions[rand.Intn(len(descriptions))],
-			Rating:      0,
-			Price:       0,
-			Stock:       0,
+			Variants:    generateVariants(title),
 		}
 	}
 
 	return products
 }
 
+func generateVariants(title string) []store.ProductVariant {
+	options, ok := titleOptions[title]
+	if !ok {
+		options = []map[string]string{{}}
+	}
+
+	price := money.New(int64(99+rand.Intn(50_000)), money.DefaultCurrency)
+
+	variants := make([]store.ProductVariant, len(options))
+	for i, o := range options {
+		variants[i] = store.ProductVariant{
+			Options: o,
+			Price:   price,
+			Stock:   rand.Intn(100),
+		}
+	}
+
+	return variants
+}
+
 func generateReviews(num int, users []*store.User, products []*store.Product) []*store.Review {
-	r := make([]*store.Review, num)
+	r := make([]*store.Review, 0, num)
 
-	for i := 0; i < num; i++ {
-		r[i] = &store.Review{
-			ProductID: products[i].ID,
-			UserID:    users[i].ID,
-			Content:   reviews[i],
+	// Users may only review a product once, so skip repeated pairs.
+	type pair struct{ user, product int }
+	seen := make(map[pair]bool, num)
+
+	for len(r) < num && len(seen) < len(users)*len(products) {
+		p := pair{rand.Intn(len(users)), rand.Intn(len(products))}
+		if seen[p] {
+			continue
 		}
+		seen[p] = true
+
+		r = append(r, &store.Review{
+			ProductID: products[p.product].ID,
+			UserID:    users[p.user].ID,
+			Content:   reviews[rand.Intn(len(reviews))],
+			Stars:     rand.Intn(5) + 1,
+		})
 	}
 
 	return r
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes each outgoing email to its own .eml file in dir so that
+// messages can be inspected without a mail server.
+type FileMailer struct {
+	fromEmail string
+	dir       string
+}
+
+func NewFileMailer(fromEmail, dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{fromEmail, dir}, nil
+}
+
+func (m *FileMailer) Send(templateFile, username, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s <%s>\r\n", FromName, m.fromEmail)
+	fmt.Fprintf(&b, "To: %s <%s>\r\n", username, email)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(body)
+
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.ReplaceAll(email, "@", "_at_"))
+
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o644)
+}
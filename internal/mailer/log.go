@@ -0,0 +1,30 @@
+package mailer
+
+import "go.uber.org/zap"
+
+// LogMailer writes outgoing emails to the application log instead of
+// delivering them. It is intended for local development.
+type LogMailer struct {
+	fromEmail string
+	logger    *zap.SugaredLogger
+}
+
+func NewLogMailer(fromEmail string, logger *zap.SugaredLogger) *LogMailer {
+	return &LogMailer{fromEmail, logger}
+}
+
+func (m *LogMailer) Send(templateFile, username, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.logger.Infow("email sent",
+		"from", FromName+" <"+m.fromEmail+">",
+		"to", username+" <"+email+">",
+		"subject", subject,
+		"body", body,
+	)
+
+	return nil
+}
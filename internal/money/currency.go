@@ -0,0 +1,70 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Currency is an ISO 4217 alphabetic currency code.
+type Currency string
+
+const (
+	AUD Currency = "AUD"
+	CAD Currency = "CAD"
+	CHF Currency = "CHF"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	JPY Currency = "JPY"
+	NZD Currency = "NZD"
+	SEK Currency = "SEK"
+	USD Currency = "USD"
+)
+
+// DefaultCurrency is assumed for amounts that do not name a currency.
+const DefaultCurrency = GBP
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// maxExponent is the most minor-unit digits a supported currency may have.
+// Money columns are stored with two decimal places, so currencies with more,
+// such as KWD, would be silently rounded by the database.
+const maxExponent = 2
+
+// exponents holds the number of minor-unit digits of each supported currency.
+var exponents = map[Currency]int{
+	AUD: 2,
+	CAD: 2,
+	CHF: 2,
+	EUR: 2,
+	GBP: 2,
+	JPY: 0,
+	NZD: 2,
+	SEK: 2,
+	USD: 2,
+}
+
+// ParseCurrency returns the currency for a case-insensitive ISO 4217 code.
+func ParseCurrency(code string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(code)))
+	if !c.Valid() {
+		return "", fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+
+	return c, nil
+}
+
+func (c Currency) Valid() bool {
+	exp, ok := exponents[c]
+	return ok && exp <= maxExponent
+}
+
+// Exponent is the number of digits after the decimal point in the currency's
+// minor unit, e.g. 2 for pence and 0 for yen.
+func (c Currency) Exponent() int {
+	return exponents[c]
+}
+
+func (c Currency) String() string {
+	return string(c)
+}
@@ -0,0 +1,57 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ParseRate reads a positive decimal exchange rate such as "1.1642".
+func ParseRate(s string) (*big.Rat, error) {
+	r, ok := parseDecimal(s)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", s)
+	}
+
+	return r, nil
+}
+
+// FormatRate writes a rate as a decimal with up to 10 decimal places.
+func FormatRate(r *big.Rat) string {
+	s := r.FloatString(10)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	return s
+}
+
+// Conversion is an amount converted from another currency, along with the
+// rate and rounding mode that produced it.
+type Conversion struct {
+	Amount   Amount
+	Rate     *big.Rat
+	Rounding RoundingMode
+}
+
+// ConvertTo is like Convert but keeps the rate and rounding mode used.
+func (a Amount) ConvertTo(to Currency, rate *big.Rat, mode RoundingMode) Conversion {
+	return Conversion{Amount: a.Convert(to, rate, mode), Rate: rate, Rounding: mode}
+}
+
+// MarshalJSON encodes the conversion as
+// {"amount": "14.99", "currency": "EUR", "rate": "1.1642", "rounding": "half_even"}.
+func (c Conversion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string   `json:"amount"`
+		Currency Currency `json:"currency"`
+		Rate     string   `json:"rate"`
+		Rounding string   `json:"rounding"`
+	}{
+		Amount:   c.Amount.String(),
+		Currency: c.Amount.Currency(),
+		Rate:     FormatRate(c.Rate),
+		Rounding: c.Rounding.String(),
+	})
+}
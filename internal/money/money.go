@@ -0,0 +1,373 @@
+// Package money represents monetary amounts exactly, as a whole number of a
+// currency's minor units, so that prices and totals never pick up binary
+// floating-point rounding errors.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	// ErrPrecision is returned when an amount has more decimal places than
+	// its currency's minor unit.
+	ErrPrecision = errors.New("amount is more precise than the currency's minor unit")
+)
+
+// Amount is a sum of money in minor units, e.g. pence, of a currency. The
+// zero Amount is zero in no currency and may be added to an amount of any
+// currency.
+type Amount struct {
+	minor    int64
+	currency Currency
+}
+
+// New returns minor units of currency c.
+func New(minor int64, c Currency) Amount {
+	return Amount{minor: minor, currency: c}
+}
+
+// Zero returns nothing of currency c.
+func Zero(c Currency) Amount {
+	return Amount{currency: c}
+}
+
+// Parse reads a decimal amount such as "12.99" or "-0.5" in currency c.
+// Digits beyond the currency's minor unit must be zeros.
+func Parse(s string, c Currency) (Amount, error) {
+	r, ok := parseDecimal(s)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+
+	r.Mul(r, scale(c))
+	if !r.IsInt() {
+		return Amount{}, fmt.Errorf("%w: %q in %s", ErrPrecision, s, c)
+	}
+
+	if !r.Num().IsInt64() {
+		return Amount{}, fmt.Errorf("%w %q: out of range", ErrInvalidAmount, s)
+	}
+
+	return Amount{minor: r.Num().Int64(), currency: c}, nil
+}
+
+// MustParse is like Parse but panics if s is not a valid amount. It is meant
+// for constants and seed data.
+func MustParse(s string, c Currency) Amount {
+	a, err := Parse(s, c)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+// parseDecimal parses a plain decimal number exactly. Exponents, fractions
+// and special values accepted by big.Rat are rejected.
+func parseDecimal(s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return nil, false
+	}
+
+	for _, part := range []string{whole, frac} {
+		for _, ch := range part {
+			if ch < '0' || ch > '9' {
+				return nil, false
+			}
+		}
+	}
+
+	return new(big.Rat).SetString(s)
+}
+
+func scale(c Currency) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(c.Exponent())), nil))
+}
+
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+func (a Amount) Currency() Currency {
+	return a.currency
+}
+
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+func (a Amount) IsNegative() bool {
+	return a.minor < 0
+}
+
+// Rat returns the amount in major units, e.g. pounds, as an exact fraction.
+func (a Amount) Rat() *big.Rat {
+	r := new(big.Rat).SetInt64(a.minor)
+	return r.Quo(r, scale(a.currency))
+}
+
+// String formats the amount in major units without a currency, e.g. "12.99".
+func (a Amount) String() string {
+	exp := a.currency.Exponent()
+	if exp == 0 {
+		return strconv.FormatInt(a.minor, 10)
+	}
+
+	sign := ""
+	minor := a.minor
+	if minor < 0 {
+		sign = "-"
+	}
+
+	// Go through uint64 so that the smallest int64 can be negated.
+	abs := uint64(minor)
+	if minor < 0 {
+		abs = -abs
+	}
+
+	digits := fmt.Sprintf("%0*d", exp+1, abs)
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// Format returns the amount followed by its currency code, e.g. "12.99 GBP".
+func (a Amount) Format() string {
+	return a.String() + " " + a.currency.String()
+}
+
+// compatible returns the currency shared by a and b. A zero Amount without
+// a currency takes on the currency of the other operand.
+func (a Amount) compatible(b Amount) (Currency, error) {
+	switch {
+	case a.currency == b.currency:
+		return a.currency, nil
+	case a.currency == "" && a.minor == 0:
+		return b.currency, nil
+	case b.currency == "" && b.minor == 0:
+		return a.currency, nil
+	default:
+		return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.currency, b.currency)
+	}
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	c, err := a.compatible(b)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	sum := a.minor + b.minor
+	if (sum > a.minor) != (b.minor > 0) {
+		return Amount{}, fmt.Errorf("%w: overflow", ErrInvalidAmount)
+	}
+
+	return Amount{minor: sum, currency: c}, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	c, err := a.compatible(b)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	// Subtracting directly rather than adding b.Neg() also catches the
+	// overflow of negating the smallest int64.
+	diff := a.minor - b.minor
+	if (diff < a.minor) != (b.minor > 0) {
+		return Amount{}, fmt.Errorf("%w: overflow", ErrInvalidAmount)
+	}
+
+	return Amount{minor: diff, currency: c}, nil
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{minor: -a.minor, currency: a.currency}
+}
+
+// Cmp compares a and b, returning -1, 0 or +1.
+func (a Amount) Cmp(b Amount) (int, error) {
+	if _, err := a.compatible(b); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case a.minor < b.minor:
+		return -1, nil
+	case a.minor > b.minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Mul multiplies the amount by a whole quantity, e.g. a unit price by the
+// number of units bought.
+func (a Amount) Mul(quantity int64) (Amount, error) {
+	if quantity != 0 && (a.minor > math.MaxInt64/abs(quantity) || a.minor < -math.MaxInt64/abs(quantity)) {
+		return Amount{}, fmt.Errorf("%w: overflow", ErrInvalidAmount)
+	}
+
+	return Amount{minor: a.minor * quantity, currency: a.currency}, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// MulRat multiplies the amount by an exact factor, such as a tax rate or a
+// discount, rounding the result to whole minor units with mode.
+func (a Amount) MulRat(factor *big.Rat, mode RoundingMode) Amount {
+	r := new(big.Rat).SetInt64(a.minor)
+	return Amount{minor: round(r.Mul(r, factor), mode), currency: a.currency}
+}
+
+// Convert returns the amount in currency to, given how many units of to one
+// unit of the amount's currency buys, rounding to whole minor units of to
+// with mode.
+func (a Amount) Convert(to Currency, rate *big.Rat, mode RoundingMode) Amount {
+	r := a.Rat()
+	r.Mul(r, rate)
+	r.Mul(r, scale(to))
+	return Amount{minor: round(r, mode), currency: to}
+}
+
+// Allocate splits the amount between n parts as evenly as possible. The
+// leftover minor units go to the first parts so the parts always add up to
+// the whole.
+func (a Amount) Allocate(n int) []Amount {
+	if n <= 0 {
+		return nil
+	}
+
+	parts := make([]Amount, n)
+	share, rest := a.minor/int64(n), a.minor%int64(n)
+	for i := range parts {
+		parts[i] = Amount{minor: share, currency: a.currency}
+		if int64(i) < abs(rest) {
+			if rest > 0 {
+				parts[i].minor++
+			} else {
+				parts[i].minor--
+			}
+		}
+	}
+
+	return parts
+}
+
+type jsonAmount struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON encodes the amount as {"amount": "12.99", "currency": "GBP"}.
+// The amount is a string so that clients never parse it as a float.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	c := a.currency
+	if c == "" {
+		c = DefaultCurrency
+	}
+
+	return json.Marshal(struct {
+		Amount   string   `json:"amount"`
+		Currency Currency `json:"currency"`
+	}{Amount: Amount{minor: a.minor, currency: c}.String(), Currency: c})
+}
+
+// UnmarshalJSON accepts the object written by MarshalJSON, with the amount
+// as a string or a number, or a bare amount in the currency the Amount
+// already has, DefaultCurrency if none.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	c := a.currency
+	if c == "" {
+		c = DefaultCurrency
+	}
+
+	raw := json.RawMessage(data)
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var obj jsonAmount
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+
+		if obj.Currency != "" {
+			var err error
+			if c, err = ParseCurrency(obj.Currency); err != nil {
+				return err
+			}
+		}
+		raw = obj.Amount
+	}
+
+	s := strings.TrimSpace(string(raw))
+	if s == "" || s == "null" {
+		return fmt.Errorf("%w: amount is required", ErrInvalidAmount)
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := Parse(s, c)
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+	return nil
+}
+
+// Scan reads a NUMERIC column. The amount keeps the currency it already has,
+// DefaultCurrency if none; currencies are stored in a column of their own.
+func (a *Amount) Scan(src any) error {
+	c := a.currency
+	if c == "" {
+		c = DefaultCurrency
+	}
+
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return fmt.Errorf("%w: cannot scan NULL into money.Amount", ErrInvalidAmount)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into money.Amount", ErrInvalidAmount, src)
+	}
+
+	parsed, err := Parse(s, c)
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+	return nil
+}
+
+// Value writes the amount in major units for a NUMERIC column.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
@@ -0,0 +1,321 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s        string
+		currency Currency
+		minor    int64
+		err      error
+	}{
+		{"12.99", GBP, 1299, nil},
+		{"-0.5", GBP, -50, nil},
+		{"+1", GBP, 100, nil},
+		{".25", EUR, 25, nil},
+		{"1.500", USD, 150, nil},
+		{" 3 ", GBP, 300, nil},
+		{"1500", JPY, 1500, nil},
+		{"1.005", GBP, 0, ErrPrecision},
+		{"1.5", JPY, 0, ErrPrecision},
+		{"92233720368547758.07", GBP, math.MaxInt64, nil},
+		{"92233720368547758.08", GBP, 0, ErrInvalidAmount},
+		{"-92233720368547758.09", GBP, 0, ErrInvalidAmount},
+		{"", GBP, 0, ErrInvalidAmount},
+		{"-", GBP, 0, ErrInvalidAmount},
+		{"1e3", GBP, 0, ErrInvalidAmount},
+		{"1/2", GBP, 0, ErrInvalidAmount},
+		{"1,00", GBP, 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+" "+tt.currency.String(), func(t *testing.T) {
+			a, err := Parse(tt.s, tt.currency)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.Minor() != tt.minor || a.Currency() != tt.currency {
+				t.Errorf("got %d %s, want %d %s", a.Minor(), a.Currency(), tt.minor, tt.currency)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{New(1299, GBP), "12.99"},
+		{New(5, GBP), "0.05"},
+		{New(-5, GBP), "-0.05"},
+		{New(0, GBP), "0.00"},
+		{New(1500, JPY), "1500"},
+		{New(math.MinInt64, GBP), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("String of %d %s: got %q, want %q", tt.amount.Minor(), tt.amount.Currency(), got, tt.want)
+		}
+	}
+}
+
+func TestAddOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Amount
+		want int64
+		err  error
+	}{
+		{"sum", New(150, GBP), New(-50, GBP), 100, nil},
+		{"zero without a currency", Amount{}, New(50, GBP), 50, nil},
+		{"largest", New(math.MaxInt64-1, GBP), New(1, GBP), math.MaxInt64, nil},
+		{"smallest", New(math.MinInt64+1, GBP), New(-1, GBP), math.MinInt64, nil},
+		{"above the largest", New(math.MaxInt64, GBP), New(1, GBP), 0, ErrInvalidAmount},
+		{"below the smallest", New(math.MinInt64, GBP), New(-1, GBP), 0, ErrInvalidAmount},
+		{"currencies differ", New(1, GBP), New(1, EUR), 0, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Add(tt.b)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Minor() != tt.want || got.Currency() != GBP {
+				t.Errorf("got %s, want %d GBP", got.Format(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSubOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Amount
+		want int64
+		err  error
+	}{
+		{"difference", New(150, GBP), New(50, GBP), 100, nil},
+		{"from zero without a currency", Amount{}, New(50, GBP), -50, nil},
+		{"largest", New(-1, GBP), New(math.MinInt64, GBP), math.MaxInt64, nil},
+		{"smallest", New(math.MinInt64+1, GBP), New(1, GBP), math.MinInt64, nil},
+		{"above the largest", New(0, GBP), New(math.MinInt64, GBP), 0, ErrInvalidAmount},
+		{"below the smallest", New(math.MinInt64, GBP), New(1, GBP), 0, ErrInvalidAmount},
+		{"currencies differ", New(1, GBP), New(1, EUR), 0, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Sub(tt.b)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Minor() != tt.want || got.Currency() != GBP {
+				t.Errorf("got %s, want %d GBP", got.Format(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMulOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		minor    int64
+		quantity int64
+		want     int64
+		err      error
+	}{
+		{"product", 1299, 3, 3897, nil},
+		{"by zero", math.MaxInt64, 0, 0, nil},
+		{"negative", 250, -2, -500, nil},
+		{"largest", math.MaxInt64 / 2, 2, math.MaxInt64 - 1, nil},
+		{"above the largest", math.MaxInt64/2 + 1, 2, 0, ErrInvalidAmount},
+		{"below the smallest", math.MaxInt64/2 + 1, -2, 0, ErrInvalidAmount},
+		{"smallest quantity", 1, math.MinInt64, 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.minor, GBP).Mul(tt.quantity)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Minor() != tt.want {
+				t.Errorf("got %d, want %d", got.Minor(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	// Each factor is applied to 100 minor units, so the unrounded result is
+	// 100 times the factor.
+	tests := []struct {
+		factor string
+		mode   RoundingMode
+		want   int64
+	}{
+		{"0.025", RoundHalfUp, 3},
+		{"0.035", RoundHalfUp, 4},
+		{"-0.025", RoundHalfUp, -3},
+		{"0.024", RoundHalfUp, 2},
+		{"0.025", RoundHalfEven, 2},
+		{"0.035", RoundHalfEven, 4},
+		{"-0.025", RoundHalfEven, -2},
+		{"0.026", RoundHalfEven, 3},
+		{"0.029", RoundDown, 2},
+		{"-0.029", RoundDown, -2},
+		{"0.021", RoundUp, 3},
+		{"-0.021", RoundUp, -3},
+		{"0.029", RoundFloor, 2},
+		{"-0.021", RoundFloor, -3},
+		{"0.021", RoundCeiling, 3},
+		{"-0.029", RoundCeiling, -2},
+		{"0.03", RoundUp, 3},
+		{"0.03", RoundDown, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.factor+" "+tt.mode.String(), func(t *testing.T) {
+			factor, ok := new(big.Rat).SetString(tt.factor)
+			if !ok {
+				t.Fatalf("bad factor %q", tt.factor)
+			}
+
+			if got := New(100, GBP).MulRat(factor, tt.mode); got.Minor() != tt.want {
+				t.Errorf("got %d, want %d", got.Minor(), tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rate, err := ParseRate("1.1642")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount Amount
+		to     Currency
+		mode   RoundingMode
+		want   string
+	}{
+		{MustParse("10.00", GBP), EUR, RoundHalfUp, "11.64"},
+		{MustParse("10.00", GBP), EUR, RoundUp, "11.65"},
+		{MustParse("0.25", GBP), USD, RoundHalfUp, "0.29"},
+		{MustParse("0.25", GBP), USD, RoundDown, "0.29"},
+		{MustParse("0.25", GBP), USD, RoundHalfEven, "0.29"},
+		{MustParse("12.50", GBP), JPY, RoundHalfEven, "15"},
+		{MustParse("12.50", GBP), JPY, RoundDown, "14"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount.Format()+" to "+tt.to.String()+" "+tt.mode.String(), func(t *testing.T) {
+			got := tt.amount.Convert(tt.to, rate, tt.mode)
+			if got.String() != tt.want || got.Currency() != tt.to {
+				t.Errorf("got %s, want %s %s", got.Format(), tt.want, tt.to)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		minor int64
+		n     int
+		want  []int64
+	}{
+		{100, 3, []int64{34, 33, 33}},
+		{-100, 3, []int64{-34, -33, -33}},
+		{5, 4, []int64{2, 1, 1, 1}},
+		{2, 4, []int64{1, 1, 0, 0}},
+		{90, 3, []int64{30, 30, 30}},
+		{7, 1, []int64{7}},
+		{7, 0, nil},
+	}
+
+	for _, tt := range tests {
+		parts := New(tt.minor, GBP).Allocate(tt.n)
+		if len(parts) != len(tt.want) {
+			t.Errorf("Allocate(%d) of %d: got %d parts, want %d", tt.n, tt.minor, len(parts), len(tt.want))
+			continue
+		}
+
+		var sum int64
+		for i, p := range parts {
+			if p.Minor() != tt.want[i] || p.Currency() != GBP {
+				t.Errorf("Allocate(%d) of %d: part %d is %s, want %d GBP", tt.n, tt.minor, i, p.Format(), tt.want[i])
+			}
+			sum += p.Minor()
+		}
+		if len(parts) > 0 && sum != tt.minor {
+			t.Errorf("Allocate(%d) of %d: parts add up to %d", tt.n, tt.minor, sum)
+		}
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		code string
+		want Currency
+		err  error
+	}{
+		{"GBP", GBP, nil},
+		{" eur ", EUR, nil},
+		{"jpy", JPY, nil},
+		{"KWD", "", ErrUnknownCurrency},
+		{"XYZ", "", ErrUnknownCurrency},
+		{"", "", ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCurrency(tt.code)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParseCurrency(%q): got %q, %v, want %q, %v", tt.code, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCurrencyExponents(t *testing.T) {
+	for c, exp := range exponents {
+		if exp > maxExponent {
+			t.Errorf("%s has %d minor-unit digits, more than the %d money columns store", c, exp, maxExponent)
+		}
+	}
+}
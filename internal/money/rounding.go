@@ -0,0 +1,97 @@
+package money
+
+import (
+	"math/big"
+)
+
+// RoundingMode decides how a fractional number of minor units is rounded to
+// a whole one.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds to the nearest unit, with halves away from zero.
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds to the nearest unit, with halves to the even
+	// neighbour (banker's rounding).
+	RoundHalfEven
+	// RoundDown truncates towards zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+	// RoundFloor rounds towards negative infinity.
+	RoundFloor
+	// RoundCeiling rounds towards positive infinity.
+	RoundCeiling
+)
+
+var roundingModes = map[string]RoundingMode{
+	"half_up":   RoundHalfUp,
+	"half_even": RoundHalfEven,
+	"down":      RoundDown,
+	"up":        RoundUp,
+	"floor":     RoundFloor,
+	"ceiling":   RoundCeiling,
+}
+
+// ParseRoundingMode returns the mode for one of half_up, half_even, down, up,
+// floor or ceiling.
+func ParseRoundingMode(name string) (RoundingMode, bool) {
+	mode, ok := roundingModes[name]
+	return mode, ok
+}
+
+func (m RoundingMode) String() string {
+	for name, mode := range roundingModes {
+		if mode == m {
+			return name
+		}
+	}
+
+	return "unknown"
+}
+
+// round rounds r to an integer using mode.
+func round(r *big.Rat, mode RoundingMode) int64 {
+	num, denom := r.Num(), r.Denom()
+
+	// big.Int.QuoRem truncates towards zero.
+	quo, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if rem.Sign() == 0 {
+		return quo.Int64()
+	}
+
+	negative := r.Sign() < 0
+	away := false
+
+	switch mode {
+	case RoundDown:
+	case RoundUp:
+		away = true
+	case RoundFloor:
+		away = negative
+	case RoundCeiling:
+		away = !negative
+	case RoundHalfUp, RoundHalfEven:
+		// Compare twice the remainder with the denominator to tell whether
+		// the fraction is below, at or above one half.
+		twice := new(big.Int).Abs(rem)
+		twice.Lsh(twice, 1)
+
+		switch twice.Cmp(denom) {
+		case 1:
+			away = true
+		case 0:
+			away = mode == RoundHalfUp || quo.Bit(0) == 1
+		}
+	}
+
+	if away {
+		if negative {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+
+	return quo.Int64()
+}
@@ -0,0 +1,213 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Magic card numbers understood by FakeGateway.
+const (
+	// CardSuccess authorizes and captures immediately.
+	CardSuccess = "4242424242424242"
+	// CardDeclined is declined at authorization.
+	CardDeclined = "4000000000000002"
+	// CardInsufficientFunds is declined at authorization for lack of funds.
+	CardInsufficientFunds = "4000000000009995"
+	// CardDelayedSuccess authorizes, but its capture stays pending until a
+	// payment.captured webhook event confirms it.
+	CardDelayedSuccess = "4000000000003063"
+	// CardDelayedFailure authorizes, but its capture stays pending until a
+	// payment.failed webhook event rejects it.
+	CardDelayedFailure = "4000000000003055"
+)
+
+var ErrUnknownPayment = errors.New("unknown payment")
+
+type fakePayment struct {
+	reference string
+	card      string
+	status    Status
+}
+
+// FakeGateway is an in-process Gateway for development and tests. Outcomes
+// are driven by the magic card numbers above; any other card is declined.
+// Delayed outcomes are delivered as signed webhook requests to webhookURL.
+type FakeGateway struct {
+	webhookURL string
+	secret     string
+	delay      time.Duration
+	client     *http.Client
+	logger     *zap.SugaredLogger
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+	// returns holds the results of voids and refunds by idempotency key.
+	returns map[string]*Result
+}
+
+func NewFakeGateway(webhookURL, secret string, delay time.Duration, logger *zap.SugaredLogger) *FakeGateway {
+	return &FakeGateway{
+		webhookURL: webhookURL,
+		secret:     secret,
+		delay:      delay,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+		payments:   make(map[string]*fakePayment),
+		returns:    make(map[string]*Result),
+	}
+}
+
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+func (g *FakeGateway) Authorize(_ context.Context, req AuthorizeRequest) (*Result, error) {
+	ref := "fake_" + uuid.NewString()
+	res := &Result{ProviderRef: ref, Status: StatusAuthorized}
+
+	switch req.CardNumber {
+	case CardSuccess, CardDelayedSuccess, CardDelayedFailure:
+	case CardDeclined:
+		res.Status, res.DeclineReason = StatusDeclined, "card_declined"
+	case CardInsufficientFunds:
+		res.Status, res.DeclineReason = StatusDeclined, "insufficient_funds"
+	default:
+		res.Status, res.DeclineReason = StatusDeclined, "card_not_supported"
+	}
+
+	g.mu.Lock()
+	g.payments[ref] = &fakePayment{reference: req.Reference, card: req.CardNumber, status: res.Status}
+	g.mu.Unlock()
+
+	return res, nil
+}
+
+func (g *FakeGateway) Capture(_ context.Context, providerRef string, _ money.Amount) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, err := g.transition(providerRef, StatusAuthorized)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.card {
+	case CardDelayedSuccess:
+		p.status = StatusPending
+		g.notifyLater(providerRef, p.reference, "payment.captured", StatusCaptured, "")
+	case CardDelayedFailure:
+		p.status = StatusPending
+		g.notifyLater(providerRef, p.reference, "payment.failed", StatusFailed, "processing_error")
+	default:
+		p.status = StatusCaptured
+	}
+
+	return &Result{ProviderRef: providerRef, Status: p.status}, nil
+}
+
+func (g *FakeGateway) Void(_ context.Context, providerRef, idempotencyKey string) (*Result, error) {
+	return g.giveBack(providerRef, idempotencyKey, StatusAuthorized, StatusVoided)
+}
+
+func (g *FakeGateway) Refund(_ context.Context, providerRef string, _ money.Amount, idempotencyKey string) (*Result, error) {
+	return g.giveBack(providerRef, idempotencyKey, StatusCaptured, StatusRefunded)
+}
+
+// giveBack moves a payment from required to status, unless a call with the
+// same idempotency key has already done so, in which case its result is
+// returned again.
+func (g *FakeGateway) giveBack(providerRef, idempotencyKey string, required, status Status) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if res, ok := g.returns[idempotencyKey]; ok {
+		return res, nil
+	}
+
+	p, err := g.transition(providerRef, required)
+	if err != nil {
+		return nil, err
+	}
+	p.status = status
+
+	res := &Result{ProviderRef: providerRef, Status: p.status}
+	g.returns[idempotencyKey] = res
+
+	return res, nil
+}
+
+// transition returns the payment if it is in the required status. The caller
+// must hold g.mu.
+func (g *FakeGateway) transition(providerRef string, required Status) (*fakePayment, error) {
+	p, ok := g.payments[providerRef]
+	if !ok {
+		return nil, ErrUnknownPayment
+	}
+
+	if p.status != required {
+		return nil, fmt.Errorf("payment %s is %s, not %s", providerRef, p.status, required)
+	}
+
+	return p, nil
+}
+
+// notifyLater settles a pending payment after the configured delay and posts
+// the outcome to the webhook URL. The caller must hold g.mu.
+func (g *FakeGateway) notifyLater(providerRef, reference, eventType string, status Status, reason string) {
+	time.AfterFunc(g.delay, func() {
+		g.mu.Lock()
+		if p, ok := g.payments[providerRef]; ok {
+			p.status = status
+		}
+		g.mu.Unlock()
+
+		event := Event{
+			ID:          "evt_" + uuid.NewString(),
+			Type:        eventType,
+			ProviderRef: providerRef,
+			Reference:   reference,
+			Status:      status,
+			Reason:      reason,
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		if err := g.send(event); err != nil {
+			g.logger.Errorw("fake gateway failed to deliver webhook", "event", event.ID, "error", err)
+		}
+	})
+}
+
+func (g *FakeGateway) send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(g.secret, time.Now(), body))
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package payments
+
+import (
+	"context"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"slices"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusDeclined   Status = "declined"
+	StatusFailed     Status = "failed"
+	StatusVoided     Status = "voided"
+	StatusRefunded   Status = "refunded"
+	// StatusVoidPending and StatusRefundPending mark payments whose money is
+	// to be given back but which the gateway has not yet voided or refunded.
+	StatusVoidPending   Status = "void_pending"
+	StatusRefundPending Status = "refund_pending"
+)
+
+// statusTransitions lists the statuses a payment may move to from each
+// status. Webhook events that would move a payment backwards are ignored.
+var statusTransitions = map[Status][]Status{
+	StatusPending:    {StatusAuthorized, StatusCaptured, StatusDeclined, StatusFailed},
+	StatusAuthorized: {StatusCaptured, StatusFailed, StatusVoided, StatusVoidPending},
+	StatusCaptured:   {StatusRefunded, StatusRefundPending},
+	StatusDeclined:   {},
+	StatusFailed:     {},
+	StatusVoided:     {},
+	StatusRefunded:   {},
+	// A capture confirmed by webhook may land before the void, in which case
+	// the payment has to be refunded instead.
+	StatusVoidPending:   {StatusVoided, StatusCaptured, StatusFailed},
+	StatusRefundPending: {StatusRefunded},
+}
+
+func (s Status) CanTransitionTo(to Status) bool {
+	return slices.Contains(statusTransitions[s], to)
+}
+
+type AuthorizeRequest struct {
+	// Reference identifies the payment on our side and is echoed back in
+	// webhook events.
+	Reference  string
+	Amount     money.Amount
+	CardNumber string
+}
+
+// Result is the outcome of a gateway call. A pending status means the
+// provider will confirm the outcome later through a webhook event.
+type Result struct {
+	ProviderRef   string
+	Status        Status
+	DeclineReason string
+}
+
+// Gateway is a payment processor. Declines are reported through
+// Result.Status; an error means the call itself failed.
+//
+// Void and Refund take an idempotency key. Repeating a call with the same key
+// returns the first call's result rather than giving the money back twice, so
+// failed or interrupted calls can be retried safely.
+type Gateway interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error)
+	Capture(ctx context.Context, providerRef string, amount money.Amount) (*Result, error)
+	Void(ctx context.Context, providerRef, idempotencyKey string) (*Result, error)
+	Refund(ctx context.Context, providerRef string, amount money.Amount, idempotencyKey string) (*Result, error)
+}
+
+// Event is an asynchronous notification from a provider about a payment.
+type Event struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	ProviderRef string    `json:"provider_ref"`
+	Reference   string    `json:"reference"`
+	Status      Status    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
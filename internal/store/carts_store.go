@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"time"
+)
+
+type Cart struct {
+	ID        uuid.UUID    `json:"id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Items     []CartItem   `json:"items"`
+	Subtotal  money.Amount `json:"subtotal"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type CartItem struct {
+	ProductID uuid.UUID         `json:"product_id"`
+	VariantID uuid.UUID         `json:"variant_id"`
+	Title     string            `json:"title"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	Quantity  int               `json:"quantity"`
+	UnitPrice money.Amount      `json:"unit_price"`
+	Subtotal  money.Amount      `json:"subtotal"`
+	// AvailableStock is the variant's stock not held by other users'
+	// reservations when the cart was read.
+	AvailableStock int `json:"available_stock"`
+	// PreviousUnitPrice is set when the line was re-priced because the
+	// variant's price changed since it was added.
+	PreviousUnitPrice *money.Amount `json:"previous_unit_price,omitempty"`
+}
+
+type CartStore struct {
+	db querier
+}
+
+// CartGet returns the user's cart, creating an empty one if they have none.
+// Lines whose variant price has changed since they were added are re-priced
+// at the current price.
+func (s *CartStore) CartGet(ctx context.Context, userID uuid.UUID) (*Cart, error) {
+	var cart *Cart
+
+	err := withTx(s.db, ctx, func(q querier) error {
+		var err error
+		if cart, err = ensureCart(ctx, q, userID); err != nil {
+			return err
+		}
+
+		if cart.Items, err = getCartItems(ctx, q, cart.ID, userID); err != nil {
+			return err
+		}
+
+		for i := range cart.Items {
+			if cart.Items[i].PreviousUnitPrice == nil {
+				continue
+			}
+
+			if err := upsertCartItem(ctx, q, cart.ID, &cart.Items[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range cart.Items {
+		if cart.Subtotal, err = cart.Subtotal.Add(item.Subtotal); err != nil {
+			return nil, err
+		}
+	}
+
+	return cart, nil
+}
+
+// CartItemAdd adds quantity units of a variant to the user's cart, on top of
+// any already in it.
+func (s *CartStore) CartItemAdd(ctx context.Context, userID, variantID uuid.UUID, quantity int) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		cart, err := ensureCart(ctx, q, userID)
+		if err != nil {
+			return err
+		}
+
+		existing, err := getCartItemQuantity(ctx, q, cart.ID, variantID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		return setCartItemQuantity(ctx, q, cart.ID, userID, variantID, existing+quantity)
+	})
+}
+
+// CartItemUpdate replaces the quantity of a variant already in the user's cart.
+func (s *CartStore) CartItemUpdate(ctx context.Context, userID, variantID uuid.UUID, quantity int) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		cart, err := ensureCart(ctx, q, userID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := getCartItemQuantity(ctx, q, cart.ID, variantID); err != nil {
+			return err
+		}
+
+		return setCartItemQuantity(ctx, q, cart.ID, userID, variantID, quantity)
+	})
+}
+
+func (s *CartStore) CartItemRemove(ctx context.Context, userID, variantID uuid.UUID) error {
+	query := `DELETE FROM cart_items ci USING carts c
+		WHERE ci.cart_id = c.id AND c.user_id = $1 AND ci.variant_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, variantID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func ensureCart(ctx context.Context, q querier, userID uuid.UUID) (*Cart, error) {
+	// The no-op update makes RETURNING yield the existing cart on conflict.
+	query := `INSERT INTO carts (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING id, user_id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	cart := &Cart{Items: []CartItem{}}
+	err := q.QueryRowContext(ctx, query, userID).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		return nil, foreignKeyViolation(err)
+	}
+
+	return cart, nil
+}
+
+// getCartItems reads the lines of userID's cart priced at the variant's
+// current price, marking the lines whose stored price is out of date. Stock
+// the user holds counts as available to them.
+func getCartItems(ctx context.Context, q querier, cartID, userID uuid.UUID) ([]CartItem, error) {
+	query := `SELECT ci.product_id, ci.variant_id, p.title, v.sku, v.options, ci.quantity, ci.unit_price, v.price, p.currency,
+			v.stock - ` + heldStockByOthers("r.variant_id", "v.id", "$2") + `
+		FROM cart_items ci
+		JOIN product_variants v ON v.id = ci.variant_id
+		JOIN products p ON p.id = v.product_id
+		WHERE ci.cart_id = $1
+		ORDER BY ci.created_at, ci.variant_id
+		FOR UPDATE OF ci`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, cartID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	items := []CartItem{}
+	for rows.Next() {
+		var (
+			item                       CartItem
+			options                    []byte
+			lastPrice, price, currency string
+		)
+
+		if err := rows.Scan(
+			&item.ProductID,
+			&item.VariantID,
+			&item.Title,
+			&item.SKU,
+			&options,
+			&item.Quantity,
+			&lastPrice,
+			&price,
+			&currency,
+			&item.AvailableStock); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(options, &item.Options); err != nil {
+			return nil, err
+		}
+
+		last, err := parseAmount(lastPrice, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		if item.UnitPrice, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
+
+		if last != item.UnitPrice {
+			item.PreviousUnitPrice = &last
+		}
+		if item.Subtotal, err = item.UnitPrice.Mul(int64(item.Quantity)); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func getCartItemQuantity(ctx context.Context, q querier, cartID, variantID uuid.UUID) (int, error) {
+	query := `SELECT quantity FROM cart_items WHERE cart_id = $1 AND variant_id = $2 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var quantity int
+	err := q.QueryRowContext(ctx, query, cartID, variantID).Scan(&quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return quantity, nil
+}
+
+// setCartItemQuantity stores a line of userID's cart at the variant's current
+// price, refusing quantities the variant does not have in stock, less what
+// other users' reservations hold, and products in a different currency from
+// the rest of the cart.
+func setCartItemQuantity(ctx context.Context, q querier, cartID, userID, variantID uuid.UUID, quantity int) error {
+	query := `SELECT p.id, p.title, v.sku, v.price, p.currency, v.stock - ` + heldStockByOthers("r.variant_id", "v.id", "$2") + `
+		FROM product_variants v JOIN products p ON p.id = v.product_id
+		WHERE v.id = $1`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		item            = CartItem{VariantID: variantID, Quantity: quantity}
+		price, currency string
+	)
+	err := q.QueryRowContext(qctx, query, variantID, userID).Scan(&item.ProductID, &item.Title, &item.SKU, &price, &currency, &item.AvailableStock)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	if item.UnitPrice, err = parseAmount(price, currency); err != nil {
+		return err
+	}
+
+	if quantity > item.AvailableStock {
+		return ErrInsufficientStock
+	}
+
+	mixed, err := cartHasOtherCurrency(ctx, q, cartID, variantID, item.UnitPrice.Currency())
+	if err != nil {
+		return err
+	}
+
+	if mixed {
+		return ErrMixedCurrencies
+	}
+
+	return upsertCartItem(ctx, q, cartID, &item)
+}
+
+// cartHasOtherCurrency reports whether any line of the cart other than the
+// given variant is priced in a currency other than c.
+func cartHasOtherCurrency(ctx context.Context, q querier, cartID, variantID uuid.UUID, c money.Currency) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM cart_items ci JOIN products p ON p.id = ci.product_id
+		WHERE ci.cart_id = $1 AND ci.variant_id <> $2 AND p.currency <> $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	err := q.QueryRowContext(ctx, query, cartID, variantID, c).Scan(&exists)
+	return exists, err
+}
+
+func upsertCartItem(ctx context.Context, q querier, cartID uuid.UUID, item *CartItem) error {
+	query := `INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, unit_price) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (cart_id, variant_id) DO UPDATE
+		SET quantity = EXCLUDED.quantity, unit_price = EXCLUDED.unit_price, updated_at = CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, cartID, item.ProductID, item.VariantID, item.Quantity, item.UnitPrice)
+	return foreignKeyViolation(err)
+}
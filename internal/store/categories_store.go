@@ -0,0 +1,336 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"sort"
+	"time"
+)
+
+var (
+	ErrDuplicateSlug       = errors.New("a category with that slug already exists")
+	ErrCategoryCycle       = errors.New("a category cannot be moved under itself or one of its subcategories")
+	ErrCategoryHasChildren = errors.New("a category with subcategories cannot be deleted")
+)
+
+// Category is a node in the category tree.
+type Category struct {
+	ID          uuid.UUID  `json:"id"`
+	ParentID    *uuid.UUID `json:"parent_id"`
+	Name        string     `json:"name"`
+	Slug        string     `json:"slug"`
+	Description string     `json:"description"`
+	// ProductCount counts the products in the category or any of its
+	// subcategories, each product once. It is only set by CategoryGetAll.
+	ProductCount int         `json:"product_count"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	Children     []*Category `json:"children,omitempty"`
+}
+
+// CategoryRef is the short form of a category shown on products.
+type CategoryRef struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Slug string    `json:"slug"`
+}
+
+// CategoryTree nests a flat list of categories under their parents. It
+// returns the top-level categories, with every level sorted by name.
+func CategoryTree(categories []Category) []*Category {
+	nodes := make(map[uuid.UUID]*Category, len(categories))
+	for i := range categories {
+		c := categories[i]
+		c.Children = nil
+		nodes[c.ID] = &c
+	}
+
+	var roots []*Category
+	for _, c := range categories {
+		node := nodes[c.ID]
+		if c.ParentID == nil {
+			roots = append(roots, node)
+			continue
+		}
+
+		if parent, ok := nodes[*c.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	var sortByName func([]*Category)
+	sortByName = func(level []*Category) {
+		sort.Slice(level, func(i, j int) bool { return level[i].Name < level[j].Name })
+		for _, c := range level {
+			sortByName(c.Children)
+		}
+	}
+	sortByName(roots)
+
+	return roots
+}
+
+// descendantsQuery selects the ID of the category whose slug is the first
+// argument and of all its subcategories.
+const descendantsQuery = `WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE slug = %s
+		UNION ALL
+		SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+	) SELECT id FROM subtree`
+
+type CategoryStore struct {
+	db querier
+}
+
+func (s *CategoryStore) CategoryCreate(ctx context.Context, category *Category) error {
+	query := `INSERT INTO categories (parent_id, name, slug, description) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		category.ParentID,
+		category.Name,
+		category.Slug,
+		category.Description).Scan(&category.ID, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		return foreignKeyViolation(uniqueViolation(err, map[string]error{
+			"categories_slug_key": ErrDuplicateSlug,
+		}))
+	}
+
+	return nil
+}
+
+func (s *CategoryStore) CategoryGetByID(ctx context.Context, id uuid.UUID) (*Category, error) {
+	query := `SELECT id, parent_id, name, slug, description, created_at, updated_at FROM categories WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	c := &Category{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.ParentID,
+		&c.Name,
+		&c.Slug,
+		&c.Description,
+		&c.CreatedAt,
+		&c.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// CategoryGetAll returns every category, flat, with product counts. Use
+// CategoryTree to nest them.
+func (s *CategoryStore) CategoryGetAll(ctx context.Context) ([]Category, error) {
+	// subtree pairs every category with itself and each of its descendants,
+	// so that products in subcategories count towards their ancestors.
+	query := `WITH RECURSIVE subtree AS (
+			SELECT id AS root, id FROM categories
+			UNION ALL
+			SELECT s.root, c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+		)
+		SELECT c.id, c.parent_id, c.name, c.slug, c.description, c.created_at, c.updated_at,
+			COUNT(DISTINCT pc.product_id)
+		FROM categories c
+		JOIN subtree s ON s.root = c.id
+		LEFT JOIN product_categories pc ON pc.category_id = s.id
+		GROUP BY c.id
+		ORDER BY c.name`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(
+			&c.ID,
+			&c.ParentID,
+			&c.Name,
+			&c.Slug,
+			&c.Description,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.ProductCount); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// CategoryUpdate saves a category's fields, including moving it under another
+// parent. It fails with ErrCategoryCycle if the new parent is the category
+// itself or one of its subcategories.
+func (s *CategoryStore) CategoryUpdate(ctx context.Context, category *Category) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if category.ParentID != nil {
+			// Walk up from the new parent; reaching the category means it
+			// would become its own ancestor.
+			query := `WITH RECURSIVE ancestors AS (
+					SELECT id, parent_id FROM categories WHERE id = $1
+					UNION
+					SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+				) SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+
+			var cycle bool
+			if err := q.QueryRowContext(qctx, query, category.ParentID, category.ID).Scan(&cycle); err != nil {
+				return err
+			}
+
+			if cycle {
+				return ErrCategoryCycle
+			}
+		}
+
+		query := `UPDATE categories SET parent_id = $1, name = $2, slug = $3, description = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5 RETURNING updated_at`
+
+		err := q.QueryRowContext(qctx, query,
+			category.ParentID,
+			category.Name,
+			category.Slug,
+			category.Description,
+			category.ID).Scan(&category.UpdatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return foreignKeyViolation(uniqueViolation(err, map[string]error{
+					"categories_slug_key": ErrDuplicateSlug,
+				}))
+			}
+		}
+
+		return nil
+	})
+}
+
+// CategoryDelete deletes a category and unlinks its products. Categories
+// that still have subcategories cannot be deleted.
+func (s *CategoryStore) CategoryDelete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM categories WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation {
+			return ErrCategoryHasChildren
+		}
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CategorySetForProduct replaces the categories a product is listed in.
+func (s *CategoryStore) CategorySetForProduct(ctx context.Context, productID uuid.UUID, categoryIDs []uuid.UUID) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := q.ExecContext(qctx, `DELETE FROM product_categories WHERE product_id = $1`, productID); err != nil {
+			return err
+		}
+
+		if len(categoryIDs) == 0 {
+			return nil
+		}
+
+		query := `INSERT INTO product_categories (product_id, category_id)
+			SELECT $1, unnest($2::uuid[]) ON CONFLICT DO NOTHING`
+
+		ids := make([]string, len(categoryIDs))
+		for i, id := range categoryIDs {
+			ids[i] = id.String()
+		}
+
+		if _, err := q.ExecContext(qctx, query, productID, pq.Array(ids)); err != nil {
+			return foreignKeyViolation(err)
+		}
+
+		return nil
+	})
+}
+
+// CategoryGetByProduct returns the categories a product is listed in.
+func (s *CategoryStore) CategoryGetByProduct(ctx context.Context, productID uuid.UUID) ([]CategoryRef, error) {
+	query := `SELECT c.id, c.name, c.slug FROM categories c
+		JOIN product_categories pc ON pc.category_id = c.id
+		WHERE pc.product_id = $1 ORDER BY c.name`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	categories := []CategoryRef{}
+	for rows.Next() {
+		var c CategoryRef
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
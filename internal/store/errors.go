@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound          = errors.New("resource not found")
+	ErrConflict          = errors.New("resource conflict")
+	ErrDuplicateEmail    = errors.New("a user with that email already exists")
+	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrDuplicateReview   = errors.New("you have already reviewed this product")
+	ErrInsufficientStock = errors.New("not enough stock to fulfil the requested quantity")
+	ErrMixedCurrencies   = errors.New("products priced in different currencies cannot be bought together")
+	// ErrVersionConflict is returned when a row changed after the version
+	// an update was based on was read.
+	ErrVersionConflict = errors.New("resource has been modified since it was read")
+)
+
+// Postgres SQLSTATE codes the stores translate into store errors.
+const (
+	pqNotNullViolation    = "23502"
+	pqForeignKeyViolation = "23503"
+	pqUniqueViolation     = "23505"
+	pqCheckViolation      = "23514"
+)
+
+// uniqueViolation maps the unique constraint that a Postgres error violated to
+// its store error. Errors that are not unique violations are returned as is.
+func uniqueViolation(err error, constraints map[string]error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != pqUniqueViolation {
+		return err
+	}
+
+	if mapped, ok := constraints[pqErr.Constraint]; ok {
+		return mapped
+	}
+
+	return ErrConflict
+}
+
+// foreignKeyViolation reports a row that references a missing parent as
+// ErrNotFound. Other errors are returned as is.
+func foreignKeyViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation {
+		return ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"math/big"
+	"time"
+)
+
+// ExchangeRate is the number of units of Quote that one unit of Base buys.
+type ExchangeRate struct {
+	Base      money.Currency
+	Quote     money.Currency
+	Rate      *big.Rat
+	UpdatedAt time.Time
+}
+
+type ExchangeRateStore struct {
+	db querier
+}
+
+// ExchangeRateUpsert stores rates in a single transaction, replacing any
+// existing rate for the same pair of currencies.
+func (s *ExchangeRateStore) ExchangeRateUpsert(ctx context.Context, rates []ExchangeRate) error {
+	query := `INSERT INTO exchange_rates (base, quote, rate) VALUES ($1, $2, $3)
+		ON CONFLICT (base, quote) DO UPDATE SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP`
+
+	return withTx(s.db, ctx, func(q querier) error {
+		for _, rate := range rates {
+			qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+			_, err := q.ExecContext(qctx, query, rate.Base, rate.Quote, money.FormatRate(rate.Rate))
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ExchangeRateGetAllTo returns the rate from every currency that can be
+// converted into quote, keyed by that currency. Pairs only stored the other
+// way round are inverted, and quote itself converts at 1.
+func (s *ExchangeRateStore) ExchangeRateGetAllTo(ctx context.Context, quote money.Currency) (map[money.Currency]*big.Rat, error) {
+	query := `SELECT base, quote, rate FROM exchange_rates WHERE quote = $1 OR base = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, quote)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	direct := map[money.Currency]*big.Rat{quote: big.NewRat(1, 1)}
+	inverse := map[money.Currency]*big.Rat{}
+	for rows.Next() {
+		var (
+			base, to money.Currency
+			value    string
+		)
+		if err := rows.Scan(&base, &to, &value); err != nil {
+			return nil, err
+		}
+
+		rate, err := money.ParseRate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if to == quote {
+			direct[base] = rate
+		} else {
+			inverse[to] = rate.Inv(rate)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for c, rate := range inverse {
+		if _, ok := direct[c]; !ok {
+			direct[c] = rate
+		}
+	}
+
+	return direct, nil
+}
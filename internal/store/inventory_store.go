@@ -0,0 +1,290 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMovement = errors.New("movement quantity has the wrong sign for its kind")
+
+// MovementKind says why a variant's stock changed.
+type MovementKind string
+
+const (
+	// MovementReceipt is new stock arriving.
+	MovementReceipt MovementKind = "receipt"
+	// MovementSale is stock leaving with an order.
+	MovementSale MovementKind = "sale"
+	// MovementReturn is sold stock coming back, e.g. from a cancelled order.
+	MovementReturn MovementKind = "return"
+	// MovementAdjustment is a correction, such as after a stock count.
+	MovementAdjustment MovementKind = "adjustment"
+	// MovementReservation is held stock taken for good when its reservation
+	// is committed.
+	MovementReservation MovementKind = "reservation"
+	// MovementTransfer is stock moving between warehouses. Transfers come in
+	// pairs, out of one warehouse and into another.
+	MovementTransfer MovementKind = "transfer"
+)
+
+// validQuantity reports whether a movement of the kind may change stock by
+// quantity.
+func (k MovementKind) validQuantity(quantity int) bool {
+	switch k {
+	case MovementReceipt, MovementReturn:
+		return quantity > 0
+	case MovementSale:
+		return quantity < 0
+	case MovementAdjustment, MovementReservation, MovementTransfer:
+		return quantity != 0
+	default:
+		return false
+	}
+}
+
+// InventoryMovement is an entry in the inventory ledger. Quantity is signed:
+// negative quantities take stock away.
+type InventoryMovement struct {
+	ID        uuid.UUID `json:"id"`
+	ProductID uuid.UUID `json:"product_id"`
+	// VariantID is nil once the variant has been deleted. SKU is a snapshot
+	// taken when the movement was recorded, so it still names the variant.
+	VariantID *uuid.UUID `json:"variant_id"`
+	SKU       string     `json:"sku"`
+	// WarehouseID is the warehouse whose stock changed. Movements recorded
+	// without one go to the seller's first warehouse.
+	WarehouseID *uuid.UUID   `json:"warehouse_id"`
+	Kind        MovementKind `json:"kind"`
+	Quantity    int          `json:"quantity"`
+	// Balance is the variant's stock after the movement.
+	Balance int `json:"balance"`
+	// ProductBalance is the product's total stock after the movement. It is
+	// only set by InventoryGetHistory.
+	ProductBalance *int       `json:"product_balance,omitempty"`
+	Reason         string     `json:"reason"`
+	OrderID        *uuid.UUID `json:"order_id"`
+	ReservationID  *uuid.UUID `json:"reservation_id"`
+	ActorID        *uuid.UUID `json:"actor_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// InventoryQuery pages through a product's ledger, newest first.
+type InventoryQuery struct {
+	ProductID uuid.UUID
+	// VariantID narrows the ledger to one of the product's variants.
+	VariantID *uuid.UUID
+	Limit     int
+	Cursor    string
+}
+
+type InventoryPage struct {
+	Movements  []InventoryMovement `json:"data"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	HasMore    bool                `json:"has_more"`
+}
+
+type InventoryStore struct {
+	db querier
+}
+
+// InventoryRecord applies a movement to its variant's stock and appends it
+// to the ledger. It fails with ErrInsufficientStock if the variant would be
+// left with negative stock.
+func (s *InventoryStore) InventoryRecord(ctx context.Context, movement *InventoryMovement) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		return recordMovement(ctx, q, movement)
+	})
+}
+
+// InventoryGetHistory returns a page of a product's ledger, newest first,
+// with the running stock of the variant and of the product after each
+// movement.
+func (s *InventoryStore) InventoryGetHistory(ctx context.Context, iq InventoryQuery) (*InventoryPage, error) {
+	limit := iq.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	limit = min(limit, MaxPageLimit)
+
+	args := []any{iq.ProductID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var where []string
+	if iq.VariantID != nil {
+		where = append(where, "m.variant_id = "+arg(*iq.VariantID))
+	}
+
+	if iq.Cursor != "" {
+		c, err := decodeCursor(iq.Cursor)
+		if err != nil || c.Sort != "-created_at" {
+			return nil, ErrInvalidCursor
+		}
+
+		if _, err := time.Parse(cursorTimeFormat, c.Value); err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		where = append(where, fmt.Sprintf("(m.created_at, m.id) < (%s::timestamp, %s)", arg(c.Value), arg(c.ID)))
+	}
+
+	// The product's running balance is summed over its whole ledger before
+	// any filtering, so that it is right on every page.
+	query := `SELECT m.id, m.product_id, m.variant_id, m.sku, m.warehouse_id, m.kind, m.quantity, m.balance, m.product_balance,
+			m.reason, m.order_id, m.reservation_id, m.actor_id, m.created_at
+		FROM (
+			SELECT *, SUM(quantity) OVER (ORDER BY created_at, id) AS product_balance
+			FROM inventory_movements WHERE product_id = $1
+		) m`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to find out whether another page follows.
+	query += " ORDER BY m.created_at DESC, m.id DESC LIMIT " + arg(limit+1)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	movements := []InventoryMovement{}
+	for rows.Next() {
+		var m InventoryMovement
+		if err := rows.Scan(
+			&m.ID,
+			&m.ProductID,
+			&m.VariantID,
+			&m.SKU,
+			&m.WarehouseID,
+			&m.Kind,
+			&m.Quantity,
+			&m.Balance,
+			&m.ProductBalance,
+			&m.Reason,
+			&m.OrderID,
+			&m.ReservationID,
+			&m.ActorID,
+			&m.CreatedAt); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &InventoryPage{Movements: movements}
+	if len(movements) > limit {
+		page.Movements = movements[:limit]
+		page.HasMore = true
+
+		last := page.Movements[limit-1]
+		page.NextCursor = cursor{
+			Sort:  "-created_at",
+			Value: last.CreatedAt.UTC().Format(cursorTimeFormat),
+			ID:    last.ID,
+		}.encode()
+	}
+
+	return page, nil
+}
+
+// recordMovement is the only way stock changes: it moves the stock of the
+// variant and of its warehouse by movement.Quantity and appends the
+// movement, with the balance it leaves, to the ledger. Run it inside a
+// transaction. The stock check constraints guard against negative stock.
+func recordMovement(ctx context.Context, q querier, movement *InventoryMovement) error {
+	if !movement.Kind.validQuantity(movement.Quantity) {
+		return fmt.Errorf("%w: %s of %d", ErrInvalidMovement, movement.Kind, movement.Quantity)
+	}
+
+	if movement.WarehouseID == nil {
+		id, err := defaultWarehouse(ctx, q, movement.ProductID)
+		if err != nil {
+			return err
+		}
+		movement.WarehouseID = &id
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, `UPDATE product_variants SET stock = stock + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND product_id = $3 RETURNING stock, sku`,
+		movement.Quantity,
+		movement.VariantID,
+		movement.ProductID).Scan(&movement.Balance, &movement.SKU)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == pqCheckViolation:
+			return ErrInsufficientStock
+		default:
+			return err
+		}
+	}
+
+	// Only the warehouses of the product's seller can hold its stock.
+	query := `INSERT INTO warehouse_stock (warehouse_id, variant_id, product_id, quantity)
+		SELECT w.id, $2, p.id, $4 FROM warehouses w JOIN products p ON p.user_id = w.user_id
+		WHERE w.id = $1 AND p.id = $3
+		ON CONFLICT (warehouse_id, variant_id) DO UPDATE
+			SET quantity = warehouse_stock.quantity + EXCLUDED.quantity, updated_at = CURRENT_TIMESTAMP
+		RETURNING quantity`
+
+	var warehouseStock int
+	err = q.QueryRowContext(ctx, query,
+		*movement.WarehouseID,
+		movement.VariantID,
+		movement.ProductID,
+		movement.Quantity).Scan(&warehouseStock)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("warehouse %s: %w", *movement.WarehouseID, ErrNotFound)
+		case errors.As(err, &pqErr) && pqErr.Code == pqCheckViolation:
+			return ErrInsufficientStock
+		default:
+			return err
+		}
+	}
+
+	query = `INSERT INTO inventory_movements (product_id, variant_id, sku, warehouse_id, kind, quantity, balance, reason,
+			order_id, reservation_id, actor_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created_at`
+
+	return q.QueryRowContext(ctx, query,
+		movement.ProductID,
+		movement.VariantID,
+		movement.SKU,
+		movement.WarehouseID,
+		movement.Kind,
+		movement.Quantity,
+		movement.Balance,
+		movement.Reason,
+		movement.OrderID,
+		movement.ReservationID,
+		movement.ActorID).Scan(&movement.ID, &movement.CreatedAt)
+}
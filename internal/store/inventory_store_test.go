@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInventoryGetHistoryAfterRecordMovement(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	product := createTestProduct(t, s, 5)
+	variant := product.Variants[0]
+
+	reservation := &StockReservation{ProductID: product.ID, VariantID: variant.ID, UserID: product.UserID, Quantity: 2}
+	if err := s.Reservations.ReservationCreate(ctx, reservation, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reservations.ReservationCommit(ctx, reservation, product.UserID, AllocatePriority); err != nil {
+		t.Fatal(err)
+	}
+
+	adjustment := &InventoryMovement{ProductID: product.ID, VariantID: &variant.ID, Kind: MovementAdjustment, Quantity: -1, Reason: "damaged"}
+	if err := s.Inventory.InventoryRecord(ctx, adjustment); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := s.Inventory.InventoryGetHistory(ctx, InventoryQuery{ProductID: product.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind              MovementKind
+		quantity, balance int
+		reservation       bool
+	}{
+		{MovementAdjustment, -1, 2, false},
+		{MovementReservation, -2, 3, true},
+		{MovementReceipt, 5, 5, false},
+	}
+	if len(page.Movements) != len(want) {
+		t.Fatalf("got %d movements, want %d", len(page.Movements), len(want))
+	}
+
+	for i, w := range want {
+		m := page.Movements[i]
+		if m.Kind != w.kind || m.Quantity != w.quantity || m.Balance != w.balance {
+			t.Errorf("movement %d: got %s of %d leaving %d, want %s of %d leaving %d",
+				i, m.Kind, m.Quantity, m.Balance, w.kind, w.quantity, w.balance)
+		}
+		if m.ProductBalance == nil || *m.ProductBalance != w.balance {
+			t.Errorf("movement %d: got product balance %v, want %d", i, m.ProductBalance, w.balance)
+		}
+		if w.reservation && (m.ReservationID == nil || *m.ReservationID != reservation.ID) {
+			t.Errorf("movement %d: got reservation %v, want %s", i, m.ReservationID, reservation.ID)
+		}
+		if !w.reservation && m.ReservationID != nil {
+			t.Errorf("movement %d: got reservation %s, want none", i, *m.ReservationID)
+		}
+		if m.WarehouseID == nil {
+			t.Errorf("movement %d: no warehouse", i)
+		}
+	}
+}
+
+func TestInventoryMovementsOutliveTheirProduct(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	product := createTestProduct(t, s, 5)
+	variant := product.Variants[0]
+
+	if err := s.Products.ProductDelete(ctx, product.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	db := s.Inventory.(*InventoryStore).db
+
+	var (
+		sku                  string
+		productID, variantID *string
+	)
+	query := `SELECT sku, product_id, variant_id FROM inventory_movements WHERE sku = $1`
+	if err := db.QueryRowContext(ctx, query, variant.SKU).Scan(&sku, &productID, &variantID); err != nil {
+		t.Fatalf("reading the movement of the deleted product: %v", err)
+	}
+	if productID != nil || variantID != nil {
+		t.Errorf("got product %v and variant %v, want both cleared", productID, variantID)
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM inventory_movements WHERE sku = $1`, variant.SKU); err == nil {
+		t.Error("deleted a movement from the ledger")
+	}
+}
@@ -0,0 +1,727 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Order struct {
+	ID        uuid.UUID    `json:"id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Status    OrderStatus  `json:"status"`
+	Total     money.Amount `json:"total"`
+	Items     []OrderItem  `json:"items"`
+	Events    []OrderEvent `json:"events,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// OrderEvent records a change of an order's status. From is nil for the
+// event that created the order and ActorID is nil when the system made the
+// change rather than a user.
+type OrderEvent struct {
+	ID        uuid.UUID    `json:"id"`
+	From      *OrderStatus `json:"from"`
+	To        OrderStatus  `json:"to"`
+	ActorID   *uuid.UUID   `json:"actor_id"`
+	Note      string       `json:"note"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// OrderItem is an immutable snapshot of a product variant as it was bought.
+// ProductID and VariantID are nil once the product or variant has been
+// deleted.
+type OrderItem struct {
+	ID        uuid.UUID         `json:"id"`
+	ProductID *uuid.UUID        `json:"product_id"`
+	VariantID *uuid.UUID        `json:"variant_id"`
+	Title     string            `json:"title"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	UnitPrice money.Amount      `json:"unit_price"`
+	Quantity  int               `json:"quantity"`
+	Subtotal  money.Amount      `json:"subtotal"`
+}
+
+// OrderLine is a variant and quantity requested at checkout.
+type OrderLine struct {
+	VariantID uuid.UUID
+	Quantity  int
+}
+
+type StockShortage struct {
+	ProductID uuid.UUID `json:"product_id"`
+	VariantID uuid.UUID `json:"variant_id"`
+	SKU       string    `json:"sku"`
+	Requested int       `json:"requested"`
+	Available int       `json:"available"`
+}
+
+// InsufficientStockError lists every variant that could not cover the
+// quantity requested. It matches ErrInsufficientStock with errors.Is.
+type InsufficientStockError struct {
+	Shortages []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	skus := make([]string, len(e.Shortages))
+	for i, s := range e.Shortages {
+		skus[i] = s.SKU
+	}
+
+	return fmt.Sprintf("%s: %s", ErrInsufficientStock, strings.Join(skus, ", "))
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+type OrderStore struct {
+	db querier
+}
+
+// OrderCheckout places an order for lines on behalf of a user. The variants
+// are locked, their stock is taken through the inventory ledger and the order
+// is written with a price snapshot of every line, all in a single
+// transaction. Stock held by other users' reservations cannot be bought,
+// while the user's own holds on the variants are committed to the order up to
+// the quantities bought.
+// Each line's stock is taken from the warehouses strategy picks.
+func (s *OrderStore) OrderCheckout(ctx context.Context, userID uuid.UUID, lines []OrderLine, strategy AllocationStrategy) (*Order, error) {
+	// Merge repeated variants so each is locked and decremented once.
+	quantities := make(map[uuid.UUID]int, len(lines))
+	for _, line := range lines {
+		quantities[line.VariantID] += line.Quantity
+	}
+
+	ids := make([]uuid.UUID, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+
+	order := &Order{UserID: userID, Items: []OrderItem{}}
+
+	err := withTx(s.db, ctx, func(q querier) error {
+		variants, err := lockVariantsForCheckout(ctx, q, ids)
+		if err != nil {
+			return err
+		}
+
+		held, err := getHeldByOthers(ctx, q, ids, userID)
+		if err != nil {
+			return err
+		}
+
+		var shortages []StockShortage
+		for _, v := range variants {
+			if available := v.Stock - held[v.ID]; quantities[v.ID] > available {
+				shortages = append(shortages, StockShortage{
+					ProductID: v.ProductID,
+					VariantID: v.ID,
+					SKU:       v.SKU,
+					Requested: quantities[v.ID],
+					Available: max(available, 0),
+				})
+			}
+		}
+
+		if len(shortages) > 0 {
+			return &InsufficientStockError{Shortages: shortages}
+		}
+
+		order.Total = money.Zero(variants[0].Price.Currency())
+		for _, v := range variants {
+			if v.Price.Currency() != order.Total.Currency() {
+				return ErrMixedCurrencies
+			}
+		}
+
+		for _, v := range variants {
+			subtotal, err := v.Price.Mul(int64(quantities[v.ID]))
+			if err != nil {
+				return err
+			}
+
+			productID, variantID := v.ProductID, v.ID
+			item := OrderItem{
+				ProductID: &productID,
+				VariantID: &variantID,
+				Title:     v.Title,
+				SKU:       v.SKU,
+				Options:   v.Options,
+				UnitPrice: v.Price,
+				Quantity:  quantities[v.ID],
+				Subtotal:  subtotal,
+			}
+
+			order.Items = append(order.Items, item)
+			if order.Total, err = order.Total.Add(item.Subtotal); err != nil {
+				return err
+			}
+		}
+
+		if err := createOrder(ctx, q, order); err != nil {
+			return err
+		}
+
+		// Take the stock in the order the variants were locked in.
+		for i := range order.Items {
+			item := &order.Items[i]
+
+			picks, err := allocateStock(ctx, q, *item.VariantID, item.Quantity, strategy)
+			if err != nil {
+				return err
+			}
+
+			for _, pick := range picks {
+				sale := &InventoryMovement{
+					ProductID:   *item.ProductID,
+					VariantID:   item.VariantID,
+					WarehouseID: &pick.WarehouseID,
+					Kind:        MovementSale,
+					Quantity:    -pick.Quantity,
+					Reason:      "Order placed",
+					OrderID:     &order.ID,
+					ActorID:     &userID,
+				}
+				if err := recordMovement(ctx, q, sale); err != nil {
+					return err
+				}
+			}
+
+			if err := createOrderItem(ctx, q, order.ID, item); err != nil {
+				return err
+			}
+
+			if err := commitHolds(ctx, q, order.ID, userID, *item.VariantID, item.Quantity); err != nil {
+				return err
+			}
+		}
+
+		event := OrderEvent{To: order.Status, ActorID: &userID}
+		if err := createOrderEvent(ctx, q, order.ID, &event); err != nil {
+			return err
+		}
+		order.Events = []OrderEvent{event}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (s *OrderStore) OrderGetByID(ctx context.Context, orderID uuid.UUID) (*Order, error) {
+	query := `SELECT id, user_id, status, total, currency, created_at, updated_at FROM orders WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		order           = &Order{}
+		total, currency string
+	)
+	err := s.db.QueryRowContext(ctx, query, orderID).Scan(
+		&order.ID,
+		&order.UserID,
+		&order.Status,
+		&total,
+		&currency,
+		&order.CreatedAt,
+		&order.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if order.Total, err = parseAmount(total, currency); err != nil {
+		return nil, err
+	}
+
+	items, err := getOrderItems(ctx, s.db, []uuid.UUID{order.ID})
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items[order.ID]
+
+	if order.Events, err = getOrderEvents(ctx, s.db, order.ID); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// OrderTransition moves an order to a new status if its lifecycle allows it,
+// recording who made the change. Cancelling an order returns its items to
+// stock.
+func (s *OrderStore) OrderTransition(ctx context.Context, orderID uuid.UUID, to OrderStatus, actorID *uuid.UUID, note string) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		from, err := lockOrderStatus(ctx, q, orderID)
+		if err != nil {
+			return err
+		}
+
+		if !from.CanTransitionTo(to) {
+			return &InvalidTransitionError{From: from, To: to}
+		}
+
+		if err := updateOrderStatus(ctx, q, orderID, to); err != nil {
+			return err
+		}
+
+		if to == OrderCancelled {
+			if err := restockOrder(ctx, q, orderID, actorID); err != nil {
+				return err
+			}
+		}
+
+		event := OrderEvent{From: &from, To: to, ActorID: actorID, Note: note}
+		return createOrderEvent(ctx, q, orderID, &event)
+	})
+}
+
+// OrderHasSeller reports whether the user listed any of the products in an
+// order.
+func (s *OrderStore) OrderHasSeller(ctx context.Context, orderID, userID uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1 AND p.user_id = $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, orderID, userID).Scan(&exists)
+	return exists, err
+}
+
+// OrderGetSellers returns the users who listed the products in an order.
+func (s *OrderStore) OrderGetSellers(ctx context.Context, orderID uuid.UUID) ([]uuid.UUID, error) {
+	query := `SELECT DISTINCT p.user_id FROM order_items oi JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	sellers := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		sellers = append(sellers, id)
+	}
+
+	return sellers, rows.Err()
+}
+
+// OrderLockStatus returns an order's status. Inside a transaction the order
+// stays locked until it ends, so the status cannot change underneath it.
+func (s *OrderStore) OrderLockStatus(ctx context.Context, orderID uuid.UUID) (OrderStatus, error) {
+	return lockOrderStatus(ctx, s.db, orderID)
+}
+
+// OrderGetAllByUser returns a user's orders, most recent first.
+func (s *OrderStore) OrderGetAllByUser(ctx context.Context, userID uuid.UUID) ([]Order, error) {
+	query := `SELECT id, user_id, status, total, currency, created_at, updated_at FROM orders
+		WHERE user_id = $1 ORDER BY created_at DESC, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	orders := []Order{}
+	for rows.Next() {
+		var (
+			o               Order
+			total, currency string
+		)
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &total, &currency, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		if o.Total, err = parseAmount(total, currency); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+	}
+
+	items, err := getOrderItems(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		orders[i].Items = items[orders[i].ID]
+	}
+
+	return orders, nil
+}
+
+type checkoutVariant struct {
+	ID        uuid.UUID
+	ProductID uuid.UUID
+	Title     string
+	SKU       string
+	Options   map[string]string
+	Price     money.Amount
+	Stock     int
+}
+
+// lockVariantsForCheckout locks the given variants and their products in
+// product then variant ID order, so that two concurrent checkouts can never
+// wait on each other's locks, and fails with ErrNotFound if any of them does
+// not exist. The products are locked too because changing a variant's stock
+// updates its product's summary.
+func lockVariantsForCheckout(ctx context.Context, q querier, ids []uuid.UUID) ([]checkoutVariant, error) {
+	query := `SELECT v.id, v.product_id, p.title, v.sku, v.options, v.price, p.currency, v.stock
+		FROM product_variants v JOIN products p ON p.id = v.product_id
+		WHERE v.id = ANY($1)
+		ORDER BY p.id, v.id
+		FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var variants []checkoutVariant
+	for rows.Next() {
+		var (
+			v               checkoutVariant
+			options         []byte
+			price, currency string
+		)
+		if err := rows.Scan(&v.ID, &v.ProductID, &v.Title, &v.SKU, &options, &price, &currency, &v.Stock); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(options, &v.Options); err != nil {
+			return nil, err
+		}
+
+		if v.Price, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if !slices.ContainsFunc(variants, func(v checkoutVariant) bool { return v.ID == id }) {
+			return nil, fmt.Errorf("variant %s: %w", id, ErrNotFound)
+		}
+	}
+
+	return variants, nil
+}
+
+func createOrder(ctx context.Context, q querier, order *Order) error {
+	query := `INSERT INTO orders (user_id, total, currency) VALUES ($1, $2, $3)
+		RETURNING id, status, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, query, order.UserID, order.Total, order.Total.Currency()).Scan(
+		&order.ID,
+		&order.Status,
+		&order.CreatedAt,
+		&order.UpdatedAt)
+	if err != nil {
+		return foreignKeyViolation(err)
+	}
+
+	return nil
+}
+
+func createOrderItem(ctx context.Context, q querier, orderID uuid.UUID, item *OrderItem) error {
+	query := `INSERT INTO order_items (order_id, product_id, variant_id, title, sku, options, unit_price, quantity, subtotal)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+
+	if item.Options == nil {
+		item.Options = map[string]string{}
+	}
+
+	options, err := json.Marshal(item.Options)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return q.QueryRowContext(ctx, query,
+		orderID,
+		item.ProductID,
+		item.VariantID,
+		item.Title,
+		item.SKU,
+		options,
+		item.UnitPrice,
+		item.Quantity,
+		item.Subtotal).Scan(&item.ID)
+}
+
+// getOrderItems returns the line items of the given orders keyed by order ID.
+func getOrderItems(ctx context.Context, q querier, orderIDs []uuid.UUID) (map[uuid.UUID][]OrderItem, error) {
+	query := `SELECT oi.id, oi.order_id, oi.product_id, oi.variant_id, oi.title, oi.sku, oi.options, oi.unit_price, oi.quantity, oi.subtotal, o.currency
+		FROM order_items oi JOIN orders o ON o.id = oi.order_id
+		WHERE oi.order_id = ANY($1) ORDER BY oi.title, oi.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	items := make(map[uuid.UUID][]OrderItem, len(orderIDs))
+	for _, id := range orderIDs {
+		items[id] = []OrderItem{}
+	}
+
+	for rows.Next() {
+		var (
+			item                          OrderItem
+			orderID                       uuid.UUID
+			options                       []byte
+			unitPrice, subtotal, currency string
+		)
+		if err := rows.Scan(
+			&item.ID,
+			&orderID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.Title,
+			&item.SKU,
+			&options,
+			&unitPrice,
+			&item.Quantity,
+			&subtotal,
+			&currency); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(options, &item.Options); err != nil {
+			return nil, err
+		}
+
+		if item.UnitPrice, err = parseAmount(unitPrice, currency); err != nil {
+			return nil, err
+		}
+
+		if item.Subtotal, err = parseAmount(subtotal, currency); err != nil {
+			return nil, err
+		}
+		items[orderID] = append(items[orderID], item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func lockOrderStatus(ctx context.Context, q querier, orderID uuid.UUID) (OrderStatus, error) {
+	query := `SELECT status FROM orders WHERE id = $1 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var status OrderStatus
+	err := q.QueryRowContext(ctx, query, orderID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return status, nil
+}
+
+func updateOrderStatus(ctx context.Context, q querier, orderID uuid.UUID, status OrderStatus) error {
+	query := `UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, status, orderID)
+	return err
+}
+
+// restockOrder returns the items of an order to stock through the inventory
+// ledger. Items whose variant has since been deleted are skipped.
+func restockOrder(ctx context.Context, q querier, orderID uuid.UUID, actorID *uuid.UUID) error {
+	returns, err := getOrderReturns(ctx, q, orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, movement := range returns {
+		movement.ActorID = actorID
+		if err := recordMovement(ctx, q, movement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getOrderReturns builds the movements that put an order's items back into
+// stock, in the product then variant order checkout locks them in. Items go
+// back to the warehouses their sale took them from; items sold before
+// warehouses existed, or from since deleted ones, go to the seller's first.
+func getOrderReturns(ctx context.Context, q querier, orderID uuid.UUID) ([]*InventoryMovement, error) {
+	query := `SELECT v.product_id, v.id, s.warehouse_id, COALESCE(s.quantity, oi.quantity)
+		FROM order_items oi
+		JOIN product_variants v ON v.id = oi.variant_id
+		LEFT JOIN (
+			SELECT variant_id, warehouse_id, -SUM(quantity) AS quantity FROM inventory_movements
+			WHERE order_id = $1 AND kind = 'sale'
+			GROUP BY variant_id, warehouse_id
+		) s ON s.variant_id = oi.variant_id
+		WHERE oi.order_id = $1
+		ORDER BY v.product_id, v.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var returns []*InventoryMovement
+	for rows.Next() {
+		movement := &InventoryMovement{Kind: MovementReturn, Reason: "Order cancelled", OrderID: &orderID}
+		if err := rows.Scan(&movement.ProductID, &movement.VariantID, &movement.WarehouseID, &movement.Quantity); err != nil {
+			return nil, err
+		}
+		returns = append(returns, movement)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return returns, nil
+}
+
+func createOrderEvent(ctx context.Context, q querier, orderID uuid.UUID, event *OrderEvent) error {
+	query := `INSERT INTO order_events (order_id, from_status, to_status, actor_id, note)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return q.QueryRowContext(ctx, query, orderID, event.From, event.To, event.ActorID, event.Note).Scan(&event.ID, &event.CreatedAt)
+}
+
+func getOrderEvents(ctx context.Context, q querier, orderID uuid.UUID) ([]OrderEvent, error) {
+	query := `SELECT id, from_status, to_status, actor_id, note, created_at
+		FROM order_events WHERE order_id = $1 ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	events := []OrderEvent{}
+	for rows.Next() {
+		var e OrderEvent
+		if err := rows.Scan(&e.ID, &e.From, &e.To, &e.ActorID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/payments"
+	"time"
+)
+
+// Payment is an attempt to pay for an order through a payment gateway.
+// ProviderRef is nil until the gateway has accepted the payment.
+type Payment struct {
+	ID            uuid.UUID       `json:"id"`
+	UserID        uuid.UUID       `json:"user_id"`
+	OrderID       uuid.UUID       `json:"order_id"`
+	Provider      string          `json:"provider"`
+	ProviderRef   *string         `json:"provider_ref"`
+	Amount        money.Amount    `json:"amount"`
+	Status        payments.Status `json:"status"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// ErrPaymentInProgress is returned when an order already has a payment that
+// is in flight or has been taken.
+var ErrPaymentInProgress = errors.New("the order already has a payment in progress")
+
+type PaymentStore struct {
+	db querier
+}
+
+func (s *PaymentStore) PaymentCreate(ctx context.Context, payment *Payment) error {
+	query := `INSERT INTO payments (user_id, order_id, provider, amount, currency)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, status, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		payment.UserID,
+		payment.OrderID,
+		payment.Provider,
+		payment.Amount,
+		payment.Amount.Currency(),
+	).Scan(
+		&payment.ID,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt)
+	if err != nil {
+		return foreignKeyViolation(uniqueViolation(err, map[string]error{
+			"payments_order_id_active_key": ErrPaymentInProgress,
+		}))
+	}
+
+	return nil
+}
+
+// PaymentUpdate stores the gateway's latest view of a payment.
+func (s *PaymentStore) PaymentUpdate(ctx context.Context, payment *Payment) error {
+	query := `UPDATE payments
+		SET provider_ref = $1, status = $2, failure_reason = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		payment.ProviderRef,
+		payment.Status,
+		payment.FailureReason,
+		payment.ID,
+	).Scan(&payment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return uniqueViolation(err, nil)
+		}
+	}
+
+	return nil
+}
+
+// PaymentGetAllByOrder returns an order's payments, oldest first. Inside a
+// transaction they stay locked until it ends.
+func (s *PaymentStore) PaymentGetAllByOrder(ctx context.Context, orderID uuid.UUID) ([]Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE order_id = $1 ORDER BY created_at, id FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// PaymentGetByID returns a payment. Inside a transaction it stays locked until
+// it ends.
+func (s *PaymentStore) PaymentGetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE id = $1 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	payment, err := scanPayment(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return payment, nil
+}
+
+// PaymentGetAllReturning returns the payments still waiting for the gateway to
+// void or refund them, longest waiting first.
+func (s *PaymentStore) PaymentGetAllReturning(ctx context.Context) ([]Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments
+		WHERE status IN ($1, $2) ORDER BY updated_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, payments.StatusVoidPending, payments.StatusRefundPending)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	returning := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		returning = append(returning, *payment)
+	}
+
+	return returning, rows.Err()
+}
+
+// PaymentGetByProviderRef returns the payment the gateway knows by ref. Inside
+// a transaction the payment stays locked until it ends, so concurrent webhook
+// deliveries for the same payment are applied one at a time.
+func (s *PaymentStore) PaymentGetByProviderRef(ctx context.Context, ref string) (*Payment, error) {
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider_ref = $1 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	payment, err := scanPayment(s.db.QueryRowContext(ctx, query, ref))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return payment, nil
+}
+
+// PaymentRecordEvent stores a webhook event against a payment. It reports
+// false if the event has been recorded before, in which case it must not be
+// applied again.
+func (s *PaymentStore) PaymentRecordEvent(ctx context.Context, paymentID uuid.UUID, eventID, eventType string, payload []byte) (bool, error) {
+	query := `INSERT INTO payment_events (id, payment_id, type, payload) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, eventID, paymentID, eventType, payload)
+	if err != nil {
+		return false, foreignKeyViolation(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// paymentColumns selects a payment in the order scanPayment reads it.
+const paymentColumns = `id, user_id, order_id, provider, provider_ref, amount, currency, status, failure_reason,
+	created_at, updated_at`
+
+func scanPayment(row interface{ Scan(...any) error }) (*Payment, error) {
+	var (
+		payment          = &Payment{}
+		amount, currency string
+	)
+	err := row.Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.OrderID,
+		&payment.Provider,
+		&payment.ProviderRef,
+		&amount,
+		&currency,
+		&payment.Status,
+		&payment.FailureReason,
+		&payment.CreatedAt,
+		&payment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Amount, err = parseAmount(amount, currency); err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
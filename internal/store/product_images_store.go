@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+var ErrTooManyImages = errors.New("product has too many images")
+
+// ProductImage is an uploaded product image. The image and its thumbnails are
+// kept in the blob store; Key and ThumbnailKeys locate them there.
+type ProductImage struct {
+	ID            uuid.UUID         `json:"id"`
+	ProductID     uuid.UUID         `json:"product_id"`
+	Position      int               `json:"position"`
+	ContentType   string            `json:"content_type"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Size          int64             `json:"size"`
+	Key           string            `json:"-"`
+	ThumbnailKeys map[string]string `json:"-"`
+	// URL and Thumbnails are filled in from the blob store by the API.
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type ProductImageStore struct {
+	db querier
+}
+
+// ProductImageAdd appends images to a product, in order, after any it already
+// has. It fails with ErrTooManyImages if the product would end up with more
+// than limit images.
+func (s *ProductImageStore) ProductImageAdd(ctx context.Context, productID uuid.UUID, images []*ProductImage, limit int) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Lock the product so that concurrent uploads take turns picking
+		// positions.
+		var count, next int
+		err := q.QueryRowContext(qctx, `SELECT
+				(SELECT COUNT(*) FROM product_images WHERE product_id = p.id),
+				(SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = p.id)
+			FROM products p WHERE p.id = $1 FOR UPDATE`, productID).Scan(&count, &next)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if count+len(images) > limit {
+			return fmt.Errorf("%w: at most %d are allowed", ErrTooManyImages, limit)
+		}
+
+		query := `INSERT INTO product_images (id, product_id, position, content_type, width, height, size, key, thumbnail_keys)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING created_at`
+
+		for i, img := range images {
+			thumbnails, err := json.Marshal(img.ThumbnailKeys)
+			if err != nil {
+				return err
+			}
+
+			img.ProductID = productID
+			img.Position = next + i
+
+			err = q.QueryRowContext(qctx, query,
+				img.ID,
+				img.ProductID,
+				img.Position,
+				img.ContentType,
+				img.Width,
+				img.Height,
+				img.Size,
+				img.Key,
+				thumbnails).Scan(&img.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ProductImageGetAll returns a product's images in display order.
+func (s *ProductImageStore) ProductImageGetAll(ctx context.Context, productID uuid.UUID) ([]ProductImage, error) {
+	query := `SELECT id, product_id, position, content_type, width, height, size, key, thumbnail_keys, created_at
+		FROM product_images WHERE product_id = $1 ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	images := []ProductImage{}
+	for rows.Next() {
+		img, err := scanProductImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// ProductImageDelete removes an image from a product and returns it, so that
+// the caller can delete its blobs.
+func (s *ProductImageStore) ProductImageDelete(ctx context.Context, productID, imageID uuid.UUID) (*ProductImage, error) {
+	query := `DELETE FROM product_images WHERE product_id = $1 AND id = $2
+		RETURNING id, product_id, position, content_type, width, height, size, key, thumbnail_keys, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	img, err := scanProductImage(s.db.QueryRowContext(ctx, query, productID, imageID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+func scanProductImage(row interface{ Scan(...any) error }) (*ProductImage, error) {
+	var (
+		img        ProductImage
+		thumbnails []byte
+	)
+	err := row.Scan(
+		&img.ID,
+		&img.ProductID,
+		&img.Position,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Size,
+		&img.Key,
+		&thumbnails,
+		&img.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(thumbnails, &img.ThumbnailKeys); err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateSKU     = errors.New("a variant with that SKU already exists")
+	ErrDuplicateVariant = errors.New("the product already has a variant with those options")
+	ErrLastVariant      = errors.New("a product must keep at least one variant")
+)
+
+// ProductVariant is a purchasable version of a product, such as one colour of
+// it. Variants are priced in their product's currency.
+type ProductVariant struct {
+	ID        uuid.UUID `json:"id"`
+	ProductID uuid.UUID `json:"product_id"`
+	SKU       string    `json:"sku"`
+	// Options holds option values by option name, e.g. {"colour": "black"}.
+	Options        map[string]string `json:"options"`
+	Price          money.Amount      `json:"price"`
+	ConvertedPrice *money.Conversion `json:"converted_price,omitempty"`
+	Stock          int               `json:"stock"`
+	// AvailableStock is Stock less the units held by active reservations.
+	AvailableStock int `json:"available_stock"`
+	// Warehouses splits Stock by warehouse. It is only set on product reads.
+	Warehouses []StockLevel `json:"warehouses,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+type ProductVariantStore struct {
+	db querier
+}
+
+// ProductVariantCreate adds a variant to a product. The variant must be
+// priced in the product's currency. Its stock is booked into the inventory
+// ledger as a receipt by actorID.
+func (s *ProductVariantStore) ProductVariantCreate(ctx context.Context, variant *ProductVariant, actorID uuid.UUID) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var currency money.Currency
+		err := q.QueryRowContext(qctx, `SELECT currency FROM products WHERE id = $1 FOR UPDATE`, variant.ProductID).Scan(&currency)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if variant.Price.Currency() != currency {
+			return ErrMixedCurrencies
+		}
+
+		if err := insertVariant(ctx, q, variant, actorID); err != nil {
+			return err
+		}
+
+		return bumpProductVersion(ctx, q, variant.ProductID)
+	})
+}
+
+func (s *ProductVariantStore) ProductVariantGetByID(ctx context.Context, productID, variantID uuid.UUID) (*ProductVariant, error) {
+	return getVariant(ctx, s.db, productID, variantID)
+}
+
+func getVariant(ctx context.Context, q querier, productID, variantID uuid.UUID) (*ProductVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM product_variants v JOIN products p ON p.id = v.product_id
+		WHERE v.product_id = $1 AND v.id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	variant, err := scanVariant(q.QueryRowContext(ctx, query, productID, variantID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return variant, nil
+}
+
+// ProductVariantGetAll returns a product's variants, oldest first.
+func (s *ProductVariantStore) ProductVariantGetAll(ctx context.Context, productID uuid.UUID) ([]ProductVariant, error) {
+	query := `SELECT ` + variantColumns + ` FROM product_variants v JOIN products p ON p.id = v.product_id
+		WHERE v.product_id = $1 ORDER BY v.created_at, v.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	variants := []ProductVariant{}
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, *variant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// ProductVariantUpdate saves a variant's SKU, options and price, bumping its
+// product's version. Stock only changes through the inventory ledger.
+func (s *ProductVariantStore) ProductVariantUpdate(ctx context.Context, variant *ProductVariant) error {
+	query := `UPDATE product_variants v SET sku = $1, options = $2, price = $3, updated_at = CURRENT_TIMESTAMP
+		FROM products p
+		WHERE p.id = v.product_id AND v.product_id = $4 AND v.id = $5 AND p.currency = $6
+		RETURNING v.stock, v.stock - ` + heldStock("r.variant_id", "v.id") + `, v.updated_at`
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := q.QueryRowContext(qctx, query,
+			variant.SKU,
+			options,
+			variant.Price,
+			variant.ProductID,
+			variant.ID,
+			variant.Price.Currency()).Scan(&variant.Stock, &variant.AvailableStock, &variant.UpdatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				// Either the variant is gone or the price is in another
+				// currency; tell the two apart.
+				if _, getErr := getVariant(ctx, q, variant.ProductID, variant.ID); getErr != nil {
+					return getErr
+				}
+				return ErrMixedCurrencies
+			default:
+				return variantViolation(err)
+			}
+		}
+
+		return bumpProductVersion(ctx, q, variant.ProductID)
+	})
+}
+
+// ProductVariantDelete removes a variant from a product. A product's last
+// variant cannot be deleted.
+func (s *ProductVariantStore) ProductVariantDelete(ctx context.Context, productID, variantID uuid.UUID) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Lock the product so that two deletes cannot each remove one of its
+		// last two variants.
+		var count int
+		err := q.QueryRowContext(qctx, `SELECT (SELECT COUNT(*) FROM product_variants WHERE product_id = p.id)
+			FROM products p WHERE p.id = $1 FOR UPDATE`, productID).Scan(&count)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		res, err := q.ExecContext(qctx, `DELETE FROM product_variants WHERE product_id = $1 AND id = $2`, productID, variantID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		if count <= 1 {
+			return ErrLastVariant
+		}
+
+		return bumpProductVersion(ctx, q, productID)
+	})
+}
+
+// variantColumns selects a variant, with its product's currency, in the order
+// scanVariant reads them.
+var variantColumns = `v.id, v.product_id, v.sku, v.options, v.price, p.currency, v.stock,
+	v.stock - ` + heldStock("r.variant_id", "v.id") + `, v.created_at, v.updated_at`
+
+func scanVariant(row interface{ Scan(...any) error }) (*ProductVariant, error) {
+	var (
+		v               ProductVariant
+		options         []byte
+		price, currency string
+	)
+	err := row.Scan(
+		&v.ID,
+		&v.ProductID,
+		&v.SKU,
+		&options,
+		&price,
+		&currency,
+		&v.Stock,
+		&v.AvailableStock,
+		&v.CreatedAt,
+		&v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(options, &v.Options); err != nil {
+		return nil, err
+	}
+
+	if v.Price, err = parseAmount(price, currency); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// insertVariant writes a new variant, generating its ID and, if it has none,
+// its SKU, and receives its stock through the inventory ledger. The caller
+// checks the variant's currency.
+func insertVariant(ctx context.Context, q querier, variant *ProductVariant, actorID uuid.UUID) error {
+	query := `INSERT INTO product_variants (id, product_id, sku, options, price, stock)
+		VALUES ($1, $2, $3, $4, $5, 0) RETURNING created_at, updated_at`
+
+	if variant.ID == uuid.Nil {
+		variant.ID = uuid.New()
+	}
+
+	if variant.SKU == "" {
+		variant.SKU = defaultSKU(variant.ProductID, variant.ID)
+	}
+
+	if variant.Options == nil {
+		variant.Options = map[string]string{}
+	}
+
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = q.QueryRowContext(qctx, query,
+		variant.ID,
+		variant.ProductID,
+		variant.SKU,
+		options,
+		variant.Price).Scan(&variant.CreatedAt, &variant.UpdatedAt)
+	if err != nil {
+		return foreignKeyViolation(variantViolation(err))
+	}
+
+	// A new variant cannot have been reserved yet.
+	variant.AvailableStock = variant.Stock
+	if variant.Stock == 0 {
+		return nil
+	}
+
+	movement := &InventoryMovement{
+		ProductID: variant.ProductID,
+		VariantID: &variant.ID,
+		Kind:      MovementReceipt,
+		Quantity:  variant.Stock,
+		Reason:    "Initial stock",
+		ActorID:   &actorID,
+	}
+
+	return recordMovement(ctx, q, movement)
+}
+
+// defaultSKU makes a SKU from the leading digits of the product and variant
+// IDs, e.g. "3F2A9C1B-7D04E2".
+func defaultSKU(productID, variantID uuid.UUID) string {
+	p := strings.ReplaceAll(productID.String(), "-", "")
+	v := strings.ReplaceAll(variantID.String(), "-", "")
+
+	return strings.ToUpper(p[:8] + "-" + v[:6])
+}
+
+func variantViolation(err error) error {
+	return uniqueViolation(err, map[string]error{
+		"product_variants_sku_key":                ErrDuplicateSKU,
+		"product_variants_product_id_options_key": ErrDuplicateVariant,
+	})
+}
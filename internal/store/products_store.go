@@ -6,6 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/tax"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,86 +18,316 @@ type Product struct {
 	UserID      uuid.UUID `json:"user_id"`
 	Title       string    `json:"name"`
 	Description string    `json:"description"`
-	Rating      int       `json:"rating"`
-	Price       float64   `json:"price"`
-	Stock       int       `json:"stock"`
-	Version     int       `json:"version"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Reviews     []Review  `json:"reviews"`
+	Rating      float64   `json:"rating"`
+	ReviewCount int       `json:"review_count"`
+	// Price is the lowest price of the product's variants, and sets the
+	// currency they are all priced in.
+	Price money.Amount `json:"price"`
+	// ConvertedPrice is set when the client asked for prices in a currency
+	// other than the product's own.
+	ConvertedPrice *money.Conversion `json:"converted_price,omitempty"`
+	// TaxClass picks the tax rate charged on the product, and
+	// PriceIncludesTax says whether its prices are gross or net of that tax.
+	TaxClass         tax.Class `json:"tax_class"`
+	PriceIncludesTax bool      `json:"price_includes_tax"`
+	// Stock is the total stock of the product's variants.
+	Stock int `json:"stock"`
+	// AvailableStock is Stock less the units held by active reservations.
+	AvailableStock int `json:"available_stock"`
+	// Warehouses splits Stock by warehouse. It is only set on product reads.
+	Warehouses []StockLevel `json:"warehouses,omitempty"`
+	// Version changes when the seller edits the product or its variants,
+	// but not when its stock moves.
+	Version    int              `json:"version"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	Variants   []ProductVariant `json:"variants"`
+	Reviews    []Review         `json:"reviews"`
+	Images     []ProductImage   `json:"images"`
+	Categories []CategoryRef    `json:"categories"`
 }
 
 type ProductSummary struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    uuid.UUID `json:"user_id"`
-	Title     string    `json:"title"`
-	Rating    int       `json:"rating"`
-	Price     float64   `json:"price"`
-	Version   int       `json:"version"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             uuid.UUID         `json:"id"`
+	UserID         uuid.UUID         `json:"user_id"`
+	Title          string            `json:"title"`
+	Rating         float64           `json:"rating"`
+	ReviewCount    int               `json:"review_count"`
+	Price          money.Amount      `json:"price"`
+	ConvertedPrice *money.Conversion `json:"converted_price,omitempty"`
+	Stock          int               `json:"stock"`
+	AvailableStock int               `json:"available_stock"`
+	Version        int               `json:"version"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// ProductQuery filters, orders and paginates ProductGetAll.
+type ProductQuery struct {
+	Limit    int
+	Cursor   string
+	SortBy   string // one of price, created_at or rating
+	Desc     bool
+	MinPrice *money.Amount
+	MaxPrice *money.Amount
+	// Currency only lists products priced in it. It must be set to filter or
+	// sort by price, as prices in different currencies cannot be compared.
+	Currency money.Currency
+	UserID   *uuid.UUID
+	InStock  bool
+	// Category is a category slug. Products in its subcategories match too.
+	Category string
+}
+
+type ProductPage struct {
+	Products   []ProductSummary `json:"data"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	HasMore    bool             `json:"has_more"`
+}
+
+// ProductSearchQuery is a full-text search over product titles and
+// descriptions. See toTSQuery for the supported syntax.
+type ProductSearchQuery struct {
+	Query  string
+	Limit  int
+	Cursor string
+}
+
+type ProductSearchResult struct {
+	ProductSummary
+	Rank float32 `json:"rank"`
+	// TitleHighlight and Snippet wrap matched terms in <mark> tags.
+	TitleHighlight string `json:"title_highlight"`
+	Snippet        string `json:"snippet"`
+}
+
+type ProductSearchPage struct {
+	Results    []ProductSearchResult `json:"data"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	HasMore    bool                  `json:"has_more"`
+}
+
+type productSort struct {
+	column string
+	// cast is the Postgres type a cursor value is cast back to.
+	cast string
+	// value returns the text form of the column for a row.
+	value func(ProductSummary) string
+	// valid reports whether a cursor value can be cast to the column type.
+	valid func(string) bool
+}
+
+var productSorts = map[string]productSort{
+	"price": {
+		column: "price",
+		cast:   "numeric",
+		value:  func(p ProductSummary) string { return p.Price.String() },
+		valid:  isNumeric,
+	},
+	"created_at": {
+		column: "created_at",
+		cast:   "timestamp",
+		value:  func(p ProductSummary) string { return p.CreatedAt.UTC().Format(cursorTimeFormat) },
+		valid: func(v string) bool {
+			_, err := time.Parse(cursorTimeFormat, v)
+			return err == nil
+		},
+	},
+	"rating": {
+		column: "rating",
+		cast:   "numeric",
+		value:  func(p ProductSummary) string { return strconv.FormatFloat(p.Rating, 'f', -1, 64) },
+		valid:  isNumeric,
+	},
+}
+
+const cursorTimeFormat = "2006-01-02T15:04:05.999999"
+
+func isNumeric(v string) bool {
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
 }
 
 type ProductStore struct {
-	db *sql.DB
+	db querier
 }
 
+// ProductCreate saves a product together with its variants, of which it
+// needs at least one. Price and Stock are set from the variants, whose stock
+// is booked into the inventory ledger as received from the seller.
 func (s *ProductStore) ProductCreate(ctx context.Context, product *Product) error {
-	if product.Version == 0 {
-		product.Version = 1
+	if len(product.Variants) == 0 {
+		return ErrLastVariant
 	}
 
-	query := `INSERT INTO products (user_id, title, description, rating, price, stock) 
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, updated_at`
-
-	row := s.db.QueryRowContext(ctx, query, product.UserID, product.Title, product.Description, product.Rating, product.Price, product.Stock)
+	product.Price = product.Variants[0].Price
+	product.Stock = 0
+	for _, v := range product.Variants {
+		if v.Price.Currency() != product.Price.Currency() {
+			return ErrMixedCurrencies
+		}
 
-	err := row.Scan(&product.ID, &product.CreatedAt, &product.UpdatedAt)
-	if err != nil {
-		return err
+		if cmp, _ := v.Price.Cmp(product.Price); cmp < 0 {
+			product.Price = v.Price
+		}
+		product.Stock += v.Stock
 	}
+	product.AvailableStock = product.Stock
 
-	return nil
+	return withTx(s.db, ctx, func(q querier) error {
+		query := `INSERT INTO products (user_id, title, description, price, currency, stock, tax_class, price_includes_tax)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id, version, created_at, updated_at`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		row := q.QueryRowContext(qctx, query,
+			product.UserID,
+			product.Title,
+			product.Description,
+			product.Price,
+			product.Price.Currency(),
+			product.Stock,
+			product.TaxClass,
+			product.PriceIncludesTax)
+
+		err := row.Scan(&product.ID, &product.Version, &product.CreatedAt, &product.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		for i := range product.Variants {
+			product.Variants[i].ProductID = product.ID
+			if err := insertVariant(ctx, q, &product.Variants[i], product.UserID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 func (s *ProductStore) ProductGetByID(ctx context.Context, productID uuid.UUID) (*Product, error) {
-	query := `SELECT id, user_id, title, description, price, stock, version, created_at, updated_at FROM products WHERE id = $1`
+	query := `SELECT id, user_id, title, description, rating, review_count, price, currency, tax_class,
+			price_includes_tax, stock, stock - ` + heldStock("r.product_id", "products.id") + `, version, created_at,
+			updated_at
+		FROM products WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
 	row := s.db.QueryRowContext(ctx, query, productID)
-	product := &Product{}
 
+	var (
+		product         = &Product{}
+		price, currency string
+	)
 	err := row.Scan(
 		&product.ID,
 		&product.UserID,
 		&product.Title,
 		&product.Description,
-		&product.Price,
+		&product.Rating,
+		&product.ReviewCount,
+		&price,
+		&currency,
+		&product.TaxClass,
+		&product.PriceIncludesTax,
 		&product.Stock,
+		&product.AvailableStock,
 		&product.Version,
 		&product.CreatedAt,
 		&product.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("product not found")
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 
+	if product.Price, err = parseAmount(price, currency); err != nil {
+		return nil, err
+	}
+
 	return product, nil
 }
 
-func (s *ProductStore) ProductGetAll(ctx context.Context) ([]ProductSummary, error) {
-	query := `SELECT id, user_id, title, price, rating, created_at, updated_at FROM products`
+func (s *ProductStore) ProductGetAll(ctx context.Context, pq ProductQuery) (*ProductPage, error) {
+	sort, ok := productSorts[pq.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", pq.SortBy)
+	}
+
+	if pq.Currency == "" && (pq.MinPrice != nil || pq.MaxPrice != nil || pq.SortBy == "price") {
+		return nil, errors.New("filtering or sorting by price needs a currency")
+	}
+
+	limit := pq.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	limit = min(limit, MaxPageLimit)
+
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if pq.Currency != "" {
+		where = append(where, "currency = "+arg(pq.Currency))
+	}
+	if pq.MinPrice != nil {
+		where = append(where, "price >= "+arg(*pq.MinPrice))
+	}
+	if pq.MaxPrice != nil {
+		where = append(where, "price <= "+arg(*pq.MaxPrice))
+	}
+	if pq.UserID != nil {
+		where = append(where, "user_id = "+arg(*pq.UserID))
+	}
+	if pq.InStock {
+		where = append(where, "stock > "+heldStock("r.product_id", "products.id"))
+	}
+	if pq.Category != "" {
+		where = append(where, fmt.Sprintf("id IN (SELECT product_id FROM product_categories WHERE category_id IN (%s))",
+			fmt.Sprintf(descendantsQuery, arg(pq.Category))))
+	}
+
+	sortKey := pq.SortBy
+	direction, comparison := "ASC", ">"
+	if pq.Desc {
+		sortKey = "-" + sortKey
+		direction, comparison = "DESC", "<"
+	}
+
+	if pq.Cursor != "" {
+		c, err := decodeCursor(pq.Cursor)
+		if err != nil || c.Sort != sortKey || !sort.valid(c.Value) {
+			return nil, ErrInvalidCursor
+		}
+
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			sort.column, comparison, arg(c.Value), sort.cast, arg(c.ID)))
+	}
+
+	query := `SELECT id, user_id, title, price, currency, rating, review_count, stock,
+		stock - ` + heldStock("r.product_id", "products.id") + `, version, created_at, updated_at FROM products`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to find out whether another page follows.
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", sort.column, direction, direction, arg(limit+1))
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,30 +338,48 @@ func (s *ProductStore) ProductGetAll(ctx context.Context) ([]ProductSummary, err
 		}
 	}(rows)
 
-	var products []ProductSummary
+	products := []ProductSummary{}
 	for rows.Next() {
-		p := ProductSummary{}
+		var (
+			p               ProductSummary
+			price, currency string
+		)
 		if err := rows.Scan(
 			&p.ID,
 			&p.UserID,
 			&p.Title,
-			&p.Price,
+			&price,
+			&currency,
 			&p.Rating,
+			&p.ReviewCount,
+			&p.Stock,
+			&p.AvailableStock,
+			&p.Version,
 			&p.CreatedAt,
 			&p.UpdatedAt); err != nil {
 			return nil, err
 		}
+
+		if p.Price, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
 		products = append(products, p)
 	}
 
 	if err := rows.Err(); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("product not found")
-		}
 		return nil, err
 	}
 
-	return products, nil
+	page := &ProductPage{Products: products}
+	if len(products) > limit {
+		page.Products = products[:limit]
+		page.HasMore = true
+
+		last := page.Products[limit-1]
+		page.NextCursor = cursor{Sort: sortKey, Value: sort.value(last), ID: last.ID}.encode()
+	}
+
+	return page, nil
 }
 
 func (s *ProductStore) ProductDelete(ctx context.Context, productID uuid.UUID) error {
@@ -147,23 +399,39 @@ func (s *ProductStore) ProductDelete(ctx context.Context, productID uuid.UUID) e
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("product not found")
+		return ErrNotFound
 	}
 
 	return nil
 }
 
+// ProductUpdate saves a product's title, description and tax treatment if it
+// is still at product.Version, bumping the version. It fails with
+// ErrVersionConflict if someone else has updated the product since that
+// version was read. Prices and stock belong to the variants and are changed
+// through them.
 func (s *ProductStore) ProductUpdate(ctx context.Context, product *Product) error {
-	query := `UPDATE products SET title = $1, description = $2, version = version + 1 WHERE id = $3 AND version = $4 RETURNING version`
+	query := `UPDATE products
+		SET title = $1, description = $2, tax_class = $3, price_includes_tax = $4, version = version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND version = $6 RETURNING version, updated_at`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, product.Title, product.Description, product.ID, product.Version).Scan(&product.Version)
+	row := s.db.QueryRowContext(ctx, query,
+		product.Title,
+		product.Description,
+		product.TaxClass,
+		product.PriceIncludesTax,
+		product.ID,
+		product.Version)
+
+	err := row.Scan(&product.Version, &product.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return fmt.Errorf("product not found")
+			return s.versionConflict(ctx, product.ID)
 		default:
 			return err
 		}
@@ -171,3 +439,151 @@ func (s *ProductStore) ProductUpdate(ctx context.Context, product *Product) erro
 
 	return nil
 }
+
+// bumpProductVersion changes a product's version, and so its ETag, after a
+// seller edits its variants. Stock changes from sales, reservations and
+// transfers leave it alone, so that they do not fail the seller's
+// conditional updates.
+func bumpProductVersion(ctx context.Context, q querier, productID uuid.UUID) error {
+	query := `UPDATE products SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, productID)
+	return err
+}
+
+// versionConflict tells apart a conditional update that matched no row
+// because the product is gone from one that lost a race with another update.
+func (s *ProductStore) versionConflict(ctx context.Context, productID uuid.UUID) error {
+	query := `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, productID).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrNotFound
+	}
+
+	return ErrVersionConflict
+}
+
+func (s *ProductStore) ProductSearch(ctx context.Context, sq ProductSearchQuery) (*ProductSearchPage, error) {
+	page := &ProductSearchPage{Results: []ProductSearchResult{}}
+
+	tsQuery := toTSQuery(sq.Query)
+	if tsQuery == "" {
+		return page, nil
+	}
+
+	limit := sq.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	limit = min(limit, MaxPageLimit)
+
+	args := []any{tsQuery}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var after string
+	if sq.Cursor != "" {
+		c, err := decodeCursor(sq.Cursor)
+		if err != nil || c.Sort != "rank" || !isNumeric(c.Value) {
+			return nil, ErrInvalidCursor
+		}
+
+		after = fmt.Sprintf("AND (ts_rank_cd(p.search, q.query), p.id) < (%s::real, %s)", arg(c.Value), arg(c.ID))
+	}
+
+	// Rank and page in the inner query so the comparatively expensive
+	// ts_headline only runs for the rows that are returned.
+	query := fmt.Sprintf(`SELECT id, user_id, title, price, currency, rating, review_count, stock, available_stock, version,
+			created_at, updated_at, rank,
+			ts_headline('english', title, query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>'),
+			ts_headline('english', coalesce(description, ''), query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=10, MaxWords=30')
+		FROM (
+			SELECT p.id, p.user_id, p.title, p.description, p.price, p.currency, p.rating, p.review_count, p.stock,
+				p.stock - %s AS available_stock, p.version, p.created_at, p.updated_at, ts_rank_cd(p.search, q.query) AS rank, q.query
+			FROM products p, to_tsquery('english', $1) AS q(query)
+			WHERE p.search @@ q.query %s
+			ORDER BY rank DESC, p.id DESC
+			LIMIT %s
+		) matches
+		ORDER BY rank DESC, id DESC`, heldStock("r.product_id", "p.id"), after, arg(limit+1))
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	for rows.Next() {
+		var (
+			r               ProductSearchResult
+			price, currency string
+		)
+		if err := rows.Scan(
+			&r.ID,
+			&r.UserID,
+			&r.Title,
+			&price,
+			&currency,
+			&r.Rating,
+			&r.ReviewCount,
+			&r.Stock,
+			&r.AvailableStock,
+			&r.Version,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Rank,
+			&r.TitleHighlight,
+			&r.Snippet); err != nil {
+			return nil, err
+		}
+
+		if r.Price, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
+		page.Results = append(page.Results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Results) > limit {
+		page.Results = page.Results[:limit]
+		page.HasMore = true
+
+		last := page.Results[limit-1]
+		value := strconv.FormatFloat(float64(last.Rank), 'g', -1, 32)
+		page.NextCursor = cursor{Sort: "rank", Value: value, ID: last.ID}.encode()
+	}
+
+	return page, nil
+}
+
+// parseAmount reads an amount stored as separate NUMERIC and currency
+// columns.
+func parseAmount(amount, currency string) (money.Amount, error) {
+	c, err := money.ParseCurrency(currency)
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return money.Parse(amount, c)
+}
@@ -0,0 +1,490 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"math/big"
+	"slices"
+	"time"
+)
+
+var ErrInvalidPromotion = errors.New("invalid promotion")
+
+type PromotionKind string
+
+const (
+	// PromotionPercentage takes a percentage off the items it applies to.
+	PromotionPercentage PromotionKind = "percentage"
+	// PromotionFixed takes a fixed amount off the items it applies to,
+	// shared out between them by value.
+	PromotionFixed PromotionKind = "fixed"
+)
+
+// Why a promotion was not applied to a basket.
+const (
+	RejectUnknownCode      = "no promotion has this code"
+	RejectInactive         = "the promotion is not active"
+	RejectNotStarted       = "the promotion has not started yet"
+	RejectEnded            = "the promotion has ended"
+	RejectUsedUp           = "the promotion has been used up"
+	RejectUsedUpByUser     = "you have used the promotion as many times as allowed"
+	RejectCurrency         = "the promotion is for baskets in another currency"
+	RejectMinBasket        = "the basket is below the promotion's minimum value"
+	RejectNoEligibleItems  = "none of the items in the basket are covered by the promotion"
+	RejectNotStackable     = "the promotion cannot be combined with the others applied"
+	RejectDuplicateInCodes = "the code was given more than once"
+)
+
+// Promotion is a coupon redeemed by its code.
+type Promotion struct {
+	ID          uuid.UUID     `json:"id"`
+	Code        string        `json:"code"`
+	Description string        `json:"description"`
+	Kind        PromotionKind `json:"kind"`
+	// Percent is the percentage off, e.g. "12.5", of percentage promotions.
+	Percent string `json:"percent,omitempty"`
+	// Amount is the amount off of fixed promotions.
+	Amount *money.Amount `json:"amount,omitempty"`
+	// MinBasket is the smallest basket subtotal, before any discounts, the
+	// promotion applies to. It must be in the same currency as Amount.
+	MinBasket *money.Amount `json:"min_basket,omitempty"`
+	// MaxUses and MaxUsesPerUser limit redemptions in total and by each
+	// user. Nil limits are unlimited.
+	MaxUses        *int       `json:"max_uses"`
+	MaxUsesPerUser *int       `json:"max_uses_per_user"`
+	Uses           int        `json:"uses"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	// Stackable promotions can be combined with each other. Promotions that
+	// are not are only ever applied alone.
+	Stackable bool `json:"stackable"`
+	// Priority orders stacked promotions, lowest first. Each applies to what
+	// the ones before it left.
+	Priority int  `json:"priority"`
+	Active   bool `json:"active"`
+	// ProductIDs and CategoryIDs narrow the promotion to those products and
+	// to products in those categories or their subcategories. With neither,
+	// it applies to every item.
+	ProductIDs  []uuid.UUID `json:"product_ids"`
+	CategoryIDs []uuid.UUID `json:"category_ids"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// currency is the currency the promotion's amounts are in, if it has any.
+func (p *Promotion) currency() money.Currency {
+	switch {
+	case p.Amount != nil:
+		return p.Amount.Currency()
+	case p.MinBasket != nil:
+		return p.MinBasket.Currency()
+	default:
+		return ""
+	}
+}
+
+// percent returns the share percentage promotions take off, e.g. 1/8 for
+// "12.5".
+func (p *Promotion) percent() (*big.Rat, error) {
+	r, err := money.ParseRate(p.Percent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: percent must be a positive number", ErrInvalidPromotion)
+	}
+
+	return r.Quo(r, big.NewRat(100, 1)), nil
+}
+
+// validate checks that the promotion's fields fit its kind and each other.
+func (p *Promotion) validate() error {
+	switch p.Kind {
+	case PromotionPercentage:
+		if p.Amount != nil {
+			return fmt.Errorf("%w: percentage promotions take a percent, not an amount", ErrInvalidPromotion)
+		}
+
+		share, err := p.percent()
+		if err != nil {
+			return err
+		}
+
+		hundredths := new(big.Rat).Mul(share, big.NewRat(10000, 1))
+		if share.Cmp(big.NewRat(1, 1)) > 0 || !hundredths.IsInt() {
+			return fmt.Errorf("%w: percent must be at most 100, with up to 2 decimal places", ErrInvalidPromotion)
+		}
+	case PromotionFixed:
+		if p.Percent != "" || p.Amount == nil {
+			return fmt.Errorf("%w: fixed promotions take an amount, not a percent", ErrInvalidPromotion)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidPromotion, p.Kind)
+	}
+
+	if p.Amount != nil && p.MinBasket != nil && p.Amount.Currency() != p.MinBasket.Currency() {
+		return ErrMixedCurrencies
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+
+	return nil
+}
+
+// BasketItem is an item of a basket to price. Without a variant, the
+// product's lowest price is used.
+type BasketItem struct {
+	ProductID uuid.UUID
+	VariantID *uuid.UUID
+	Quantity  int
+}
+
+// BasketLine is a priced basket item with the discount promotions take off
+// it.
+type BasketLine struct {
+	ProductID uuid.UUID    `json:"product_id"`
+	VariantID *uuid.UUID   `json:"variant_id,omitempty"`
+	Title     string       `json:"title"`
+	Quantity  int          `json:"quantity"`
+	UnitPrice money.Amount `json:"unit_price"`
+	Subtotal  money.Amount `json:"subtotal"`
+	Discount  money.Amount `json:"discount"`
+	Total     money.Amount `json:"total"`
+	// categories holds the line's categories and all their ancestors.
+	categories []uuid.UUID
+}
+
+// AppliedPromotion is a promotion taken off a basket.
+type AppliedPromotion struct {
+	PromotionID uuid.UUID    `json:"promotion_id"`
+	Code        string       `json:"code"`
+	Discount    money.Amount `json:"discount"`
+	// Lines is the discount on each basket line, in basket order.
+	Lines []money.Amount `json:"lines"`
+	// RedemptionID is set once the promotion has been redeemed.
+	RedemptionID *uuid.UUID `json:"redemption_id,omitempty"`
+}
+
+type RejectedPromotion struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// PromotionEvaluation is a basket priced with the promotions whose codes were
+// given.
+type PromotionEvaluation struct {
+	Lines    []BasketLine        `json:"lines"`
+	Subtotal money.Amount        `json:"subtotal"`
+	Discount money.Amount        `json:"discount"`
+	Total    money.Amount        `json:"total"`
+	Applied  []AppliedPromotion  `json:"applied"`
+	Rejected []RejectedPromotion `json:"rejected"`
+}
+
+// promotionUse is a promotion being evaluated, with how often it has been
+// redeemed by the user.
+type promotionUse struct {
+	*Promotion
+	userUses int
+}
+
+// evaluatePromotions prices lines with the promotions for codes, in the
+// order the codes were given. Codes without a promotion are rejected.
+//
+// Promotions that cannot apply to the basket are rejected with the reason.
+// Of the rest, either all the stackable ones are applied together or the
+// single non-stackable one that takes off the most, whichever saves more.
+// Percentage discounts are rounded down, so no item is ever discounted by
+// more than the percentage.
+func evaluatePromotions(lines []BasketLine, codes []string, promotions map[string]promotionUse, now time.Time) (*PromotionEvaluation, error) {
+	currency := lines[0].UnitPrice.Currency()
+	eval := &PromotionEvaluation{
+		Lines:    lines,
+		Subtotal: money.Zero(currency),
+		Applied:  []AppliedPromotion{},
+		Rejected: []RejectedPromotion{},
+	}
+
+	for i := range eval.Lines {
+		line := &eval.Lines[i]
+		if line.UnitPrice.Currency() != currency {
+			return nil, ErrMixedCurrencies
+		}
+
+		var err error
+		if line.Subtotal, err = line.UnitPrice.Mul(int64(line.Quantity)); err != nil {
+			return nil, err
+		}
+		if eval.Subtotal, err = eval.Subtotal.Add(line.Subtotal); err != nil {
+			return nil, err
+		}
+	}
+
+	var stackable, exclusive []promotionUse
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if seen[code] {
+			eval.Rejected = append(eval.Rejected, RejectedPromotion{Code: code, Reason: RejectDuplicateInCodes})
+			continue
+		}
+		seen[code] = true
+
+		p, ok := promotions[code]
+		if !ok {
+			eval.Rejected = append(eval.Rejected, RejectedPromotion{Code: code, Reason: RejectUnknownCode})
+			continue
+		}
+
+		if reason := p.rejection(eval, now); reason != "" {
+			eval.Rejected = append(eval.Rejected, RejectedPromotion{Code: code, Reason: reason})
+			continue
+		}
+
+		if p.Stackable {
+			stackable = append(stackable, p)
+		} else {
+			exclusive = append(exclusive, p)
+		}
+	}
+
+	// Try every allowed combination and keep the one that saves the most,
+	// preferring the stackable promotions on a tie.
+	options := [][]promotionUse{stackable}
+	for _, p := range exclusive {
+		options = append(options, []promotionUse{p})
+	}
+
+	var (
+		best     []AppliedPromotion
+		bestSave = money.Zero(currency)
+		bestIdx  = -1
+	)
+	for i, option := range options {
+		if len(option) == 0 {
+			continue
+		}
+
+		applied, saved, err := applyPromotions(eval.Lines, option)
+		if err != nil {
+			return nil, err
+		}
+
+		if c, err := saved.Cmp(bestSave); err != nil {
+			return nil, err
+		} else if bestIdx < 0 || c > 0 {
+			best, bestSave, bestIdx = applied, saved, i
+		}
+	}
+
+	for i, option := range options {
+		if i == bestIdx {
+			continue
+		}
+		for _, p := range option {
+			eval.Rejected = append(eval.Rejected, RejectedPromotion{Code: p.Code, Reason: RejectNotStackable})
+		}
+	}
+
+	eval.Discount = bestSave
+	for i := range eval.Lines {
+		line := &eval.Lines[i]
+		line.Discount = money.Zero(currency)
+		for _, a := range best {
+			var err error
+			if line.Discount, err = line.Discount.Add(a.Lines[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if line.Total, err = line.Subtotal.Sub(line.Discount); err != nil {
+			return nil, err
+		}
+	}
+	if best != nil {
+		eval.Applied = best
+	}
+
+	var err error
+	if eval.Total, err = eval.Subtotal.Sub(eval.Discount); err != nil {
+		return nil, err
+	}
+
+	return eval, nil
+}
+
+// rejection returns why the promotion cannot apply to the basket, or "" if
+// it can.
+func (p promotionUse) rejection(eval *PromotionEvaluation, now time.Time) string {
+	switch {
+	case !p.Active:
+		return RejectInactive
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return RejectNotStarted
+	case p.EndsAt != nil && !now.Before(*p.EndsAt):
+		return RejectEnded
+	case p.MaxUses != nil && p.Uses >= *p.MaxUses:
+		return RejectUsedUp
+	case p.MaxUsesPerUser != nil && p.userUses >= *p.MaxUsesPerUser:
+		return RejectUsedUpByUser
+	}
+
+	if c := p.currency(); c != "" && c != eval.Subtotal.Currency() {
+		return RejectCurrency
+	}
+
+	if p.MinBasket != nil {
+		if c, err := eval.Subtotal.Cmp(*p.MinBasket); err != nil || c < 0 {
+			return RejectMinBasket
+		}
+	}
+
+	if !slices.ContainsFunc(eval.Lines, p.covers) {
+		return RejectNoEligibleItems
+	}
+
+	return ""
+}
+
+// covers reports whether the promotion applies to a basket line.
+func (p *Promotion) covers(line BasketLine) bool {
+	if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 {
+		return true
+	}
+
+	if slices.Contains(p.ProductIDs, line.ProductID) {
+		return true
+	}
+
+	return slices.ContainsFunc(p.CategoryIDs, func(id uuid.UUID) bool {
+		return slices.Contains(line.categories, id)
+	})
+}
+
+// applyPromotions takes promotions off lines in priority order, each from
+// what the earlier ones left, and returns what each took off and the total.
+func applyPromotions(lines []BasketLine, promotions []promotionUse) ([]AppliedPromotion, money.Amount, error) {
+	currency := lines[0].Subtotal.Currency()
+
+	ordered := slices.Clone(promotions)
+	slices.SortStableFunc(ordered, func(a, b promotionUse) int {
+		if c := cmp.Compare(a.Priority, b.Priority); c != 0 {
+			return c
+		}
+		// Percentages first, so they are not taken off fixed discounts.
+		return cmp.Compare(kindOrder(a.Kind), kindOrder(b.Kind))
+	})
+
+	remaining := make([]money.Amount, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.Subtotal
+	}
+
+	var (
+		applied = make([]AppliedPromotion, 0, len(ordered))
+		saved   = money.Zero(currency)
+	)
+	for _, p := range ordered {
+		var (
+			discounts []money.Amount
+			err       error
+		)
+		switch p.Kind {
+		case PromotionPercentage:
+			discounts, err = percentageDiscounts(p.Promotion, lines, remaining)
+		case PromotionFixed:
+			discounts, err = fixedDiscounts(p.Promotion, lines, remaining)
+		default:
+			err = fmt.Errorf("%w: unknown kind %q", ErrInvalidPromotion, p.Kind)
+		}
+		if err != nil {
+			return nil, money.Amount{}, err
+		}
+
+		a := AppliedPromotion{PromotionID: p.ID, Code: p.Code, Discount: money.Zero(currency), Lines: discounts}
+		for i, d := range discounts {
+			if remaining[i], err = remaining[i].Sub(d); err != nil {
+				return nil, money.Amount{}, err
+			}
+			if a.Discount, err = a.Discount.Add(d); err != nil {
+				return nil, money.Amount{}, err
+			}
+		}
+
+		if saved, err = saved.Add(a.Discount); err != nil {
+			return nil, money.Amount{}, err
+		}
+		applied = append(applied, a)
+	}
+
+	return applied, saved, nil
+}
+
+func kindOrder(k PromotionKind) int {
+	if k == PromotionPercentage {
+		return 0
+	}
+	return 1
+}
+
+// percentageDiscounts takes the promotion's percentage off what remains of
+// each line it covers.
+func percentageDiscounts(p *Promotion, lines []BasketLine, remaining []money.Amount) ([]money.Amount, error) {
+	share, err := p.percent()
+	if err != nil {
+		return nil, err
+	}
+
+	discounts := make([]money.Amount, len(lines))
+	for i, line := range lines {
+		discounts[i] = money.Zero(remaining[i].Currency())
+		if p.covers(line) {
+			discounts[i] = remaining[i].MulRat(share, money.RoundDown)
+		}
+	}
+
+	return discounts, nil
+}
+
+// fixedDiscounts shares the promotion's amount, capped at what remains of
+// the lines it covers, between those lines in proportion to what remains of
+// them. Minor units left over from rounding go to the first lines with room
+// for them.
+func fixedDiscounts(p *Promotion, lines []BasketLine, remaining []money.Amount) ([]money.Amount, error) {
+	currency := remaining[0].Currency()
+	discounts := make([]money.Amount, len(lines))
+
+	var covered int64
+	for i, line := range lines {
+		discounts[i] = money.Zero(currency)
+		if p.covers(line) {
+			covered += remaining[i].Minor()
+		}
+	}
+
+	total := min(p.Amount.Minor(), covered)
+	if total == 0 {
+		return discounts, nil
+	}
+
+	left := total
+	for i, line := range lines {
+		if !p.covers(line) {
+			continue
+		}
+
+		discounts[i] = remaining[i].MulRat(big.NewRat(total, covered), money.RoundDown)
+		left -= discounts[i].Minor()
+	}
+
+	for i := 0; left > 0 && i < len(lines); i++ {
+		if p.covers(lines[i]) && discounts[i].Minor() < remaining[i].Minor() {
+			discounts[i] = money.New(discounts[i].Minor()+1, currency)
+			left--
+		}
+	}
+
+	return discounts, nil
+}
@@ -0,0 +1,605 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"time"
+)
+
+var (
+	ErrDuplicatePromotionCode = errors.New("a promotion with that code already exists")
+	ErrDuplicateRedemption    = errors.New("the promotion has already been redeemed on this order")
+	ErrPromotionNotApplicable = errors.New("none of the promotions apply to the basket")
+)
+
+type PromotionStore struct {
+	db querier
+}
+
+func (s *PromotionStore) PromotionCreate(ctx context.Context, promotion *Promotion) error {
+	if err := promotion.validate(); err != nil {
+		return err
+	}
+	utcWindow(promotion)
+
+	return withTx(s.db, ctx, func(q querier) error {
+		query := `INSERT INTO promotions (code, description, kind, percent, amount, min_basket, currency, max_uses,
+				max_uses_per_user, starts_at, ends_at, stackable, priority, active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id, created_at, updated_at`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := q.QueryRowContext(qctx, query,
+			promotion.Code,
+			promotion.Description,
+			promotion.Kind,
+			nullString(promotion.Percent),
+			promotion.Amount,
+			promotion.MinBasket,
+			nullString(string(promotion.currency())),
+			promotion.MaxUses,
+			promotion.MaxUsesPerUser,
+			promotion.StartsAt,
+			promotion.EndsAt,
+			promotion.Stackable,
+			promotion.Priority,
+			promotion.Active).Scan(&promotion.ID, &promotion.CreatedAt, &promotion.UpdatedAt)
+		if err != nil {
+			return uniqueViolation(err, map[string]error{
+				"promotions_code_key": ErrDuplicatePromotionCode,
+			})
+		}
+
+		return setPromotionScope(ctx, q, promotion)
+	})
+}
+
+func (s *PromotionStore) PromotionGetByID(ctx context.Context, id uuid.UUID) (*Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM promotions p WHERE p.id = $1`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	promotion, err := scanPromotion(s.db.QueryRowContext(qctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := getPromotionScopes(ctx, s.db, []*Promotion{promotion}); err != nil {
+		return nil, err
+	}
+
+	return promotion, nil
+}
+
+// PromotionGetAll returns every promotion, newest first.
+func (s *PromotionStore) PromotionGetAll(ctx context.Context) ([]*Promotion, error) {
+	query := `SELECT ` + promotionColumns + ` FROM promotions p ORDER BY p.created_at DESC, p.id`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(qctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	promotions := []*Promotion{}
+	for rows.Next() {
+		promotion, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, promotion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := getPromotionScopes(ctx, s.db, promotions); err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// PromotionUpdate saves a promotion's terms and scope. Past redemptions keep
+// the discount they were given.
+func (s *PromotionStore) PromotionUpdate(ctx context.Context, promotion *Promotion) error {
+	if err := promotion.validate(); err != nil {
+		return err
+	}
+	utcWindow(promotion)
+
+	return withTx(s.db, ctx, func(q querier) error {
+		query := `UPDATE promotions SET code = $1, description = $2, kind = $3, percent = $4, amount = $5,
+				min_basket = $6, currency = $7, max_uses = $8, max_uses_per_user = $9, starts_at = $10, ends_at = $11,
+				stackable = $12, priority = $13, active = $14, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $15 RETURNING updated_at`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := q.QueryRowContext(qctx, query,
+			promotion.Code,
+			promotion.Description,
+			promotion.Kind,
+			nullString(promotion.Percent),
+			promotion.Amount,
+			promotion.MinBasket,
+			nullString(string(promotion.currency())),
+			promotion.MaxUses,
+			promotion.MaxUsesPerUser,
+			promotion.StartsAt,
+			promotion.EndsAt,
+			promotion.Stackable,
+			promotion.Priority,
+			promotion.Active,
+			promotion.ID).Scan(&promotion.UpdatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return uniqueViolation(err, map[string]error{
+					"promotions_code_key": ErrDuplicatePromotionCode,
+				})
+			}
+		}
+
+		return setPromotionScope(ctx, q, promotion)
+	})
+}
+
+// PromotionDelete deletes a promotion along with its redemption history.
+// Deactivate promotions instead to keep the history.
+func (s *PromotionStore) PromotionDelete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM promotions WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PromotionEvaluate prices a basket for a user with the promotions whose
+// codes are given, without redeeming them.
+func (s *PromotionStore) PromotionEvaluate(ctx context.Context, userID uuid.UUID, codes []string, items []BasketItem) (*PromotionEvaluation, error) {
+	var eval *PromotionEvaluation
+
+	// A transaction gives a consistent view of the basket and the promotions.
+	err := withTx(s.db, ctx, func(q querier) error {
+		var err error
+		eval, err = evaluateBasket(ctx, q, userID, codes, items, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return eval, nil
+}
+
+// PromotionRedeem prices a basket like PromotionEvaluate and records a use of
+// each promotion applied, optionally against one of the user's orders. The
+// promotions are locked while their usage limits are checked, so concurrent
+// redemptions can never take a promotion past its limits. It fails with
+// ErrPromotionNotApplicable if no promotion applies.
+func (s *PromotionStore) PromotionRedeem(ctx context.Context, userID uuid.UUID, codes []string, items []BasketItem, orderID *uuid.UUID) (*PromotionEvaluation, error) {
+	var eval *PromotionEvaluation
+
+	err := withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if orderID != nil {
+			var owner uuid.UUID
+			err := q.QueryRowContext(qctx, `SELECT user_id FROM orders WHERE id = $1`, *orderID).Scan(&owner)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			// Other users' orders are reported as missing.
+			if err != nil || owner != userID {
+				return fmt.Errorf("order %s: %w", *orderID, ErrNotFound)
+			}
+		}
+
+		var err error
+		if eval, err = evaluateBasket(ctx, q, userID, codes, items, true); err != nil {
+			return err
+		}
+
+		if len(eval.Applied) == 0 {
+			return ErrPromotionNotApplicable
+		}
+
+		query := `INSERT INTO promotion_redemptions (promotion_id, user_id, order_id, discount, currency)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+		for i := range eval.Applied {
+			applied := &eval.Applied[i]
+
+			var id uuid.UUID
+			err := q.QueryRowContext(qctx, query,
+				applied.PromotionID,
+				userID,
+				orderID,
+				applied.Discount,
+				applied.Discount.Currency()).Scan(&id)
+			if err != nil {
+				return uniqueViolation(err, map[string]error{
+					"promotion_redemptions_promotion_id_order_id_key": ErrDuplicateRedemption,
+				})
+			}
+			applied.RedemptionID = &id
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return eval, nil
+}
+
+// evaluateBasket prices items and evaluates the promotions for codes against
+// them. With lock set, the promotions are locked until the transaction ends.
+func evaluateBasket(ctx context.Context, q querier, userID uuid.UUID, codes []string, items []BasketItem, lock bool) (*PromotionEvaluation, error) {
+	lines, err := getBasketLines(ctx, q, items)
+	if err != nil {
+		return nil, err
+	}
+
+	promotions, err := getPromotionsByCode(ctx, q, codes, userID, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluatePromotions(lines, codes, promotions, time.Now().UTC())
+}
+
+// getBasketLines prices basket items and finds the categories, with their
+// ancestors, that each item's product is in.
+func getBasketLines(ctx context.Context, q querier, items []BasketItem) ([]BasketLine, error) {
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	lines := make([]BasketLine, len(items))
+	productIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		line := BasketLine{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+
+		var row *sql.Row
+		if item.VariantID != nil {
+			row = q.QueryRowContext(qctx, `SELECT p.title, v.price, p.currency
+				FROM product_variants v JOIN products p ON p.id = v.product_id
+				WHERE v.id = $1 AND v.product_id = $2`, *item.VariantID, item.ProductID)
+		} else {
+			row = q.QueryRowContext(qctx, `SELECT title, price, currency FROM products WHERE id = $1`, item.ProductID)
+		}
+
+		var price, currency string
+		if err := row.Scan(&line.Title, &price, &currency); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, fmt.Errorf("product %s: %w", item.ProductID, ErrNotFound)
+			default:
+				return nil, err
+			}
+		}
+
+		var err error
+		if line.UnitPrice, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
+
+		lines[i] = line
+		productIDs[i] = item.ProductID
+	}
+
+	query := `WITH RECURSIVE ancestors AS (
+			SELECT pc.product_id, c.id, c.parent_id
+			FROM product_categories pc JOIN categories c ON c.id = pc.category_id
+			WHERE pc.product_id = ANY($1)
+			UNION
+			SELECT a.product_id, c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+		) SELECT product_id, id FROM ancestors`
+
+	rows, err := q.QueryContext(qctx, query, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	categories := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var productID, categoryID uuid.UUID
+		if err := rows.Scan(&productID, &categoryID); err != nil {
+			return nil, err
+		}
+		categories[productID] = append(categories[productID], categoryID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range lines {
+		lines[i].categories = categories[lines[i].ProductID]
+	}
+
+	return lines, nil
+}
+
+// getPromotionsByCode returns the promotions for codes, keyed by code, with
+// how often the user has redeemed each.
+func getPromotionsByCode(ctx context.Context, q querier, codes []string, userID uuid.UUID, lock bool) (map[string]promotionUse, error) {
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if lock {
+		// Concurrent redemptions of the same promotion take turns on its
+		// lock. Locking in ID order means two redemptions sharing several
+		// promotions cannot each hold one the other waits for, so they never
+		// deadlock. The uses are counted by the next statement rather than
+		// this one: under read committed isolation a statement that waited
+		// for a lock still counts from the snapshot taken before the wait,
+		// and would miss the redemptions of the transaction it waited for.
+		_, err := q.ExecContext(qctx, `SELECT id FROM promotions WHERE code = ANY($1) ORDER BY id FOR UPDATE`,
+			pq.Array(codes))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `SELECT ` + promotionColumns + `,
+			(SELECT COUNT(*) FROM promotion_redemptions r WHERE r.promotion_id = p.id AND r.user_id = $2)
+		FROM promotions p WHERE p.code = ANY($1) ORDER BY p.id`
+
+	rows, err := q.QueryContext(qctx, query, pq.Array(codes), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var (
+		found      []*Promotion
+		promotions = make(map[string]promotionUse, len(codes))
+	)
+	for rows.Next() {
+		var userUses int
+		promotion, err := scanPromotion(rows, &userUses)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, promotion)
+		promotions[promotion.Code] = promotionUse{Promotion: promotion, userUses: userUses}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := getPromotionScopes(ctx, q, found); err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// getPromotionScopes fills in the products and categories of promotions.
+func getPromotionScopes(ctx context.Context, q querier, promotions []*Promotion) error {
+	if len(promotions) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*Promotion, len(promotions))
+	ids := make([]uuid.UUID, len(promotions))
+	for i, p := range promotions {
+		p.ProductIDs, p.CategoryIDs = []uuid.UUID{}, []uuid.UUID{}
+		byID[p.ID] = p
+		ids[i] = p.ID
+	}
+
+	query := `SELECT promotion_id, product_id, 'product' FROM promotion_products WHERE promotion_id = ANY($1)
+		UNION ALL
+		SELECT promotion_id, category_id, 'category' FROM promotion_categories WHERE promotion_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	for rows.Next() {
+		var (
+			promotionID, id uuid.UUID
+			kind            string
+		)
+		if err := rows.Scan(&promotionID, &id, &kind); err != nil {
+			return err
+		}
+
+		p := byID[promotionID]
+		if kind == "product" {
+			p.ProductIDs = append(p.ProductIDs, id)
+		} else {
+			p.CategoryIDs = append(p.CategoryIDs, id)
+		}
+	}
+
+	return rows.Err()
+}
+
+// setPromotionScope replaces the products and categories a promotion
+// applies to.
+func setPromotionScope(ctx context.Context, q querier, promotion *Promotion) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if promotion.ProductIDs == nil {
+		promotion.ProductIDs = []uuid.UUID{}
+	}
+	if promotion.CategoryIDs == nil {
+		promotion.CategoryIDs = []uuid.UUID{}
+	}
+
+	scopes := []struct {
+		table, column string
+		ids           []uuid.UUID
+	}{
+		{"promotion_products", "product_id", promotion.ProductIDs},
+		{"promotion_categories", "category_id", promotion.CategoryIDs},
+	}
+
+	for _, scope := range scopes {
+		if _, err := q.ExecContext(ctx, `DELETE FROM `+scope.table+` WHERE promotion_id = $1`, promotion.ID); err != nil {
+			return err
+		}
+
+		if len(scope.ids) == 0 {
+			continue
+		}
+
+		query := `INSERT INTO ` + scope.table + ` (promotion_id, ` + scope.column + `)
+			SELECT $1, unnest($2::uuid[]) ON CONFLICT DO NOTHING`
+		if _, err := q.ExecContext(ctx, query, promotion.ID, pq.Array(scope.ids)); err != nil {
+			return foreignKeyViolation(err)
+		}
+	}
+
+	return nil
+}
+
+// utcWindow stores a promotion's validity window in UTC, the time zone it is
+// compared in.
+func utcWindow(promotion *Promotion) {
+	if promotion.StartsAt != nil {
+		t := promotion.StartsAt.UTC()
+		promotion.StartsAt = &t
+	}
+	if promotion.EndsAt != nil {
+		t := promotion.EndsAt.UTC()
+		promotion.EndsAt = &t
+	}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// promotionColumns selects a promotion, with its number of uses, in the
+// order scanPromotion reads it.
+const promotionColumns = `p.id, p.code, p.description, p.kind, p.percent, p.amount, p.min_basket, p.currency,
+	p.max_uses, p.max_uses_per_user, p.starts_at, p.ends_at, p.stackable, p.priority, p.active, p.created_at,
+	p.updated_at, (SELECT COUNT(*) FROM promotion_redemptions r WHERE r.promotion_id = p.id)`
+
+// scanPromotion reads promotionColumns, followed by any extra columns into
+// extra.
+func scanPromotion(row interface{ Scan(...any) error }, extra ...any) (*Promotion, error) {
+	var (
+		p                        Promotion
+		percent, amount, minimum sql.NullString
+		currency                 sql.NullString
+	)
+	dest := append([]any{
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.Kind,
+		&percent,
+		&amount,
+		&minimum,
+		&currency,
+		&p.MaxUses,
+		&p.MaxUsesPerUser,
+		&p.StartsAt,
+		&p.EndsAt,
+		&p.Stackable,
+		&p.Priority,
+		&p.Active,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.Uses,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if percent.Valid {
+		r, err := money.ParseRate(percent.String)
+		if err != nil {
+			return nil, err
+		}
+		p.Percent = money.FormatRate(r)
+	}
+
+	for _, a := range []struct {
+		src sql.NullString
+		dst **money.Amount
+	}{{amount, &p.Amount}, {minimum, &p.MinBasket}} {
+		if !a.src.Valid {
+			continue
+		}
+
+		parsed, err := parseAmount(a.src.String, currency.String)
+		if err != nil {
+			return nil, err
+		}
+		*a.dst = &parsed
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+)
+
+func testLines(prices ...string) []BasketLine {
+	lines := make([]BasketLine, len(prices))
+	for i, price := range prices {
+		lines[i] = BasketLine{ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse(price, money.GBP)}
+	}
+	return lines
+}
+
+func percentagePromotion(code, percent string, stackable bool, priority int) promotionUse {
+	return promotionUse{Promotion: &Promotion{
+		ID: uuid.New(), Code: code, Kind: PromotionPercentage, Percent: percent,
+		Stackable: stackable, Priority: priority, Active: true,
+	}}
+}
+
+func fixedPromotion(code, amount string, stackable bool, priority int) promotionUse {
+	a := money.MustParse(amount, money.GBP)
+	return promotionUse{Promotion: &Promotion{
+		ID: uuid.New(), Code: code, Kind: PromotionFixed, Amount: &a,
+		Stackable: stackable, Priority: priority, Active: true,
+	}}
+}
+
+func TestFixedDiscounts(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining []string
+		amount    string
+		// covered lists the lines the promotion is narrowed to, or all of
+		// them if empty.
+		covered []int
+		want    []string
+	}{
+		{"shared by value", []string{"10.00", "30.00"}, "4.00", nil, []string{"1.00", "3.00"}},
+		{"remainder to the first lines", []string{"10.00", "10.00", "10.00"}, "10.00", nil, []string{"3.34", "3.33", "3.33"}},
+		{"remainder of two", []string{"0.01", "0.01", "0.05"}, "0.06", nil, []string{"0.01", "0.01", "0.04"}},
+		{"remainder skips lines with nothing left", []string{"0.00", "0.01", "0.02"}, "0.02", nil, []string{"0.00", "0.01", "0.01"}},
+		{"capped at what remains", []string{"2.00", "3.00"}, "10.00", nil, []string{"2.00", "3.00"}},
+		{"only covered lines", []string{"10.00", "50.00", "10.00"}, "5.00", []int{0, 2}, []string{"2.50", "0.00", "2.50"}},
+		{"remainder only to covered lines", []string{"10.00", "10.00", "10.00", "10.00"}, "0.05", []int{1, 2, 3}, []string{"0.00", "0.02", "0.02", "0.01"}},
+		{"nothing left", []string{"0.00", "0.00"}, "5.00", nil, []string{"0.00", "0.00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := testLines(tt.remaining...)
+			remaining := make([]money.Amount, len(lines))
+			for i, line := range lines {
+				remaining[i] = line.UnitPrice
+			}
+
+			p := fixedPromotion("FIXED", tt.amount, false, 0).Promotion
+			for _, i := range tt.covered {
+				p.ProductIDs = append(p.ProductIDs, lines[i].ProductID)
+			}
+
+			discounts, err := fixedDiscounts(p, lines, remaining)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var total int64
+			for i, d := range discounts {
+				if d.String() != tt.want[i] {
+					t.Errorf("line %d: got %s, want %s", i, d, tt.want[i])
+				}
+				total += d.Minor()
+			}
+
+			var covered int64
+			for i, r := range remaining {
+				if len(tt.covered) == 0 || slices.Contains(tt.covered, i) {
+					covered += r.Minor()
+				}
+			}
+			if want := min(p.Amount.Minor(), covered); total != want {
+				t.Errorf("discounts add up to %d, want %d", total, want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePromotionsStacking(t *testing.T) {
+	// The basket is 20.00 + 30.00. Together, 10% off then 5.00 off save
+	// 2.00 + 3.00 and then 2.00 + 3.00 of the 18.00 + 27.00 left, 10.00 in
+	// all.
+	stackable := func() []promotionUse {
+		return []promotionUse{
+			fixedPromotion("FIVE", "5.00", true, 0),
+			percentagePromotion("TENPC", "10", true, 0),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		promotions []promotionUse
+		codes      []string
+		applied    []string
+		rejected   []string
+		discount   string
+	}{
+		{
+			name:       "percentages before fixed amounts of the same priority",
+			promotions: stackable(),
+			codes:      []string{"FIVE", "TENPC"},
+			applied:    []string{"TENPC", "FIVE"},
+			discount:   "10.00",
+		},
+		{
+			name: "lower priorities first",
+			promotions: []promotionUse{
+				fixedPromotion("FIVE", "5.00", true, 0),
+				percentagePromotion("TENPC", "10", true, 1),
+			},
+			codes:    []string{"TENPC", "FIVE"},
+			applied:  []string{"FIVE", "TENPC"},
+			discount: "9.50",
+		},
+		{
+			name:       "an exclusive promotion saving more",
+			promotions: append(stackable(), fixedPromotion("TWELVE", "12.00", false, 0)),
+			codes:      []string{"FIVE", "TENPC", "TWELVE"},
+			applied:    []string{"TWELVE"},
+			rejected:   []string{"FIVE", "TENPC"},
+			discount:   "12.00",
+		},
+		{
+			name:       "stacked promotions saving more",
+			promotions: append(stackable(), fixedPromotion("EIGHT", "8.00", false, 0)),
+			codes:      []string{"EIGHT", "FIVE", "TENPC"},
+			applied:    []string{"TENPC", "FIVE"},
+			rejected:   []string{"EIGHT"},
+			discount:   "10.00",
+		},
+		{
+			name:       "stacked promotions win a tie",
+			promotions: append(stackable(), fixedPromotion("TEN", "10.00", false, 0)),
+			codes:      []string{"TEN", "FIVE", "TENPC"},
+			applied:    []string{"TENPC", "FIVE"},
+			rejected:   []string{"TEN"},
+			discount:   "10.00",
+		},
+		{
+			name: "the first of tied exclusive promotions",
+			promotions: []promotionUse{
+				fixedPromotion("TEN", "10.00", false, 0),
+				percentagePromotion("TWENTYPC", "20", false, 0),
+			},
+			codes:    []string{"TWENTYPC", "TEN"},
+			applied:  []string{"TWENTYPC"},
+			rejected: []string{"TEN"},
+			discount: "10.00",
+		},
+		{
+			name: "the best of exclusive promotions",
+			promotions: []promotionUse{
+				fixedPromotion("TEN", "10.00", false, 0),
+				percentagePromotion("TWENTYFIVEPC", "25", false, 0),
+			},
+			codes:    []string{"TEN", "TWENTYFIVEPC"},
+			applied:  []string{"TWENTYFIVEPC"},
+			rejected: []string{"TEN"},
+			discount: "12.50",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promotions := make(map[string]promotionUse, len(tt.promotions))
+			for _, p := range tt.promotions {
+				promotions[p.Code] = p
+			}
+
+			eval, err := evaluatePromotions(testLines("20.00", "30.00"), tt.codes, promotions, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var applied, rejected []string
+			for _, a := range eval.Applied {
+				applied = append(applied, a.Code)
+			}
+			for _, r := range eval.Rejected {
+				if r.Reason != RejectNotStackable {
+					t.Errorf("%s rejected because %s, want %s", r.Code, r.Reason, RejectNotStackable)
+				}
+				rejected = append(rejected, r.Code)
+			}
+
+			if !slices.Equal(applied, tt.applied) {
+				t.Errorf("got applied %v, want %v", applied, tt.applied)
+			}
+			if !slices.Equal(rejected, tt.rejected) {
+				t.Errorf("got rejected %v, want %v", rejected, tt.rejected)
+			}
+			if eval.Discount.String() != tt.discount {
+				t.Errorf("got discount %s, want %s", eval.Discount, tt.discount)
+			}
+			if total := money.MustParse("50.00", money.GBP); eval.Total.Minor() != total.Minor()-eval.Discount.Minor() {
+				t.Errorf("got total %s with discount %s of %s", eval.Total, eval.Discount, total)
+			}
+
+			var lines int64
+			for _, line := range eval.Lines {
+				lines += line.Discount.Minor()
+			}
+			if lines != eval.Discount.Minor() {
+				t.Errorf("line discounts add up to %d, want %d", lines, eval.Discount.Minor())
+			}
+		})
+	}
+}
+
+func TestEvaluatePromotionsRejections(t *testing.T) {
+	now := time.Now()
+	earlier, later := now.Add(-time.Hour), now.Add(time.Hour)
+	one := 1
+
+	tests := []struct {
+		name   string
+		change func(p *promotionUse)
+		reason string
+	}{
+		{"inactive", func(p *promotionUse) { p.Active = false }, RejectInactive},
+		{"not started", func(p *promotionUse) { p.StartsAt = &later }, RejectNotStarted},
+		{"ended", func(p *promotionUse) { p.EndsAt = &earlier }, RejectEnded},
+		{"ends now", func(p *promotionUse) { p.EndsAt = &now }, RejectEnded},
+		{"used up", func(p *promotionUse) { p.MaxUses, p.Uses = &one, 1 }, RejectUsedUp},
+		{"used up by the user", func(p *promotionUse) { p.MaxUsesPerUser, p.userUses = &one, 1 }, RejectUsedUpByUser},
+		{"another currency", func(p *promotionUse) {
+			a := money.MustParse("5.00", money.EUR)
+			p.Amount = &a
+		}, RejectCurrency},
+		{"below the minimum basket", func(p *promotionUse) {
+			a := money.MustParse("50.01", money.GBP)
+			p.MinBasket = &a
+		}, RejectMinBasket},
+		{"no eligible items", func(p *promotionUse) { p.ProductIDs = []uuid.UUID{uuid.New()} }, RejectNoEligibleItems},
+		{"started, not ended and at the minimum basket", func(p *promotionUse) {
+			a := money.MustParse("50.00", money.GBP)
+			p.StartsAt, p.EndsAt, p.MinBasket = &earlier, &later, &a
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := fixedPromotion("FIVE", "5.00", true, 0)
+			tt.change(&p)
+
+			eval, err := evaluatePromotions(testLines("20.00", "30.00"), []string{"FIVE"}, map[string]promotionUse{"FIVE": p}, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.reason == "" {
+				if len(eval.Applied) != 1 || len(eval.Rejected) != 0 {
+					t.Fatalf("got applied %v and rejected %v, want it applied", eval.Applied, eval.Rejected)
+				}
+				return
+			}
+
+			if len(eval.Applied) != 0 || len(eval.Rejected) != 1 || eval.Rejected[0].Reason != tt.reason {
+				t.Fatalf("got applied %v and rejected %v, want it rejected because %s", eval.Applied, eval.Rejected, tt.reason)
+			}
+			if !eval.Discount.IsZero() || eval.Total.String() != "50.00" {
+				t.Errorf("got discount %s and total %s, want none off 50.00", eval.Discount, eval.Total)
+			}
+		})
+	}
+}
+
+func TestEvaluatePromotionsCodes(t *testing.T) {
+	promotions := map[string]promotionUse{"FIVE": fixedPromotion("FIVE", "5.00", true, 0)}
+
+	eval, err := evaluatePromotions(testLines("20.00"), []string{"NOPE", "FIVE", "FIVE"}, promotions, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RejectedPromotion{
+		{Code: "NOPE", Reason: RejectUnknownCode},
+		{Code: "FIVE", Reason: RejectDuplicateInCodes},
+	}
+	if !slices.Equal(eval.Rejected, want) {
+		t.Errorf("got rejected %v, want %v", eval.Rejected, want)
+	}
+	if len(eval.Applied) != 1 || eval.Applied[0].Code != "FIVE" || eval.Total.String() != "15.00" {
+		t.Errorf("got applied %v and total %s, want FIVE applied once", eval.Applied, eval.Total)
+	}
+}
+
+func TestEvaluatePromotionsMixedCurrencies(t *testing.T) {
+	lines := testLines("20.00", "30.00")
+	lines[1].UnitPrice = money.MustParse("30.00", money.EUR)
+
+	if _, err := evaluatePromotions(lines, nil, nil, time.Now()); !errors.Is(err, ErrMixedCurrencies) {
+		t.Fatalf("got error %v, want %v", err, ErrMixedCurrencies)
+	}
+}
@@ -0,0 +1,363 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+var ErrReservationClosed = errors.New("the reservation has already been committed, released or expired")
+
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "active"
+	ReservationCommitted ReservationStatus = "committed"
+	ReservationReleased  ReservationStatus = "released"
+	ReservationExpired   ReservationStatus = "expired"
+)
+
+// StockReservation holds units of a variant for a buyer until ExpiresAt.
+// While it is active the units count against the stock available to others.
+type StockReservation struct {
+	ID        uuid.UUID         `json:"id"`
+	ProductID uuid.UUID         `json:"product_id"`
+	VariantID uuid.UUID         `json:"variant_id"`
+	UserID    uuid.UUID         `json:"user_id"`
+	Quantity  int               `json:"quantity"`
+	Status    ReservationStatus `json:"status"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// OrderID is set when the hold was used up by checking out.
+	OrderID   *uuid.UUID `json:"order_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// heldStock is a subquery summing the active, unexpired holds whose column
+// matches id, e.g. heldStock("r.variant_id", "v.id") for the variant of the
+// row being selected.
+func heldStock(column, id string) string {
+	return fmt.Sprintf(`(SELECT COALESCE(SUM(r.quantity), 0) FROM stock_reservations r
+		WHERE %s = %s AND r.status = 'active' AND r.expires_at > CURRENT_TIMESTAMP)`, column, id)
+}
+
+// heldStockByOthers is heldStock leaving out the holds of the user matching
+// userID, e.g. a query parameter, as getHeldByOthers does for checkout. A
+// buyer's own holds are stock set aside for them, not taken from them.
+func heldStockByOthers(column, id, userID string) string {
+	return fmt.Sprintf(`(SELECT COALESCE(SUM(r.quantity), 0) FROM stock_reservations r
+		WHERE %s = %s AND r.user_id <> %s AND r.status = 'active' AND r.expires_at > CURRENT_TIMESTAMP)`, column, id, userID)
+}
+
+type ReservationStore struct {
+	db querier
+}
+
+// ReservationCreate holds reservation.Quantity units of a variant for
+// reservation.UserID until ttl has passed. It fails with an
+// InsufficientStockError if the variant does not have that many units that
+// are neither sold nor held by someone else.
+func (s *ReservationStore) ReservationCreate(ctx context.Context, reservation *StockReservation, ttl time.Duration) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Locking the variant makes concurrent holds on it take turns, so
+		// they cannot both claim the last units.
+		query := `SELECT v.sku, v.stock - ` + heldStock("r.variant_id", "v.id") + `
+			FROM product_variants v WHERE v.id = $1 AND v.product_id = $2 FOR UPDATE`
+
+		var (
+			sku       string
+			available int
+		)
+		err := q.QueryRowContext(qctx, query, reservation.VariantID, reservation.ProductID).Scan(&sku, &available)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if reservation.Quantity > available {
+			return &InsufficientStockError{Shortages: []StockShortage{{
+				ProductID: reservation.ProductID,
+				VariantID: reservation.VariantID,
+				SKU:       sku,
+				Requested: reservation.Quantity,
+				Available: max(available, 0),
+			}}}
+		}
+
+		query = `INSERT INTO stock_reservations (product_id, variant_id, user_id, quantity, expires_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))
+			RETURNING id, status, expires_at, created_at, updated_at`
+
+		err = q.QueryRowContext(qctx, query,
+			reservation.ProductID,
+			reservation.VariantID,
+			reservation.UserID,
+			reservation.Quantity,
+			ttl.Seconds()).Scan(
+			&reservation.ID,
+			&reservation.Status,
+			&reservation.ExpiresAt,
+			&reservation.CreatedAt,
+			&reservation.UpdatedAt)
+		if err != nil {
+			return foreignKeyViolation(err)
+		}
+
+		return nil
+	})
+}
+
+func (s *ReservationStore) ReservationGetByID(ctx context.Context, id uuid.UUID) (*StockReservation, error) {
+	query := `SELECT ` + reservationColumns + ` FROM stock_reservations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	reservation, err := scanReservation(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return reservation, nil
+}
+
+// ReservationCommit turns an active hold into a permanent decrement of the
+// variant's stock, taken from the warehouses strategy picks and recorded in
+// the inventory ledger by actorID. It fails with ErrReservationClosed if the
+// hold has ended or expired.
+func (s *ReservationStore) ReservationCommit(ctx context.Context, reservation *StockReservation, actorID uuid.UUID, strategy AllocationStrategy) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		if _, err := lockVariantsForCheckout(ctx, q, []uuid.UUID{reservation.VariantID}); err != nil {
+			return err
+		}
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `UPDATE stock_reservations SET status = 'committed', updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND status = 'active' AND expires_at > CURRENT_TIMESTAMP
+			RETURNING ` + reservationColumns
+
+		committed, err := scanReservation(q.QueryRowContext(qctx, query, reservation.ID))
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrReservationClosed
+			default:
+				return err
+			}
+		}
+		*reservation = *committed
+
+		picks, err := allocateStock(ctx, q, reservation.VariantID, reservation.Quantity, strategy)
+		if err != nil {
+			return err
+		}
+
+		for _, pick := range picks {
+			movement := &InventoryMovement{
+				ProductID:     reservation.ProductID,
+				VariantID:     &reservation.VariantID,
+				WarehouseID:   &pick.WarehouseID,
+				Kind:          MovementReservation,
+				Quantity:      -pick.Quantity,
+				Reason:        "Reservation committed",
+				ReservationID: &reservation.ID,
+				ActorID:       &actorID,
+			}
+			if err := recordMovement(ctx, q, movement); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReservationRelease ends an active hold early, returning its units to the
+// available stock. It fails with ErrReservationClosed if the hold has already
+// ended or expired.
+func (s *ReservationStore) ReservationRelease(ctx context.Context, reservation *StockReservation) error {
+	query := `UPDATE stock_reservations SET status = 'released', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'active' AND expires_at > CURRENT_TIMESTAMP
+		RETURNING status, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, reservation.ID).Scan(&reservation.Status, &reservation.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrReservationClosed
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReservationExpire marks the active holds that have run out as expired and
+// returns how many there were. Expired holds already stop counting against
+// available stock, so this only tidies their status.
+func (s *ReservationStore) ReservationExpire(ctx context.Context) (int64, error) {
+	query := `UPDATE stock_reservations SET status = 'expired', updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'active' AND expires_at <= CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// getHeldByOthers returns the units of the given variants held by users
+// other than userID, keyed by variant ID.
+func getHeldByOthers(ctx context.Context, q querier, variantIDs []uuid.UUID, userID uuid.UUID) (map[uuid.UUID]int, error) {
+	query := `SELECT variant_id, SUM(quantity) FROM stock_reservations
+		WHERE variant_id = ANY($1) AND user_id <> $2 AND status = 'active' AND expires_at > CURRENT_TIMESTAMP
+		GROUP BY variant_id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(variantIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	held := make(map[uuid.UUID]int, len(variantIDs))
+	for rows.Next() {
+		var (
+			id       uuid.UUID
+			quantity int
+		)
+		if err := rows.Scan(&id, &quantity); err != nil {
+			return nil, err
+		}
+		held[id] = quantity
+	}
+
+	return held, rows.Err()
+}
+
+// commitHolds uses up to quantity units of a user's active holds on a variant
+// for an order, soonest to expire first. A hold larger than what is left to
+// commit is split: the part bought is committed and the rest stays active.
+func commitHolds(ctx context.Context, q querier, orderID, userID, variantID uuid.UUID, quantity int) error {
+	query := `SELECT id, quantity FROM stock_reservations
+		WHERE user_id = $1 AND variant_id = $2 AND status = 'active' AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY expires_at, id FOR UPDATE`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(qctx, query, userID, variantID)
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	type hold struct {
+		id       uuid.UUID
+		quantity int
+	}
+
+	var holds []hold
+	for rows.Next() {
+		var h hold
+		if err := rows.Scan(&h.id, &h.quantity); err != nil {
+			return err
+		}
+		holds = append(holds, h)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	commit := `UPDATE stock_reservations SET status = 'committed', order_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+
+	// The remainder keeps the original hold's ID, so the user can still
+	// release it.
+	split := `WITH kept AS (
+			UPDATE stock_reservations SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $2 RETURNING product_id, variant_id, user_id, expires_at)
+		INSERT INTO stock_reservations (product_id, variant_id, user_id, quantity, status, expires_at, order_id)
+		SELECT product_id, variant_id, user_id, $1, 'committed', expires_at, $3 FROM kept`
+
+	for _, h := range holds {
+		if quantity == 0 {
+			break
+		}
+
+		if h.quantity <= quantity {
+			_, err = q.ExecContext(qctx, commit, orderID, h.id)
+			quantity -= h.quantity
+		} else {
+			_, err = q.ExecContext(qctx, split, quantity, h.id, orderID)
+			quantity = 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reservationColumns selects a reservation in the order scanReservation
+// reads it.
+const reservationColumns = `id, product_id, variant_id, user_id, quantity, status, expires_at, order_id, created_at, updated_at`
+
+func scanReservation(row interface{ Scan(...any) error }) (*StockReservation, error) {
+	var r StockReservation
+	err := row.Scan(
+		&r.ID,
+		&r.ProductID,
+		&r.VariantID,
+		&r.UserID,
+		&r.Quantity,
+		&r.Status,
+		&r.ExpiresAt,
+		&r.OrderID,
+		&r.CreatedAt,
+		&r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOrderCheckoutCommitsOnlyWhatIsBought(t *testing.T) {
+	ctx := context.Background()
+	s := testStorage(t)
+	product := createTestProduct(t, s, 10)
+	variant := product.Variants[0]
+
+	reservation := &StockReservation{ProductID: product.ID, VariantID: variant.ID, UserID: product.UserID, Quantity: 5}
+	if err := s.Reservations.ReservationCreate(ctx, reservation, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := s.Orders.OrderCheckout(ctx, product.UserID, []OrderLine{{VariantID: variant.ID, Quantity: 2}}, AllocatePriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept, err := s.Reservations.ReservationGetByID(ctx, reservation.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Status != ReservationActive || kept.Quantity != 3 || kept.OrderID != nil {
+		t.Errorf("got hold %s of %d for order %v, want 3 still active", kept.Status, kept.Quantity, kept.OrderID)
+	}
+
+	var committed int
+	query := `SELECT COALESCE(SUM(quantity), 0) FROM stock_reservations WHERE order_id = $1 AND status = 'committed'`
+	if err := s.Reservations.(*ReservationStore).db.QueryRowContext(ctx, query, order.ID).Scan(&committed); err != nil {
+		t.Fatal(err)
+	}
+	if committed != 2 {
+		t.Errorf("got %d units committed to the order, want 2", committed)
+	}
+}
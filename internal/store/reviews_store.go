@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"time"
@@ -10,25 +11,28 @@ import (
 
 type Review struct {
 	ID        uuid.UUID `json:"id"`
-	ProductID uuid.UUID `json:"post_id"`
+	ProductID uuid.UUID `json:"product_id"`
 	UserID    uuid.UUID `json:"user_id"`
 	Content   string    `json:"content"`
+	Stars     int       `json:"stars"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 	User      User      `json:"user"`
 }
 
 type ReviewStore struct {
-	db *sql.DB
+	db querier
 }
 
-func (s *ReviewStore) ReviewGet(ctx context.Context, postID uuid.UUID) ([]Review, error) {
-	query := `SELECT r.product_id, r.user_id, r.content, r.created_at, users.username, users.id FROM reviews r JOIN users ON users.id = r.user_id
-         WHERE r.product_id = $1 ORDER BY r.created_at DESC;`
+func (s *ReviewStore) ReviewGet(ctx context.Context, productID uuid.UUID) ([]Review, error) {
+	query := `SELECT r.id, r.product_id, r.user_id, r.content, r.stars, r.created_at, r.updated_at, users.username, users.id
+		FROM reviews r JOIN users ON users.id = r.user_id
+        WHERE r.product_id = $1 ORDER BY r.created_at DESC;`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, productID)
 	if err != nil {
 		return nil, err
 	}
@@ -41,30 +45,161 @@ func (s *ReviewStore) ReviewGet(ctx context.Context, postID uuid.UUID) ([]Review
 		}
 	}(rows)
 
-	var reviews []Review
+	reviews := []Review{}
 	for rows.Next() {
 		var r Review
 		r.User = User{}
-		err := rows.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Content, &r.CreatedAt, &r.User.Username, &r.User.ID)
+		err := rows.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Content, &r.Stars, &r.CreatedAt, &r.UpdatedAt, &r.User.Username, &r.User.ID)
 		if err != nil {
 			return nil, err
 		}
 		reviews = append(reviews, r)
 	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return reviews, nil
 }
 
+func (s *ReviewStore) ReviewGetByID(ctx context.Context, reviewID uuid.UUID) (*Review, error) {
+	query := `SELECT r.id, r.product_id, r.user_id, r.content, r.stars, r.created_at, r.updated_at, users.username, users.id
+		FROM reviews r JOIN users ON users.id = r.user_id
+		WHERE r.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var r Review
+	row := s.db.QueryRowContext(ctx, query, reviewID)
+
+	err := row.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Content, &r.Stars, &r.CreatedAt, &r.UpdatedAt, &r.User.Username, &r.User.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
 func (s *ReviewStore) ReviewCreate(ctx context.Context, r *Review) error {
-	query := `INSERT INTO reviews (product_id, user_id, content) VALUES ($1, $2, $3) RETURNING id, created_at`
+	return withTx(s.db, ctx, func(q querier) error {
+		if err := lockProduct(ctx, q, r.ProductID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO reviews (product_id, user_id, content, stars) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		row := q.QueryRowContext(ctx, query, r.ProductID, r.UserID, r.Content, r.Stars)
+		err := row.Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return foreignKeyViolation(uniqueViolation(err, map[string]error{
+				"reviews_product_id_user_id_key": ErrDuplicateReview,
+			}))
+		}
+
+		return refreshProductRating(ctx, q, r.ProductID)
+	})
+}
+
+func (s *ReviewStore) ReviewUpdate(ctx context.Context, r *Review) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		if err := lockProduct(ctx, q, r.ProductID); err != nil {
+			return err
+		}
+
+		query := `UPDATE reviews SET content = $1, stars = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3 AND product_id = $4 RETURNING updated_at`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := q.QueryRowContext(ctx, query, r.Content, r.Stars, r.ID, r.ProductID).Scan(&r.UpdatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return refreshProductRating(ctx, q, r.ProductID)
+	})
+}
+
+func (s *ReviewStore) ReviewDelete(ctx context.Context, r *Review) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		if err := lockProduct(ctx, q, r.ProductID); err != nil {
+			return err
+		}
+
+		query := `DELETE FROM reviews WHERE id = $1 AND product_id = $2`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := q.ExecContext(ctx, query, r.ID, r.ProductID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		return refreshProductRating(ctx, q, r.ProductID)
+	})
+}
+
+// lockProduct takes a row lock on a product for the rest of the transaction.
+// Review writes take it before touching reviews so that concurrent writers
+// recompute the product's rating one at a time and always see each other's
+// rows.
+func lockProduct(ctx context.Context, q querier, productID uuid.UUID) error {
+	query := `SELECT id FROM products WHERE id = $1 FOR UPDATE`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows := s.db.QueryRowContext(ctx, query, r.ProductID, r.UserID, r.Content)
-	err := rows.Scan(&r.ID, &r.CreatedAt)
+	var id uuid.UUID
+	err := q.QueryRowContext(ctx, query, productID).Scan(&id)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
 	}
 
 	return nil
 }
+
+// refreshProductRating recomputes a product's average star rating and review
+// count from its reviews.
+func refreshProductRating(ctx context.Context, q querier, productID uuid.UUID) error {
+	query := `UPDATE products
+		SET rating       = COALESCE((SELECT AVG(stars) FROM reviews WHERE product_id = $1), 0),
+			review_count = (SELECT COUNT(*) FROM reviews WHERE product_id = $1)
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, productID)
+	return err
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"slices"
+)
+
+// Built-in role names. Every user has exactly one role.
+const (
+	RoleBuyer  = "buyer"
+	RoleSeller = "seller"
+	RoleAdmin  = "admin"
+)
+
+// Permissions granted to roles through the role_permissions table.
+const (
+	// PermOrdersWrite allows using a cart, checking out and paying.
+	PermOrdersWrite = "orders:write"
+	// PermOrdersManage allows changing the status of any order.
+	PermOrdersManage = "orders:manage"
+	// PermProductsWrite allows listing products and changing one's own.
+	PermProductsWrite = "products:write"
+	// PermProductsManage allows changing and deleting anyone's products.
+	PermProductsManage = "products:manage"
+	// PermReviewsWrite allows reviewing products.
+	PermReviewsWrite = "reviews:write"
+	// PermReviewsModerate allows deleting anyone's reviews.
+	PermReviewsModerate = "reviews:moderate"
+	// PermUsersManage allows changing the roles of users.
+	PermUsersManage = "users:manage"
+	// PermCategoriesManage allows creating, changing and deleting categories.
+	PermCategoriesManage = "categories:manage"
+	// PermPromotionsManage allows creating, changing and deleting promotions.
+	PermPromotionsManage = "promotions:manage"
+	// PermTaxManage allows creating, changing and deleting tax rates.
+	PermTaxManage = "tax:manage"
+)
+
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Level       int      `json:"level"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Can reports whether the role has been granted permission.
+func (r Role) Can(permission string) bool {
+	return slices.Contains(r.Permissions, permission)
+}
+
+type RoleStore struct {
+	db querier
+}
+
+func (s *RoleStore) RoleGetByName(ctx context.Context, name string) (*Role, error) {
+	query := `SELECT id, name, level, description,
+		ARRAY(SELECT permission FROM role_permissions WHERE role_id = roles.id ORDER BY permission)
+		FROM roles WHERE name = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	role := &Role{}
+	err := s.db.QueryRowContext(ctx, query, name).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Level,
+		&role.Description,
+		pq.Array(&role.Permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+// RoleGetAll returns every role, least privileged first.
+func (s *RoleStore) RoleGetAll(ctx context.Context) ([]Role, error) {
+	query := `SELECT id, name, level, description,
+		ARRAY(SELECT permission FROM role_permissions WHERE role_id = roles.id ORDER BY permission)
+		FROM roles ORDER BY level, name`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Level, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
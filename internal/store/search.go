@@ -0,0 +1,55 @@
+package store
+
+import (
+	"strings"
+	"unicode"
+)
+
+// toTSQuery turns a user search string into to_tsquery syntax. Terms are
+// ANDed together, "quoted phrases" must appear in order and a trailing * on a
+// term matches it as a prefix. Anything other than letters and digits is
+// dropped so user input can never produce a malformed query.
+func toTSQuery(search string) string {
+	var terms []string
+
+	for i, part := range strings.Split(search, `"`) {
+		// Every odd part sits between a pair of quotes.
+		if i%2 == 1 {
+			if words := tsWords(part, false); len(words) > 0 {
+				terms = append(terms, "("+strings.Join(words, " <-> ")+")")
+			}
+			continue
+		}
+
+		terms = append(terms, tsWords(part, true)...)
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+func tsWords(s string, allowPrefix bool) []string {
+	var words []string
+
+	for _, field := range strings.Fields(s) {
+		prefix := allowPrefix && strings.HasSuffix(field, "*")
+
+		word := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, field)
+
+		if word == "" {
+			continue
+		}
+
+		if prefix {
+			word += ":*"
+		}
+
+		words = append(words, word)
+	}
+
+	return words
+}
@@ -3,8 +3,12 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"github.com/seanhalberthal/webmart/internal/tax"
+	"math/big"
 	"time"
 )
 
@@ -12,47 +16,218 @@ var (
 	QueryTimeoutDuration = time.Second * 5
 )
 
+// querier is the subset of database/sql shared by *sql.DB and *sql.Tx, which
+// lets every store run either standalone or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Storage struct {
+	// db is nil when the Storage is bound to a transaction by WithTx.
+	db *sql.DB
+
 	Products interface {
 		ProductCreate(context.Context, *Product) error
 		ProductGetByID(context.Context, uuid.UUID) (*Product, error)
-		ProductGetAll(context.Context) ([]ProductSummary, error)
+		ProductGetAll(context.Context, ProductQuery) (*ProductPage, error)
+		ProductSearch(context.Context, ProductSearchQuery) (*ProductSearchPage, error)
 		ProductDelete(context.Context, uuid.UUID) error
 		ProductUpdate(context.Context, *Product) error
 	}
 
+	ProductImages interface {
+		ProductImageAdd(ctx context.Context, productID uuid.UUID, images []*ProductImage, limit int) error
+		ProductImageGetAll(context.Context, uuid.UUID) ([]ProductImage, error)
+		ProductImageDelete(ctx context.Context, productID, imageID uuid.UUID) (*ProductImage, error)
+	}
+
+	ProductVariants interface {
+		ProductVariantCreate(ctx context.Context, variant *ProductVariant, actorID uuid.UUID) error
+		ProductVariantGetByID(ctx context.Context, productID, variantID uuid.UUID) (*ProductVariant, error)
+		ProductVariantGetAll(context.Context, uuid.UUID) ([]ProductVariant, error)
+		ProductVariantUpdate(context.Context, *ProductVariant) error
+		ProductVariantDelete(ctx context.Context, productID, variantID uuid.UUID) error
+	}
+
+	Inventory interface {
+		InventoryRecord(context.Context, *InventoryMovement) error
+		InventoryGetHistory(context.Context, InventoryQuery) (*InventoryPage, error)
+	}
+
+	Reservations interface {
+		ReservationCreate(ctx context.Context, reservation *StockReservation, ttl time.Duration) error
+		ReservationGetByID(context.Context, uuid.UUID) (*StockReservation, error)
+		ReservationCommit(ctx context.Context, reservation *StockReservation, actorID uuid.UUID, strategy AllocationStrategy) error
+		ReservationRelease(context.Context, *StockReservation) error
+		ReservationExpire(context.Context) (int64, error)
+	}
+
+	Warehouses interface {
+		WarehouseCreate(context.Context, *Warehouse) error
+		WarehouseGetByID(context.Context, uuid.UUID) (*Warehouse, error)
+		WarehouseGetAllByUser(context.Context, uuid.UUID) ([]Warehouse, error)
+		WarehouseUpdate(context.Context, *Warehouse) error
+		WarehouseDelete(context.Context, uuid.UUID) error
+		WarehouseGetStockLevels(context.Context, uuid.UUID) (map[uuid.UUID][]StockLevel, error)
+		WarehouseAllocate(ctx context.Context, productID, variantID uuid.UUID, quantity int, strategy AllocationStrategy) (*Allocation, error)
+		WarehouseTransfer(context.Context, *StockTransfer) error
+	}
+
+	Categories interface {
+		CategoryCreate(context.Context, *Category) error
+		CategoryGetByID(context.Context, uuid.UUID) (*Category, error)
+		CategoryGetAll(context.Context) ([]Category, error)
+		CategoryUpdate(context.Context, *Category) error
+		CategoryDelete(context.Context, uuid.UUID) error
+		CategorySetForProduct(ctx context.Context, productID uuid.UUID, categoryIDs []uuid.UUID) error
+		CategoryGetByProduct(context.Context, uuid.UUID) ([]CategoryRef, error)
+	}
+
 	Users interface {
 		UserCreate(context.Context, *User) error
 		UserGet(context.Context, uuid.UUID) (*User, error)
+		UserGetByEmail(context.Context, string) (*User, error)
+		UserCreateAndInvite(context.Context, *User, string, time.Duration) error
+		UserActivate(context.Context, string) error
+		UserDelete(context.Context, uuid.UUID) error
+		UserSetRole(ctx context.Context, userID uuid.UUID, role string) error
+	}
+
+	Roles interface {
+		RoleGetByName(context.Context, string) (*Role, error)
+		RoleGetAll(context.Context) ([]Role, error)
 	}
 
 	Reviews interface {
 		ReviewCreate(context.Context, *Review) error
 		ReviewGet(context.Context, uuid.UUID) ([]Review, error)
+		ReviewGetByID(context.Context, uuid.UUID) (*Review, error)
+		ReviewUpdate(context.Context, *Review) error
+		ReviewDelete(context.Context, *Review) error
+	}
+
+	Carts interface {
+		CartGet(context.Context, uuid.UUID) (*Cart, error)
+		CartItemAdd(ctx context.Context, userID, variantID uuid.UUID, quantity int) error
+		CartItemUpdate(ctx context.Context, userID, variantID uuid.UUID, quantity int) error
+		CartItemRemove(ctx context.Context, userID, variantID uuid.UUID) error
+	}
+
+	Orders interface {
+		OrderCheckout(ctx context.Context, userID uuid.UUID, lines []OrderLine, strategy AllocationStrategy) (*Order, error)
+		OrderGetByID(context.Context, uuid.UUID) (*Order, error)
+		OrderGetAllByUser(context.Context, uuid.UUID) ([]Order, error)
+		OrderTransition(ctx context.Context, orderID uuid.UUID, to OrderStatus, actorID *uuid.UUID, note string) error
+		OrderHasSeller(ctx context.Context, orderID, userID uuid.UUID) (bool, error)
+		OrderGetSellers(context.Context, uuid.UUID) ([]uuid.UUID, error)
+		OrderLockStatus(context.Context, uuid.UUID) (OrderStatus, error)
+	}
+
+	Promotions interface {
+		PromotionCreate(context.Context, *Promotion) error
+		PromotionGetByID(context.Context, uuid.UUID) (*Promotion, error)
+		PromotionGetAll(context.Context) ([]*Promotion, error)
+		PromotionUpdate(context.Context, *Promotion) error
+		PromotionDelete(context.Context, uuid.UUID) error
+		PromotionEvaluate(ctx context.Context, userID uuid.UUID, codes []string, items []BasketItem) (*PromotionEvaluation, error)
+		PromotionRedeem(ctx context.Context, userID uuid.UUID, codes []string, items []BasketItem, orderID *uuid.UUID) (*PromotionEvaluation, error)
+	}
+
+	TaxRates interface {
+		TaxRateCreate(context.Context, *tax.Rate) error
+		TaxRateGetByID(context.Context, uuid.UUID) (*tax.Rate, error)
+		TaxRateGetAll(ctx context.Context, country string) ([]tax.Rate, error)
+		TaxRateUpdate(context.Context, *tax.Rate) error
+		TaxRateDelete(context.Context, uuid.UUID) error
+		TaxQuote(ctx context.Context, dest tax.Destination, items []BasketItem) (*tax.Quote, error)
+	}
+
+	ExchangeRates interface {
+		ExchangeRateUpsert(context.Context, []ExchangeRate) error
+		ExchangeRateGetAllTo(context.Context, money.Currency) (map[money.Currency]*big.Rat, error)
+	}
+
+	Payments interface {
+		PaymentCreate(context.Context, *Payment) error
+		PaymentUpdate(context.Context, *Payment) error
+		PaymentGetAllByOrder(context.Context, uuid.UUID) ([]Payment, error)
+		PaymentGetByID(context.Context, uuid.UUID) (*Payment, error)
+		PaymentGetAllReturning(context.Context) ([]Payment, error)
+		PaymentGetByProviderRef(context.Context, string) (*Payment, error)
+		PaymentRecordEvent(ctx context.Context, paymentID uuid.UUID, eventID, eventType string, payload []byte) (bool, error)
 	}
 }
 
 func NewStorage(db *sql.DB) Storage {
+	s := newStorage(db)
+	s.db = db
+
+	return s
+}
+
+func newStorage(q querier) Storage {
 	return Storage{
-		Products: &ProductStore{db},
-		Users:    &UserStore{db},
-		Reviews:  &ReviewStore{db},
+		Products:        &ProductStore{q},
+		ProductImages:   &ProductImageStore{q},
+		ProductVariants: &ProductVariantStore{q},
+		Inventory:       &InventoryStore{q},
+		Reservations:    &ReservationStore{q},
+		Warehouses:      &WarehouseStore{q},
+		Categories:      &CategoryStore{q},
+		Users:           &UserStore{q},
+		Roles:           &RoleStore{q},
+		Reviews:         &ReviewStore{q},
+		Carts:           &CartStore{q},
+		Orders:          &OrderStore{q},
+		Payments:        &PaymentStore{q},
+		Promotions:      &PromotionStore{q},
+		TaxRates:        &TaxRateStore{q},
+		ExchangeRates:   &ExchangeRateStore{q},
+	}
+}
+
+// WithTx runs fn with a Storage whose stores all share a single transaction.
+// The transaction is committed if fn returns nil and rolled back if it returns
+// an error or panics. Calling WithTx on a Storage that is already bound to a
+// transaction joins the existing transaction.
+func (s Storage) WithTx(ctx context.Context, fn func(Storage) error) error {
+	if s.db == nil {
+		return fn(s)
 	}
+
+	return withTx(s.db, ctx, func(tx querier) error {
+		return fn(newStorage(tx))
+	})
 }
 
-//func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
-//	tx, err := db.BeginTx(ctx, nil)
-//	if err != nil {
-//		return err
-//	}
-//
-//	if err = fn(tx); err != nil {
-//		rbErr := tx.Rollback()
-//		if rbErr != nil {
-//			return rbErr
-//		}
-//		return err
-//	}
-//
-//	return tx.Commit()
-//}
+// withTx runs fn inside a transaction on q. If q is already a transaction, fn
+// runs on it directly and the outermost caller decides whether to commit.
+func withTx(q querier, ctx context.Context, fn func(querier) error) (err error) {
+	db, ok := q.(*sql.DB)
+	if !ok {
+		return fn(q)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
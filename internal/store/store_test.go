@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+)
+
+// testStorage returns a Storage bound to a transaction on the migrated
+// database at TEST_DB_ADDR, which is rolled back when the test ends. Tests
+// using it are skipped when TEST_DB_ADDR is not set.
+func testStorage(t *testing.T) Storage {
+	t.Helper()
+
+	addr := os.Getenv("TEST_DB_ADDR")
+	if addr == "" {
+		t.Skip("TEST_DB_ADDR is not set")
+	}
+
+	db, err := sql.Open("postgres", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tx.Rollback() })
+
+	return newStorage(tx)
+}
+
+// createTestProduct creates a seller with a product that has a single
+// variant holding stock units.
+func createTestProduct(t *testing.T, s Storage, stock int) *Product {
+	t.Helper()
+	ctx := context.Background()
+
+	name := "test-" + uuid.NewString()[:8]
+	password := "password"
+	seller := &User{Name: name, Username: name, Email: name + "@example.com", Password: Password{Text: &password}, Role: Role{Name: RoleSeller}}
+	if err := s.Users.UserCreate(ctx, seller); err != nil {
+		t.Fatal(err)
+	}
+
+	product := &Product{
+		UserID:   seller.ID,
+		Title:    "Test product",
+		Variants: []ProductVariant{{Price: money.MustParse("9.99", money.GBP), Stock: stock}},
+	}
+	if err := s.Products.ProductCreate(ctx, product); err != nil {
+		t.Fatal(err)
+	}
+
+	return product
+}
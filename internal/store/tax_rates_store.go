@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/tax"
+	"strings"
+)
+
+var ErrDuplicateTaxRate = errors.New("a tax rate for that destination and tax class already exists")
+
+type TaxRateStore struct {
+	db querier
+}
+
+func (s *TaxRateStore) TaxRateCreate(ctx context.Context, rate *tax.Rate) error {
+	if err := rate.Normalize(); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO tax_rates (country, region, tax_class, percent, name)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		rate.Country,
+		rate.Region,
+		rate.Class,
+		rate.Percent,
+		rate.Name).Scan(&rate.ID, &rate.CreatedAt, &rate.UpdatedAt)
+	if err != nil {
+		return uniqueViolation(err, map[string]error{
+			"tax_rates_country_region_tax_class_key": ErrDuplicateTaxRate,
+		})
+	}
+
+	return nil
+}
+
+func (s *TaxRateStore) TaxRateGetByID(ctx context.Context, id uuid.UUID) (*tax.Rate, error) {
+	query := `SELECT ` + taxRateColumns + ` FROM tax_rates WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rate, err := scanTaxRate(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return rate, nil
+}
+
+// TaxRateGetAll returns the rate table, optionally only the rates of one
+// country, ordered by country, region and tax class.
+func (s *TaxRateStore) TaxRateGetAll(ctx context.Context, country string) ([]tax.Rate, error) {
+	query := `SELECT ` + taxRateColumns + ` FROM tax_rates
+		WHERE $1 = '' OR country = $1
+		ORDER BY country, region, tax_class`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return getTaxRates(ctx, s.db, query, strings.ToUpper(country))
+}
+
+func (s *TaxRateStore) TaxRateUpdate(ctx context.Context, rate *tax.Rate) error {
+	if err := rate.Normalize(); err != nil {
+		return err
+	}
+
+	query := `UPDATE tax_rates SET country = $1, region = $2, tax_class = $3, percent = $4, name = $5,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		rate.Country,
+		rate.Region,
+		rate.Class,
+		rate.Percent,
+		rate.Name,
+		rate.ID).Scan(&rate.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return uniqueViolation(err, map[string]error{
+				"tax_rates_country_region_tax_class_key": ErrDuplicateTaxRate,
+			})
+		}
+	}
+
+	return nil
+}
+
+func (s *TaxRateStore) TaxRateDelete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM tax_rates WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// TaxQuote prices basket items and works out the tax due on them when
+// delivered to dest, using each product's tax class and tax treatment.
+func (s *TaxRateStore) TaxQuote(ctx context.Context, dest tax.Destination, items []BasketItem) (*tax.Quote, error) {
+	var (
+		lines []tax.Line
+		rates []tax.Rate
+	)
+
+	// A transaction gives a consistent view of the prices and the rates.
+	err := withTx(s.db, ctx, func(q querier) error {
+		var err error
+		if lines, err = getTaxLines(ctx, q, items); err != nil {
+			return err
+		}
+
+		query := `SELECT ` + taxRateColumns + ` FROM tax_rates WHERE country = $1`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		rates, err = getTaxRates(qctx, q, query, strings.ToUpper(dest.Country))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tax.NewTable(rates).Quote(dest, lines)
+}
+
+// getTaxLines prices basket items along with their products' tax treatment.
+// All the items must be priced in the same currency.
+func getTaxLines(ctx context.Context, q querier, items []BasketItem) ([]tax.Line, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	lines := make([]tax.Line, len(items))
+	for i, item := range items {
+		line := tax.Line{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+
+		var row *sql.Row
+		if item.VariantID != nil {
+			row = q.QueryRowContext(ctx, `SELECT p.title, v.price, p.currency, p.tax_class, p.price_includes_tax
+				FROM product_variants v JOIN products p ON p.id = v.product_id
+				WHERE v.id = $1 AND v.product_id = $2`, *item.VariantID, item.ProductID)
+		} else {
+			row = q.QueryRowContext(ctx, `SELECT title, price, currency, tax_class, price_includes_tax
+				FROM products WHERE id = $1`, item.ProductID)
+		}
+
+		var price, currency string
+		if err := row.Scan(&line.Title, &price, &currency, &line.Class, &line.PriceIncludesTax); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, fmt.Errorf("product %s: %w", item.ProductID, ErrNotFound)
+			default:
+				return nil, err
+			}
+		}
+
+		var err error
+		if line.UnitPrice, err = parseAmount(price, currency); err != nil {
+			return nil, err
+		}
+
+		if i > 0 && line.UnitPrice.Currency() != lines[0].UnitPrice.Currency() {
+			return nil, ErrMixedCurrencies
+		}
+
+		lines[i] = line
+	}
+
+	return lines, nil
+}
+
+func getTaxRates(ctx context.Context, q querier, query string, args ...any) ([]tax.Rate, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	rates := []tax.Rate{}
+	for rows.Next() {
+		rate, err := scanTaxRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, *rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// taxRateColumns selects a tax rate in the order scanTaxRate reads it. The
+// percent is trimmed of the column's trailing zeros, e.g. to "20" rather than
+// "20.0000".
+const taxRateColumns = `id, country, region, tax_class, trim_scale(percent)::text, name, created_at, updated_at`
+
+func scanTaxRate(row interface{ Scan(...any) error }) (*tax.Rate, error) {
+	var rate tax.Rate
+	err := row.Scan(
+		&rate.ID,
+		&rate.Country,
+		&rate.Region,
+		&rate.Class,
+		&rate.Percent,
+		&rate.Name,
+		&rate.CreatedAt,
+		&rate.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
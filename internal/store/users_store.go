@@ -2,22 +2,61 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"time"
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  Password  `json:"-"`
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Password Password  `json:"-"`
+	IsActive bool      `json:"is_active"`
+	// Role is created as a buyer unless its Name is set.
+	Role      Role      `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// userColumns selects a user joined with its role and the role's
+// permissions, in the order scanUser reads them.
+const userColumns = `u.id, u.name, u.username, u.email, u.password, u.is_active, u.created_at,
+	r.id, r.name, r.level, r.description,
+	ARRAY(SELECT permission FROM role_permissions WHERE role_id = r.id ORDER BY permission)`
+
+func scanUser(row *sql.Row) (*User, error) {
+	user := &User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Username,
+		&user.Email,
+		&user.Password.Hash,
+		&user.IsActive,
+		&user.CreatedAt,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
+		pq.Array(&user.Role.Permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
 type Password struct {
 	Text *string
 	Hash []byte
@@ -35,49 +74,205 @@ func (p *Password) Set(text string) error {
 	return nil
 }
 
+func (p *Password) Compare(text string) error {
+	return bcrypt.CompareHashAndPassword(p.Hash, []byte(text))
+}
+
 type UserStore struct {
-	db *sql.DB
+	db querier
 }
 
 func (s *UserStore) UserCreate(ctx context.Context, user *User) error {
-	query := `INSERT INTO users (name, username, email, password) VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at`
-
 	err := user.Password.Set(*user.Password.Text)
 	if err != nil {
 		return err
 	}
 
+	return s.createUser(ctx, s.db, user)
+}
+
+func (s *UserStore) UserGet(ctx context.Context, userID uuid.UUID) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u JOIN roles r ON r.id = u.role_id WHERE u.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return scanUser(s.db.QueryRowContext(ctx, query, userID))
+}
+
+func (s *UserStore) UserGetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u JOIN roles r ON r.id = u.role_id WHERE u.email = $1`
+
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	row := s.db.QueryRowContext(ctx, query, user.Name, user.Username, user.Email, string(user.Password.Hash))
-	err = row.Scan(&user.ID, &user.CreatedAt)
+	return scanUser(s.db.QueryRowContext(ctx, query, email))
+}
+
+// UserSetRole gives a user the named role.
+func (s *UserStore) UserSetRole(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `UPDATE users SET role_id = (SELECT id FROM roles WHERE name = $1) WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, role, userID)
 	if err != nil {
+		// A role that does not exist leaves role_id NULL.
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqNotNullViolation {
+			return ErrNotFound
+		}
 		return err
 	}
 
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
 
-func (s *UserStore) UserGet(ctx context.Context, userID uuid.UUID) (*User, error) {
-	query := `SELECT id, username, email, password, created_at FROM users WHERE id = $1`
+func (s *UserStore) UserCreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error {
+	return withTx(s.db, ctx, func(tx querier) error {
+		if err := s.createUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		return s.createUserInvitation(ctx, tx, token, invitationExp, user.ID)
+	})
+}
+
+func (s *UserStore) UserActivate(ctx context.Context, token string) error {
+	return withTx(s.db, ctx, func(tx querier) error {
+		user, err := s.getUserFromInvitation(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+
+		user.IsActive = true
+		if err := s.updateUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		return s.deleteUserInvitations(ctx, tx, user.ID)
+	})
+}
+
+func (s *UserStore) UserDelete(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *UserStore) createUser(ctx context.Context, q querier, user *User) error {
+	query := `INSERT INTO users (name, username, email, password, is_active, role_id)
+	SELECT $1, $2, $3, $4, $5, r.id FROM roles r WHERE r.name = $6
+	RETURNING id, created_at, role_id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if user.Role.Name == "" {
+		user.Role.Name = RoleBuyer
+	}
+
+	row := q.QueryRowContext(ctx, query, user.Name, user.Username, user.Email, string(user.Password.Hash), user.IsActive, user.Role.Name)
+
+	err := row.Scan(&user.ID, &user.CreatedAt, &user.Role.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("role %q: %w", user.Role.Name, ErrNotFound)
+	}
+	if err != nil {
+		return uniqueViolation(err, map[string]error{
+			"users_email_key":    ErrDuplicateEmail,
+			"users_username_key": ErrDuplicateUsername,
+		})
+	}
+
+	return nil
+}
+
+func (s *UserStore) createUserInvitation(ctx context.Context, q querier, token string, exp time.Duration, userID uuid.UUID) error {
+	query := `INSERT INTO user_invitations (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, hashToken(token), userID, time.Now().Add(exp))
+	return err
+}
+
+func (s *UserStore) getUserFromInvitation(ctx context.Context, q querier, token string) (*User, error) {
+	query := `SELECT u.id, u.name, u.username, u.email, u.is_active, u.created_at
+		FROM users u
+		JOIN user_invitations ui ON u.id = ui.user_id
+		WHERE ui.token = $1 AND ui.expiry > $2`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
 	user := &User{}
-	row := s.db.QueryRowContext(ctx, query, userID)
+	row := q.QueryRowContext(ctx, query, hashToken(token), time.Now())
 
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Name, &user.Username, &user.Email, &user.IsActive, &user.CreatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, err
+			return nil, ErrNotFound
 		default:
-			return user, nil
+			return nil, err
 		}
 	}
 
 	return user, nil
 }
+
+func (s *UserStore) updateUser(ctx context.Context, q querier, user *User) error {
+	query := `UPDATE users SET name = $1, username = $2, email = $3, is_active = $4 WHERE id = $5`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, user.Name, user.Username, user.Email, user.IsActive, user.ID)
+	return err
+}
+
+func (s *UserStore) deleteUserInvitations(ctx context.Context, q querier, userID uuid.UUID) error {
+	query := `DELETE FROM user_invitations WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := q.ExecContext(ctx, query, userID)
+	return err
+}
+
+// hashToken returns the SHA-256 digest of an invitation token. Only the
+// digest is persisted so a leaked table cannot be used to activate accounts.
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
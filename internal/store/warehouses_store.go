@@ -0,0 +1,482 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+var (
+	ErrDuplicateWarehouse = errors.New("a warehouse with that name already exists")
+	ErrWarehouseNotEmpty  = errors.New("a warehouse that still holds stock cannot be deleted")
+)
+
+// AllocationStrategy decides which warehouses stock is taken from.
+type AllocationStrategy string
+
+const (
+	// AllocateMostStock takes from the warehouses holding the most first.
+	AllocateMostStock AllocationStrategy = "most_stock"
+	// AllocateFewestSplits takes from as few warehouses as possible. When one
+	// warehouse can fill the request alone, the one holding the least that
+	// can is used, keeping larger stocks for larger requests.
+	AllocateFewestSplits AllocationStrategy = "fewest_splits"
+	// AllocatePriority takes from the warehouses in their priority order.
+	AllocatePriority AllocationStrategy = "priority"
+)
+
+func (s AllocationStrategy) Valid() bool {
+	switch s {
+	case AllocateMostStock, AllocateFewestSplits, AllocatePriority:
+		return true
+	default:
+		return false
+	}
+}
+
+// Warehouse is a location a seller keeps stock in.
+type Warehouse struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Priority orders the seller's warehouses, lowest first, for the
+	// priority allocation strategy. The first warehouse also receives stock
+	// that is booked without naming one.
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StockLevel is an amount of stock in one warehouse.
+type StockLevel struct {
+	WarehouseID   uuid.UUID `json:"warehouse_id"`
+	WarehouseName string    `json:"warehouse_name"`
+	Quantity      int       `json:"quantity"`
+}
+
+// Allocation is a plan for taking a quantity of a variant from its
+// warehouses.
+type Allocation struct {
+	VariantID uuid.UUID          `json:"variant_id"`
+	Quantity  int                `json:"quantity"`
+	Strategy  AllocationStrategy `json:"strategy"`
+	Picks     []StockLevel       `json:"picks"`
+}
+
+// StockTransfer moves stock of a variant between two of its seller's
+// warehouses.
+type StockTransfer struct {
+	ProductID       uuid.UUID
+	VariantID       uuid.UUID
+	FromWarehouseID uuid.UUID
+	ToWarehouseID   uuid.UUID
+	Quantity        int
+	Reason          string
+	ActorID         *uuid.UUID
+	// Movements are the ledger entries the transfer made, out then in.
+	Movements []InventoryMovement
+}
+
+// TotalStockLevels adds up the stock levels of a product's variants by
+// warehouse, keeping the order the warehouses first appear in.
+func TotalStockLevels(variants []ProductVariant) []StockLevel {
+	totals := []StockLevel{}
+	for _, v := range variants {
+		for _, level := range v.Warehouses {
+			i := slices.IndexFunc(totals, func(t StockLevel) bool { return t.WarehouseID == level.WarehouseID })
+			if i < 0 {
+				totals = append(totals, level)
+				continue
+			}
+			totals[i].Quantity += level.Quantity
+		}
+	}
+
+	return totals
+}
+
+type WarehouseStore struct {
+	db querier
+}
+
+func (s *WarehouseStore) WarehouseCreate(ctx context.Context, warehouse *Warehouse) error {
+	query := `INSERT INTO warehouses (user_id, name, priority) VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		warehouse.UserID,
+		warehouse.Name,
+		warehouse.Priority).Scan(&warehouse.ID, &warehouse.CreatedAt, &warehouse.UpdatedAt)
+	if err != nil {
+		return foreignKeyViolation(uniqueViolation(err, map[string]error{
+			"warehouses_user_id_name_key": ErrDuplicateWarehouse,
+		}))
+	}
+
+	return nil
+}
+
+func (s *WarehouseStore) WarehouseGetByID(ctx context.Context, id uuid.UUID) (*Warehouse, error) {
+	query := `SELECT id, user_id, name, priority, created_at, updated_at FROM warehouses WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	w := &Warehouse{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&w.ID,
+		&w.UserID,
+		&w.Name,
+		&w.Priority,
+		&w.CreatedAt,
+		&w.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// WarehouseGetAllByUser returns a seller's warehouses in priority order.
+func (s *WarehouseStore) WarehouseGetAllByUser(ctx context.Context, userID uuid.UUID) ([]Warehouse, error) {
+	query := `SELECT id, user_id, name, priority, created_at, updated_at FROM warehouses
+		WHERE user_id = $1 ORDER BY priority, created_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	warehouses := []Warehouse{}
+	for rows.Next() {
+		var w Warehouse
+		if err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.Priority, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		warehouses = append(warehouses, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return warehouses, nil
+}
+
+func (s *WarehouseStore) WarehouseUpdate(ctx context.Context, warehouse *Warehouse) error {
+	query := `UPDATE warehouses SET name = $1, priority = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		warehouse.Name,
+		warehouse.Priority,
+		warehouse.ID).Scan(&warehouse.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return uniqueViolation(err, map[string]error{
+				"warehouses_user_id_name_key": ErrDuplicateWarehouse,
+			})
+		}
+	}
+
+	return nil
+}
+
+// WarehouseDelete deletes a warehouse that holds no stock. Its past
+// movements stay in the ledger without it.
+func (s *WarehouseStore) WarehouseDelete(ctx context.Context, id uuid.UUID) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Locking the warehouse holds off movements into it, whose
+		// warehouse_stock rows reference it, until the check is done.
+		query := `SELECT COALESCE((SELECT SUM(quantity) FROM warehouse_stock WHERE warehouse_id = w.id), 0)
+			FROM warehouses w WHERE w.id = $1 FOR UPDATE`
+
+		var stock int
+		if err := q.QueryRowContext(qctx, query, id).Scan(&stock); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if stock > 0 {
+			return ErrWarehouseNotEmpty
+		}
+
+		_, err := q.ExecContext(qctx, `DELETE FROM warehouses WHERE id = $1`, id)
+		return err
+	})
+}
+
+// WarehouseGetStockLevels returns the stock of each of a product's variants
+// by warehouse, keyed by variant ID. Warehouses are in priority order.
+func (s *WarehouseStore) WarehouseGetStockLevels(ctx context.Context, productID uuid.UUID) (map[uuid.UUID][]StockLevel, error) {
+	query := `SELECT ws.variant_id, w.id, w.name, ws.quantity
+		FROM warehouse_stock ws JOIN warehouses w ON w.id = ws.warehouse_id
+		WHERE ws.product_id = $1 AND ws.quantity > 0
+		ORDER BY w.priority, w.created_at, w.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	levels := make(map[uuid.UUID][]StockLevel)
+	for rows.Next() {
+		var (
+			variantID uuid.UUID
+			level     StockLevel
+		)
+		if err := rows.Scan(&variantID, &level.WarehouseID, &level.WarehouseName, &level.Quantity); err != nil {
+			return nil, err
+		}
+		levels[variantID] = append(levels[variantID], level)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+// WarehouseAllocate plans which warehouses quantity units of a variant
+// would be taken from under a strategy, without taking them. It fails with
+// an InsufficientStockError if the variant does not have that much stock
+// that is not held by reservations.
+func (s *WarehouseStore) WarehouseAllocate(ctx context.Context, productID, variantID uuid.UUID, quantity int, strategy AllocationStrategy) (*Allocation, error) {
+	query := `SELECT v.sku, v.stock - ` + heldStock("r.variant_id", "v.id") + `
+		FROM product_variants v WHERE v.id = $1 AND v.product_id = $2`
+
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		sku       string
+		available int
+	)
+	if err := s.db.QueryRowContext(qctx, query, variantID, productID).Scan(&sku, &available); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if quantity > available {
+		return nil, &InsufficientStockError{Shortages: []StockShortage{{
+			ProductID: productID,
+			VariantID: variantID,
+			SKU:       sku,
+			Requested: quantity,
+			Available: max(available, 0),
+		}}}
+	}
+
+	picks, err := allocateStock(ctx, s.db, variantID, quantity, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Allocation{VariantID: variantID, Quantity: quantity, Strategy: strategy, Picks: picks}, nil
+}
+
+// WarehouseTransfer moves stock of a variant between two of its seller's
+// warehouses, recording a movement out of one and into the other. It fails
+// with ErrNotFound if either warehouse is not the seller's, and with
+// ErrInsufficientStock if the source holds too little.
+func (s *WarehouseStore) WarehouseTransfer(ctx context.Context, transfer *StockTransfer) error {
+	return withTx(s.db, ctx, func(q querier) error {
+		if _, err := lockVariantsForCheckout(ctx, q, []uuid.UUID{transfer.VariantID}); err != nil {
+			return err
+		}
+
+		out := InventoryMovement{
+			ProductID:   transfer.ProductID,
+			VariantID:   &transfer.VariantID,
+			WarehouseID: &transfer.FromWarehouseID,
+			Kind:        MovementTransfer,
+			Quantity:    -transfer.Quantity,
+			Reason:      transfer.Reason,
+			ActorID:     transfer.ActorID,
+		}
+		if err := recordMovement(ctx, q, &out); err != nil {
+			return err
+		}
+
+		in := out
+		in.WarehouseID = &transfer.ToWarehouseID
+		in.Quantity = transfer.Quantity
+		if err := recordMovement(ctx, q, &in); err != nil {
+			return err
+		}
+
+		transfer.Movements = []InventoryMovement{out, in}
+		return nil
+	})
+}
+
+// allocateStock picks the warehouses to take quantity units of a variant
+// from. Run it with the variant locked when the stock is then taken.
+func allocateStock(ctx context.Context, q querier, variantID uuid.UUID, quantity int, strategy AllocationStrategy) ([]StockLevel, error) {
+	query := `SELECT w.id, w.name, ws.quantity
+		FROM warehouse_stock ws JOIN warehouses w ON w.id = ws.warehouse_id
+		WHERE ws.variant_id = $1 AND ws.quantity > 0
+		ORDER BY w.priority, w.created_at, w.id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := q.QueryContext(ctx, query, variantID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			return
+		}
+	}(rows)
+
+	var levels []StockLevel
+	for rows.Next() {
+		var level StockLevel
+		if err := rows.Scan(&level.WarehouseID, &level.WarehouseName, &level.Quantity); err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return allocate(levels, quantity, strategy)
+}
+
+// allocate splits quantity across stock levels, which are in priority
+// order, according to a strategy. It fails with ErrInsufficientStock if the
+// levels add up to less than quantity.
+func allocate(levels []StockLevel, quantity int, strategy AllocationStrategy) ([]StockLevel, error) {
+	// Stable sorts keep warehouses holding the same amount in priority order.
+	order := slices.Clone(levels)
+	mostFirst := func(a, b StockLevel) int { return cmp.Compare(b.Quantity, a.Quantity) }
+
+	switch strategy {
+	case AllocatePriority:
+	case AllocateMostStock:
+		slices.SortStableFunc(order, mostFirst)
+	case AllocateFewestSplits:
+		best := -1
+		for i, level := range order {
+			if level.Quantity >= quantity && (best < 0 || level.Quantity < order[best].Quantity) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			pick := order[best]
+			pick.Quantity = quantity
+			return []StockLevel{pick}, nil
+		}
+
+		// Taking from the fullest warehouses first needs the fewest of them.
+		slices.SortStableFunc(order, mostFirst)
+	default:
+		return nil, fmt.Errorf("unknown allocation strategy %q", strategy)
+	}
+
+	var picks []StockLevel
+	remaining := quantity
+	for _, level := range order {
+		if remaining == 0 {
+			break
+		}
+
+		pick := level
+		pick.Quantity = min(level.Quantity, remaining)
+		picks = append(picks, pick)
+		remaining -= pick.Quantity
+	}
+
+	if remaining > 0 {
+		return nil, ErrInsufficientStock
+	}
+
+	return picks, nil
+}
+
+// defaultWarehouse returns the first of the product's seller's warehouses in
+// priority order, creating a "Main" warehouse for sellers without any.
+func defaultWarehouse(ctx context.Context, q querier, productID uuid.UUID) (uuid.UUID, error) {
+	query := `SELECT w.id FROM warehouses w JOIN products p ON p.user_id = w.user_id
+		WHERE p.id = $1 ORDER BY w.priority, w.created_at, w.id LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var id uuid.UUID
+	err := q.QueryRowContext(ctx, query, productID).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	// The no-op update returns the warehouse even if a concurrent request
+	// created it first.
+	query = `INSERT INTO warehouses (user_id, name) SELECT user_id, 'Main' FROM products WHERE id = $1
+		ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name RETURNING id`
+
+	err = q.QueryRowContext(ctx, query, productID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return uuid.Nil, ErrNotFound
+		default:
+			return uuid.Nil, err
+		}
+	}
+
+	return id, nil
+}
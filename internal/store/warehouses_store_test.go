@@ -0,0 +1,100 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAllocate(t *testing.T) {
+	// levels returns stock levels in priority order, one per quantity, in
+	// warehouses named A, B, C and so on.
+	levels := func(quantities ...int) []StockLevel {
+		ls := make([]StockLevel, len(quantities))
+		for i, q := range quantities {
+			ls[i] = StockLevel{WarehouseID: uuid.New(), WarehouseName: string(rune('A' + i)), Quantity: q}
+		}
+		return ls
+	}
+
+	tests := []struct {
+		name     string
+		levels   []StockLevel
+		quantity int
+		strategy AllocationStrategy
+		want     string
+		err      error
+	}{
+		{"priority takes in order", levels(2, 5, 9), 4, AllocatePriority, "A2 B2", nil},
+		{"priority from the first alone", levels(5, 9), 5, AllocatePriority, "A5", nil},
+		{"priority takes everything", levels(2, 5, 9), 16, AllocatePriority, "A2 B5 C9", nil},
+
+		{"most stock takes the fullest first", levels(2, 5, 9), 12, AllocateMostStock, "C9 B3", nil},
+		{"most stock breaks ties by priority", levels(3, 5, 5), 7, AllocateMostStock, "B5 C2", nil},
+
+		{"fewest splits takes the smallest that fits", levels(9, 3, 5), 4, AllocateFewestSplits, "C4", nil},
+		{"fewest splits takes an exact fit", levels(9, 4, 5), 4, AllocateFewestSplits, "B4", nil},
+		{"fewest splits breaks ties by priority", levels(9, 5, 5), 4, AllocateFewestSplits, "B4", nil},
+		{"fewest splits falls back to the fullest first", levels(3, 6, 2, 5), 10, AllocateFewestSplits, "B6 D4", nil},
+		{"fewest splits fallback breaks ties by priority", levels(4, 2, 4), 6, AllocateFewestSplits, "A4 C2", nil},
+		{"fewest splits needs fewer warehouses than priority", levels(1, 1, 1, 3), 3, AllocateFewestSplits, "D3", nil},
+
+		{"priority short of stock", levels(2, 5), 8, AllocatePriority, "", ErrInsufficientStock},
+		{"most stock short of stock", levels(2, 5), 8, AllocateMostStock, "", ErrInsufficientStock},
+		{"fewest splits short of stock", levels(2, 5), 8, AllocateFewestSplits, "", ErrInsufficientStock},
+		{"no stock", nil, 1, AllocatePriority, "", ErrInsufficientStock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picks, err := allocate(tt.levels, tt.quantity, tt.strategy)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			for i, p := range picks {
+				if i > 0 {
+					got += " "
+				}
+				got += fmt.Sprintf("%s%d", p.WarehouseName, p.Quantity)
+			}
+			if got != tt.want {
+				t.Errorf("got picks %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateKeepsLevels(t *testing.T) {
+	levels := []StockLevel{
+		{WarehouseID: uuid.New(), WarehouseName: "A", Quantity: 2},
+		{WarehouseID: uuid.New(), WarehouseName: "B", Quantity: 5},
+	}
+
+	picks, err := allocate(levels, 3, AllocateMostStock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if picks[0].WarehouseID != levels[1].WarehouseID {
+		t.Errorf("got first pick from %s, want %s", picks[0].WarehouseName, levels[1].WarehouseName)
+	}
+	if levels[0].WarehouseName != "A" || levels[0].Quantity != 2 || levels[1].Quantity != 5 {
+		t.Errorf("allocate changed the levels it was given: %+v", levels)
+	}
+}
+
+func TestAllocateUnknownStrategy(t *testing.T) {
+	if _, err := allocate(nil, 1, "nearest"); err == nil {
+		t.Fatal("got no error for an unknown strategy")
+	}
+}
@@ -0,0 +1,258 @@
+// Package tax works out the sales tax, such as VAT, due on prices from a
+// table of rates keyed by destination and product tax class.
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRate = errors.New("invalid tax rate")
+
+// Class groups products that are taxed alike, e.g. "standard", "reduced" or
+// "zero".
+type Class string
+
+// DefaultClass is the class of products not given another one.
+const DefaultClass Class = "standard"
+
+var (
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	percentPattern = regexp.MustCompile(`^\d{1,3}(\.\d{1,4})?$`)
+)
+
+// Destination is where goods are delivered to, which decides the tax due on
+// them.
+type Destination struct {
+	// Country is an ISO 3166-1 alpha-2 code, e.g. "GB".
+	Country string `json:"country"`
+	// Region is an ISO 3166-2 code, e.g. "US-CA", within Country. It only
+	// matters where a region is taxed differently from the rest of its
+	// country.
+	Region string `json:"region,omitempty"`
+}
+
+// Rate is an entry of the rate table: the percentage of tax charged on a
+// class of goods delivered to a country, or to one of its regions.
+type Rate struct {
+	ID      uuid.UUID `json:"id"`
+	Country string    `json:"country"`
+	// Region is empty for rates that apply to the whole country.
+	Region string `json:"region"`
+	Class  Class  `json:"tax_class"`
+	// Percent is the percentage charged, e.g. "20" or "7.25".
+	Percent string `json:"percent"`
+	// Name is the name the tax is known by, e.g. "VAT".
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ParsePercent reads a percentage between 0 and 100, with up to 4 decimal
+// places, and returns it as a share, e.g. 1/5 for "20".
+func ParsePercent(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !percentPattern.MatchString(s) || !ok || r.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, fmt.Errorf("%w: percent must be between 0 and 100, with up to 4 decimal places", ErrInvalidRate)
+	}
+
+	return r.Quo(r, big.NewRat(100, 1)), nil
+}
+
+// Normalize upper-cases the rate's country and region and writes its percent
+// in its shortest form, failing with ErrInvalidRate if any of them is
+// malformed.
+func (r *Rate) Normalize() error {
+	r.Country = strings.ToUpper(r.Country)
+	r.Region = strings.ToUpper(r.Region)
+
+	if !countryPattern.MatchString(r.Country) {
+		return fmt.Errorf("%w: country must be a two-letter country code", ErrInvalidRate)
+	}
+
+	if r.Region != "" && !strings.HasPrefix(r.Region, r.Country+"-") {
+		return fmt.Errorf("%w: region must be a code within %s, e.g. %s-XX", ErrInvalidRate, r.Country, r.Country)
+	}
+
+	share, err := ParsePercent(r.Percent)
+	if err != nil {
+		return err
+	}
+	r.Percent = formatPercent(share)
+
+	return nil
+}
+
+// formatPercent writes a share as a percentage, e.g. "20" for 1/5.
+func formatPercent(share *big.Rat) string {
+	if share.Sign() == 0 {
+		return "0"
+	}
+
+	return money.FormatRate(new(big.Rat).Mul(share, big.NewRat(100, 1)))
+}
+
+type tableKey struct {
+	country, region string
+	class           Class
+}
+
+// Table looks up the rate charged on goods by destination and class.
+type Table struct {
+	rates map[tableKey]*Rate
+}
+
+func NewTable(rates []Rate) *Table {
+	t := &Table{rates: make(map[tableKey]*Rate, len(rates))}
+	for i := range rates {
+		r := &rates[i]
+		t.rates[tableKey{r.Country, r.Region, r.Class}] = r
+	}
+
+	return t
+}
+
+// Lookup returns the rate charged on goods of class delivered to dest: the
+// destination region's rate if it has one, or else its country's. It returns
+// nil if neither has a rate for the class, in which case no tax is charged.
+func (t *Table) Lookup(dest Destination, class Class) *Rate {
+	country, region := strings.ToUpper(dest.Country), strings.ToUpper(dest.Region)
+
+	if region != "" {
+		if r, ok := t.rates[tableKey{country, region, class}]; ok {
+			return r
+		}
+	}
+
+	return t.rates[tableKey{country, "", class}]
+}
+
+// Line is a basket line to work out the tax on.
+type Line struct {
+	ProductID uuid.UUID
+	VariantID *uuid.UUID
+	Title     string
+	Quantity  int
+	UnitPrice money.Amount
+	Class     Class
+	// PriceIncludesTax says whether UnitPrice is gross of tax, as consumer
+	// prices are in the UK and EU, or net of it.
+	PriceIncludesTax bool
+}
+
+// LineQuote is the tax due on a basket line.
+type LineQuote struct {
+	ProductID        uuid.UUID    `json:"product_id"`
+	VariantID        *uuid.UUID   `json:"variant_id,omitempty"`
+	Title            string       `json:"title"`
+	Quantity         int          `json:"quantity"`
+	UnitPrice        money.Amount `json:"unit_price"`
+	Class            Class        `json:"tax_class"`
+	PriceIncludesTax bool         `json:"price_includes_tax"`
+	// RateID and Name identify the rate charged. They are unset when no tax
+	// is charged on the line because the table has no rate for it.
+	RateID  *uuid.UUID `json:"rate_id,omitempty"`
+	Name    string     `json:"name,omitempty"`
+	Percent string     `json:"percent"`
+	// Net, Tax and Gross are the line's price before tax, the tax and its
+	// price with tax.
+	Net   money.Amount `json:"net"`
+	Tax   money.Amount `json:"tax"`
+	Gross money.Amount `json:"gross"`
+}
+
+// Quote is the tax due on a basket delivered to a destination.
+type Quote struct {
+	Destination Destination  `json:"destination"`
+	Lines       []LineQuote  `json:"lines"`
+	Net         money.Amount `json:"net"`
+	Tax         money.Amount `json:"tax"`
+	Gross       money.Amount `json:"gross"`
+}
+
+// Quote works out the tax due on lines delivered to dest. Tax is worked out
+// and rounded half up to the minor unit line by line, so the totals are the
+// sums of the lines. All lines must be priced in the same currency.
+func (t *Table) Quote(dest Destination, lines []Line) (*Quote, error) {
+	var currency money.Currency
+	if len(lines) > 0 {
+		currency = lines[0].UnitPrice.Currency()
+	}
+
+	q := &Quote{
+		Destination: dest,
+		Lines:       make([]LineQuote, len(lines)),
+		Net:         money.Zero(currency),
+		Tax:         money.Zero(currency),
+		Gross:       money.Zero(currency),
+	}
+
+	for i, line := range lines {
+		lq, err := t.quoteLine(dest, line)
+		if err != nil {
+			return nil, err
+		}
+		q.Lines[i] = lq
+
+		if q.Net, err = q.Net.Add(lq.Net); err != nil {
+			return nil, err
+		}
+		if q.Tax, err = q.Tax.Add(lq.Tax); err != nil {
+			return nil, err
+		}
+		if q.Gross, err = q.Gross.Add(lq.Gross); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+func (t *Table) quoteLine(dest Destination, line Line) (LineQuote, error) {
+	lq := LineQuote{
+		ProductID:        line.ProductID,
+		VariantID:        line.VariantID,
+		Title:            line.Title,
+		Quantity:         line.Quantity,
+		UnitPrice:        line.UnitPrice,
+		Class:            line.Class,
+		PriceIncludesTax: line.PriceIncludesTax,
+		Percent:          "0",
+	}
+
+	price, err := line.UnitPrice.Mul(int64(line.Quantity))
+	if err != nil {
+		return LineQuote{}, err
+	}
+
+	share := new(big.Rat)
+	if rate := t.Lookup(dest, line.Class); rate != nil {
+		if share, err = ParsePercent(rate.Percent); err != nil {
+			return LineQuote{}, err
+		}
+		lq.RateID, lq.Name, lq.Percent = &rate.ID, rate.Name, formatPercent(share)
+	}
+
+	if line.PriceIncludesTax {
+		// The net price is the gross price divided by 1 + the rate. The tax
+		// is what is left, so net and tax always add up to the price.
+		lq.Gross = price
+		lq.Net = price.MulRat(new(big.Rat).Inv(new(big.Rat).Add(big.NewRat(1, 1), share)), money.RoundHalfUp)
+		lq.Tax, err = lq.Gross.Sub(lq.Net)
+	} else {
+		lq.Net = price
+		lq.Tax = price.MulRat(share, money.RoundHalfUp)
+		lq.Gross, err = lq.Net.Add(lq.Tax)
+	}
+	if err != nil {
+		return LineQuote{}, err
+	}
+
+	return lq, nil
+}
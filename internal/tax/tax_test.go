@@ -0,0 +1,183 @@
+package tax
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/seanhalberthal/webmart/internal/money"
+)
+
+func testTable() *Table {
+	return NewTable([]Rate{
+		{ID: uuid.New(), Country: "GB", Class: DefaultClass, Percent: "20", Name: "VAT"},
+		{ID: uuid.New(), Country: "GB", Class: "reduced", Percent: "5", Name: "VAT"},
+		{ID: uuid.New(), Country: "GB", Class: "zero", Percent: "0", Name: "VAT"},
+		{ID: uuid.New(), Country: "US", Class: DefaultClass, Percent: "6", Name: "Sales tax"},
+		{ID: uuid.New(), Country: "US", Region: "US-CA", Class: DefaultClass, Percent: "7.25", Name: "Sales tax"},
+	})
+}
+
+func TestQuoteLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		dest      Destination
+		class     Class
+		price     string
+		quantity  int
+		inclusive bool
+		percent   string
+		net       string
+		tax       string
+		gross     string
+	}{
+		{"inclusive", Destination{Country: "GB"}, DefaultClass, "12.00", 1, true, "20", "10.00", "2.00", "12.00"},
+		{"inclusive net rounded half up", Destination{Country: "GB"}, DefaultClass, "9.99", 1, true, "20", "8.33", "1.66", "9.99"},
+		{"inclusive net rounded down", Destination{Country: "GB"}, DefaultClass, "0.10", 1, true, "20", "0.08", "0.02", "0.10"},
+		{"inclusive by the line", Destination{Country: "GB"}, DefaultClass, "0.99", 3, true, "20", "2.48", "0.49", "2.97"},
+		{"inclusive reduced rate", Destination{Country: "GB"}, "reduced", "10.50", 1, true, "5", "10.00", "0.50", "10.50"},
+		{"inclusive zero rate", Destination{Country: "GB"}, "zero", "4.99", 2, true, "0", "9.98", "0.00", "9.98"},
+		{"exclusive", Destination{Country: "GB"}, DefaultClass, "10.00", 1, false, "20", "10.00", "2.00", "12.00"},
+		{"exclusive tax rounded half up", Destination{Country: "US", Region: "US-CA"}, DefaultClass, "10.00", 1, false, "7.25", "10.00", "0.73", "10.73"},
+		{"exclusive tax rounded down", Destination{Country: "US", Region: "US-CA"}, DefaultClass, "0.06", 1, false, "7.25", "0.06", "0.00", "0.06"},
+		{"exclusive by the line", Destination{Country: "GB"}, DefaultClass, "9.99", 2, false, "20", "19.98", "4.00", "23.98"},
+		{"region falls back to its country", Destination{Country: "US", Region: "US-NY"}, DefaultClass, "10.00", 1, false, "6", "10.00", "0.60", "10.60"},
+		{"destination is not case sensitive", Destination{Country: "us", Region: "us-ca"}, DefaultClass, "10.00", 1, false, "7.25", "10.00", "0.73", "10.73"},
+		{"no rate for the country", Destination{Country: "FR"}, DefaultClass, "10.00", 1, true, "0", "10.00", "0.00", "10.00"},
+		{"no rate for the class", Destination{Country: "US"}, "reduced", "10.00", 1, false, "0", "10.00", "0.00", "10.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := Line{
+				ProductID:        uuid.New(),
+				Quantity:         tt.quantity,
+				UnitPrice:        money.MustParse(tt.price, money.GBP),
+				Class:            tt.class,
+				PriceIncludesTax: tt.inclusive,
+			}
+
+			q, err := testTable().Quote(tt.dest, []Line{line})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lq := q.Lines[0]
+			if lq.Percent != tt.percent || lq.Net.String() != tt.net || lq.Tax.String() != tt.tax || lq.Gross.String() != tt.gross {
+				t.Errorf("got %s%%: %s + %s = %s, want %s%%: %s + %s = %s",
+					lq.Percent, lq.Net, lq.Tax, lq.Gross, tt.percent, tt.net, tt.tax, tt.gross)
+			}
+
+			if sum, err := lq.Net.Add(lq.Tax); err != nil || sum != lq.Gross {
+				t.Errorf("net %s and tax %s do not add up to gross %s", lq.Net, lq.Tax, lq.Gross)
+			}
+
+			if (lq.RateID == nil) != (tt.percent == "0" && tt.class != "zero") {
+				t.Errorf("got rate %v for %s%%", lq.RateID, lq.Percent)
+			}
+		})
+	}
+}
+
+func TestQuoteTotals(t *testing.T) {
+	lines := []Line{
+		{ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse("9.99", money.GBP), Class: DefaultClass, PriceIncludesTax: true},
+		{ProductID: uuid.New(), Quantity: 2, UnitPrice: money.MustParse("10.50", money.GBP), Class: "reduced", PriceIncludesTax: true},
+		{ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse("10.00", money.GBP), Class: DefaultClass},
+	}
+
+	q, err := testTable().Quote(Destination{Country: "GB"}, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8.33 + 1.66, 20.00 + 1.00 and 10.00 + 2.00.
+	if q.Net.String() != "38.33" || q.Tax.String() != "4.66" || q.Gross.String() != "42.99" {
+		t.Errorf("got %s + %s = %s, want 38.33 + 4.66 = 42.99", q.Net, q.Tax, q.Gross)
+	}
+	if q.Gross.Currency() != money.GBP {
+		t.Errorf("got totals in %s, want GBP", q.Gross.Currency())
+	}
+}
+
+func TestQuoteMixedCurrencies(t *testing.T) {
+	lines := []Line{
+		{ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse("10.00", money.GBP), Class: DefaultClass},
+		{ProductID: uuid.New(), Quantity: 1, UnitPrice: money.MustParse("10.00", money.EUR), Class: DefaultClass},
+	}
+
+	if _, err := testTable().Quote(Destination{Country: "GB"}, lines); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Fatalf("got error %v, want %v", err, money.ErrCurrencyMismatch)
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  bool
+	}{
+		{"20", "1/5", false},
+		{"7.25", "29/400", false},
+		{"0", "0/1", false},
+		{"100", "1/1", false},
+		{"12.3456", "1929/15625", false},
+		{"12.34567", "", true},
+		{"100.01", "", true},
+		{"-5", "", true},
+		{"1e2", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePercent(tt.s)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidRate) {
+				t.Errorf("ParsePercent(%q): got error %v, want %v", tt.s, err, ErrInvalidRate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePercent(%q): %v", tt.s, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParsePercent(%q): got %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		rate    Rate
+		want    Rate
+		invalid bool
+	}{
+		{Rate{Country: "gb", Percent: "20.00"}, Rate{Country: "GB", Percent: "20"}, false},
+		{Rate{Country: "us", Region: "us-ca", Percent: "7.250"}, Rate{Country: "US", Region: "US-CA", Percent: "7.25"}, false},
+		{Rate{Country: "GB", Percent: "0.0"}, Rate{Country: "GB", Percent: "0"}, false},
+		{Rate{Country: "GBR", Percent: "20"}, Rate{}, true},
+		{Rate{Country: "US", Region: "CA", Percent: "7"}, Rate{}, true},
+		{Rate{Country: "US", Region: "GB-CA", Percent: "7"}, Rate{}, true},
+		{Rate{Country: "GB", Percent: "101"}, Rate{}, true},
+	}
+
+	for _, tt := range tests {
+		r := tt.rate
+		err := r.Normalize()
+		if tt.invalid {
+			if !errors.Is(err, ErrInvalidRate) {
+				t.Errorf("Normalize(%+v): got error %v, want %v", tt.rate, err, ErrInvalidRate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Normalize(%+v): %v", tt.rate, err)
+			continue
+		}
+		if r.Country != tt.want.Country || r.Region != tt.want.Region || r.Percent != tt.want.Percent {
+			t.Errorf("Normalize(%+v): got %s %s %s, want %s %s %s",
+				tt.rate, r.Country, r.Region, r.Percent, tt.want.Country, tt.want.Region, tt.want.Percent)
+		}
+	}
+}